@@ -0,0 +1,237 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Scopes of the clients.
+const (
+	ScopeHTMLRender = "html:render"
+	ScopeDIANRender = "dian:render"
+	// ScopePDFProcess allows the endpoints that process existing PDFs, the
+	// renders of their parts need the scopes of the renders.
+	ScopePDFProcess = "pdf:process"
+	// ScopeAll allows every endpoint and the jobs of the other clients.
+	ScopeAll = "*"
+)
+
+// HeaderAPIKey is the header with the API key, it can also be sent as a
+// bearer token or, for the old clients, in the x-internalcode header.
+const HeaderAPIKey = "X-API-Key"
+
+// contextKeyClient is the echo.Context key of the authenticated client.
+const contextKeyClient = "client"
+
+var (
+	// ErrMissingCredentials is returned by an Authenticator when the request
+	// doesn't have its kind of credentials.
+	ErrMissingCredentials = errors.New("the credentials are missing")
+	ErrInvalidAPIKey      = errors.New("the API key is not valid")
+)
+
+// Authenticator returns the client of a request.
+type Authenticator interface {
+	Authenticate(req *http.Request) (Client, error)
+}
+
+// Authenticators tries every Authenticator, the request is authenticated by
+// the first one that accepts it.
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(req *http.Request) (Client, error) {
+	err := ErrMissingCredentials
+	for _, auth := range a {
+		client, authErr := auth.Authenticate(req)
+		if authErr == nil {
+			return client, nil
+		}
+		if !errors.Is(authErr, ErrMissingCredentials) {
+			err = authErr
+		}
+	}
+
+	return Client{}, err
+}
+
+// APIKey is a key of a client. Only the SHA-256 of the key is kept, the
+// keys are random strings so they don't need a slow hash.
+type APIKey struct {
+	Name   string   `json:"name"`
+	Hash   string   `json:"key_sha256"`
+	Scopes []string `json:"scopes"`
+}
+
+// Client is the caller of a request.
+type Client struct {
+	Name   string
+	Scopes []string
+}
+
+// Has reports whether the client has the scope, ScopeAll has all of them.
+func (c Client) Has(scope string) bool {
+	return scope == "" || slices.Contains(c.Scopes, scope) || slices.Contains(c.Scopes, ScopeAll)
+}
+
+type clientKey struct{}
+
+// ContextWithClient returns a context with the authenticated client.
+func ContextWithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the authenticated client, if any.
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(clientKey{}).(Client)
+	return client, ok
+}
+
+// clientName is the name of the client for the logs and metrics.
+func clientName(ctx context.Context) string {
+	client, ok := ClientFromContext(ctx)
+	if !ok {
+		return "anonymous"
+	}
+
+	return client.Name
+}
+
+// HashAPIKey returns the hash of a key to save it in the keys file.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadAPIKeys reads a JSON file with a list of APIKey.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the API keys: %w", err)
+	}
+
+	var keys []APIKey
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// APIKeys authenticates the requests with the keys of the clients.
+type APIKeys struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	hash   []byte
+	client Client
+}
+
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	a := &APIKeys{}
+	names := map[string]bool{}
+	for _, key := range keys {
+		hash, err := hex.DecodeString(key.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("the hash of the API key %q must be a hex SHA-256", key.Name)
+		}
+		if key.Name == "" || names[key.Name] {
+			return nil, fmt.Errorf("the API key %q must have a unique name", key.Name)
+		}
+		names[key.Name] = true
+
+		a.keys = append(a.keys, apiKey{hash: hash, client: Client{Name: key.Name, Scopes: key.Scopes}})
+	}
+
+	return a, nil
+}
+
+// Authenticate returns the client of the API key of the request.
+func (a *APIKeys) Authenticate(req *http.Request) (Client, error) {
+	return a.Lookup(apiKeyFromRequest(req))
+}
+
+// Lookup returns the client of the key. Every key is compared in constant
+// time, so the time doesn't tell which one was close.
+func (a *APIKeys) Lookup(key string) (Client, error) {
+	if key == "" {
+		return Client{}, ErrMissingCredentials
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	found := -1
+	for i, k := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return Client{}, ErrInvalidAPIKey
+	}
+
+	return a.keys[found].client, nil
+}
+
+// RequireScope authenticates the request and checks the scope of the
+// client, an empty scope only authenticates. It responds 401 without valid
+// credentials and 403 without the scope. A nil Authenticator doesn't
+// authenticate.
+func RequireScope(auth Authenticator, scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if auth == nil {
+				return next(c)
+			}
+
+			client, err := auth.Authenticate(c.Request())
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="gohtmltopdf"`)
+				return errorResponse(c, http.StatusUnauthorized, "can't authenticate the request", err)
+			}
+
+			req := c.Request()
+			ctx := ContextWithClient(req.Context(), client)
+			ctx = ContextWithLogger(ctx, LoggerFromContext(ctx).With("client", client.Name))
+			c.SetRequest(req.WithContext(ctx))
+			c.Set(contextKeyClient, client.Name)
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("enduser.id", client.Name))
+
+			if !client.Has(scope) {
+				return errorResponse(c, http.StatusForbidden, "can't authorize the request", fmt.Errorf("the client doesn't have the scope %q", scope))
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// apiKeyFromRequest returns the key of the X-API-Key header, the bearer
+// token or the x-internalcode header. A bearer JWT isn't an API key.
+func apiKeyFromRequest(req *http.Request) string {
+	if key := req.Header.Get(HeaderAPIKey); key != "" {
+		return key
+	}
+	if token, ok := bearerToken(req); ok && !isJWT(token) {
+		return token
+	}
+
+	return req.Header.Get(ParamInternalCode)
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	return strings.CutPrefix(req.Header.Get(echo.HeaderAuthorization), "Bearer ")
+}
@@ -0,0 +1,147 @@
+package gohtmltopdf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// testAPIKeys has the key "secret" with all the scopes, it's sent in the
+// x-internalcode header by the tests.
+func testAPIKeys() *APIKeys {
+	auth, _ := NewAPIKeys([]APIKey{{Name: "test", Hash: HashAPIKey("secret"), Scopes: []string{ScopeAll}}})
+	return auth
+}
+
+func TestAPIKeys_Authenticate(t *testing.T) {
+	auth, err := NewAPIKeys([]APIKey{
+		{Name: "payroll", Hash: HashAPIKey("payroll-key"), Scopes: []string{ScopeDIANRender}},
+		{Name: "web", Hash: HashAPIKey("web-key"), Scopes: []string{ScopeHTMLRender}},
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the keys: %v", err)
+	}
+
+	client, err := auth.Lookup("web-key")
+	if err != nil || client.Name != "web" || !client.Has(ScopeHTMLRender) || client.Has(ScopeDIANRender) {
+		t.Errorf("Authenticate() = %+v, %v", client, err)
+	}
+	if _, err := auth.Lookup("other"); err != ErrInvalidAPIKey {
+		t.Errorf("err = %v, want %v", err, ErrInvalidAPIKey)
+	}
+	if _, err := auth.Lookup(""); err != ErrMissingCredentials {
+		t.Errorf("err = %v, want %v", err, ErrMissingCredentials)
+	}
+}
+
+func TestNewAPIKeys_invalid(t *testing.T) {
+	tests := map[string][]APIKey{
+		"plain key":      {{Name: "a", Hash: "secret"}},
+		"duplicate name": {{Name: "a", Hash: HashAPIKey("1")}, {Name: "a", Hash: HashAPIKey("2")}},
+		"without name":   {{Hash: HashAPIKey("1")}},
+	}
+	for name, keys := range tests {
+		if _, err := NewAPIKeys(keys); err == nil {
+			t.Errorf("%s: the keys must be rejected", name)
+		}
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `[{"name": "payroll", "key_sha256": "` + HashAPIKey("k") + `", "scopes": ["dian:render"]}]`
+	_ = os.WriteFile(path, []byte(content), 0o600)
+
+	keys, err := LoadAPIKeys(path)
+	if err != nil || len(keys) != 1 || keys[0].Name != "payroll" || keys[0].Scopes[0] != ScopeDIANRender {
+		t.Errorf("LoadAPIKeys() = %+v, %v", keys, err)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	auth, _ := NewAPIKeys([]APIKey{
+		{Name: "payroll", Hash: HashAPIKey("payroll-key"), Scopes: []string{ScopeDIANRender}},
+		{Name: "web", Hash: HashAPIKey("web-key"), Scopes: []string{ScopeHTMLRender}},
+	})
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	jobs := NewJobs(gen, JobsConfig{})
+	e := echo.New()
+	Router(e, RouterConfig{Auth: auth, Generator: gen, Jobs: jobs})
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		header string
+		key    string
+		want   int
+	}{
+		{"without key", "/html-to-pdf", `{"data": "<p>a</p>"}`, "", "", http.StatusUnauthorized},
+		{"invalid key", "/html-to-pdf", `{"data": "<p>a</p>"}`, HeaderAPIKey, "nope", http.StatusUnauthorized},
+		{"api key header", "/html-to-pdf", `{"data": "<p>a</p>"}`, HeaderAPIKey, "web-key", http.StatusOK},
+		{"bearer", "/html-to-pdf", `{"data": "<p>a</p>"}`, echo.HeaderAuthorization, "Bearer web-key", http.StatusOK},
+		{"internal code header", "/html-to-pdf", `{"data": "<p>a</p>"}`, ParamInternalCode, "web-key", http.StatusOK},
+		{"without scope", "/dian-form-220", `{"data": [{"year": 2022, "rows": {}}]}`, HeaderAPIKey, "web-key", http.StatusForbidden},
+		{"job without scope", "/jobs", `{"type": "dian-form-220", "request": {"data": [{"year": 2022, "rows": {}}]}}`, HeaderAPIKey, "web-key", http.StatusForbidden},
+		{"job with scope", "/jobs", `{"type": "dian-form-220", "request": {"data": [{"year": 2022, "rows": {}}]}}`, HeaderAPIKey, "payroll-key", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get(echo.HeaderWWWAuthenticate) == "" {
+				t.Errorf("the 401 must have the WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestJobs_client(t *testing.T) {
+	auth, _ := NewAPIKeys([]APIKey{
+		{Name: "payroll", Hash: HashAPIKey("payroll-key"), Scopes: []string{ScopeDIANRender}},
+		{Name: "web", Hash: HashAPIKey("web-key"), Scopes: []string{ScopeHTMLRender}},
+		{Name: "admin", Hash: HashAPIKey("admin-key"), Scopes: []string{ScopeAll}},
+	})
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	jobs := NewJobs(gen, JobsConfig{})
+	e := echo.New()
+	Router(e, RouterConfig{Auth: auth, Generator: gen, Jobs: jobs})
+
+	request := func(method, path, body, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderAPIKey, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodPost, "/jobs", `{"type": "html-to-pdf", "request": {"data": "<p>a</p>"}}`, "web-key")
+	job := Job{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+	if job.Client != "web" {
+		t.Errorf("client = %q, want %q", job.Client, "web")
+	}
+
+	// The jobs of other clients aren't visible, only with all the scopes.
+	for key, want := range map[string]int{"web-key": http.StatusOK, "payroll-key": http.StatusNotFound, "admin-key": http.StatusOK} {
+		if rec := request(http.MethodGet, "/jobs/"+job.ID, "", key); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", key, rec.Code, want)
+		}
+	}
+}
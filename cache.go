@@ -0,0 +1,386 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderCache tells if the response comes from the cache: HIT or MISS.
+const HeaderCache = "X-Cache"
+
+const (
+	CacheHit  = "HIT"
+	CacheMiss = "MISS"
+
+	DefaultCacheMemoryBytes = 64 << 20
+	DefaultCacheTTL         = 24 * time.Hour
+)
+
+// CacheConfig are the limits of the cache. Without a Dir there isn't a disk
+// tier.
+type CacheConfig struct {
+	MaxMemoryBytes int64
+	Dir            string
+	MaxDiskBytes   int64
+	TTL            time.Duration
+	// DiskSensitive saves the sensitive documents, like the DIAN forms, in
+	// the disk tier too. The files aren't encrypted, so by default they are
+	// only kept in memory.
+	DiskSensitive bool
+}
+
+// CacheEntry is a cached document with the report of its optimization, so
+// a hit responds with the same headers as the render.
+type CacheEntry struct {
+	Data         []byte         `json:"-"`
+	Optimization OptimizeReport `json:"optimization"`
+	// Sensitive documents aren't saved on disk unless the config allows it.
+	Sensitive bool `json:"-"`
+}
+
+type cacheItem struct {
+	key       string
+	entry     CacheEntry
+	createdAt time.Time
+}
+
+type diskItem struct {
+	size      int64
+	createdAt time.Time
+	usedAt    time.Time
+}
+
+// Cache keeps the rendered documents by the hash of their input, so an
+// identical request doesn't render again. The recently used documents are
+// in memory and the documents that aren't sensitive are on disk, both tiers
+// evict the least recently used documents when they are full. A nil *Cache
+// doesn't cache.
+type Cache struct {
+	maxMemory     int64
+	maxDisk       int64
+	dir           string
+	ttl           time.Duration
+	diskSensitive bool
+
+	mu         sync.Mutex
+	memory     map[string]*list.Element
+	lru        *list.List
+	memorySize int64
+	disk       map[string]diskItem
+	diskSize   int64
+}
+
+// NewCache creates the cache, the documents of a previous run in the
+// directory are reused.
+func NewCache(cfg CacheConfig) (*Cache, error) {
+	if cfg.MaxMemoryBytes <= 0 {
+		cfg.MaxMemoryBytes = DefaultCacheMemoryBytes
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultCacheTTL
+	}
+
+	c := &Cache{
+		maxMemory:     cfg.MaxMemoryBytes,
+		maxDisk:       cfg.MaxDiskBytes,
+		dir:           cfg.Dir,
+		ttl:           cfg.TTL,
+		diskSensitive: cfg.DiskSensitive,
+		memory:        map[string]*list.Element{},
+		lru:           list.New(),
+		disk:          map[string]diskItem{},
+	}
+	if c.dir == "" {
+		return c, nil
+	}
+
+	err := os.MkdirAll(c.dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the cache directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the cache directory: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		c.disk[entry.Name()] = diskItem{size: info.Size(), createdAt: info.ModTime(), usedAt: info.ModTime()}
+		c.diskSize += info.Size()
+	}
+	c.evictDisk()
+
+	return c, nil
+}
+
+// Get returns the document of the key, a document found on disk is moved to
+// memory. An empty key is never cached.
+func (c *Cache) Get(key string) (CacheEntry, bool) {
+	if c == nil || key == "" {
+		return CacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.memory[key]; ok {
+		item := element.Value.(*cacheItem)
+		if time.Since(item.createdAt) < c.ttl {
+			c.lru.MoveToFront(element)
+			return item.entry, true
+		}
+		c.removeMemory(element)
+	}
+
+	item, ok := c.disk[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if time.Since(item.createdAt) >= c.ttl {
+		c.removeDisk(key)
+		return CacheEntry{}, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		c.removeDisk(key)
+		return CacheEntry{}, false
+	}
+	entry := decodeCacheFile(data)
+
+	item.usedAt = time.Now()
+	c.disk[key] = item
+	c.addMemory(key, entry, item.createdAt)
+
+	return entry, true
+}
+
+// Set saves the document in memory and on disk. A document bigger than a
+// tier isn't saved in it, and the sensitive ones are only on disk when the
+// config allows it.
+func (c *Cache) Set(key string, entry CacheEntry) {
+	if c == nil || key == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if element, ok := c.memory[key]; ok {
+		c.removeMemory(element)
+	}
+	c.addMemory(key, entry, now)
+
+	if c.dir == "" || (entry.Sensitive && !c.diskSensitive) {
+		return
+	}
+	data := encodeCacheFile(entry)
+	if c.maxDisk > 0 && int64(len(data)) > c.maxDisk {
+		return
+	}
+
+	path := filepath.Join(c.dir, key)
+	err := os.WriteFile(path+".tmp", data, 0o600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return
+	}
+
+	if previous, ok := c.disk[key]; ok {
+		c.diskSize -= previous.size
+	}
+	c.disk[key] = diskItem{size: int64(len(data)), createdAt: now, usedAt: now}
+	c.diskSize += int64(len(data))
+	c.evictDisk()
+}
+
+// encodeCacheFile writes the entry as a line with its JSON followed by the
+// document, the documents that weren't optimized don't have the line.
+func encodeCacheFile(entry CacheEntry) []byte {
+	if entry.Optimization.Documents == 0 {
+		return entry.Data
+	}
+	header, _ := json.Marshal(entry)
+
+	return append(append(header, '\n'), entry.Data...)
+}
+
+// decodeCacheFile reads a file of encodeCacheFile, the documents start with
+// %PDF so they can't be confused with the line.
+func decodeCacheFile(data []byte) CacheEntry {
+	entry := CacheEntry{}
+	header, document, ok := bytes.Cut(data, []byte("\n"))
+	if !ok || !bytes.HasPrefix(header, []byte("{")) || json.Unmarshal(header, &entry) != nil {
+		return CacheEntry{Data: data}
+	}
+	entry.Data = document
+
+	return entry
+}
+
+// Start removes the expired documents every minute, until the context is
+// done. Without it, an expired document is only removed when its key is
+// requested again.
+func (c *Cache) Start(ctx context.Context) {
+	if c == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.removeExpired(now)
+			}
+		}
+	}()
+}
+
+// removeExpired removes the documents of both tiers created more than the
+// ttl before the time.
+func (c *Cache) removeExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, element := range c.memory {
+		if now.Sub(element.Value.(*cacheItem).createdAt) >= c.ttl {
+			c.removeMemory(element)
+		}
+	}
+	for key, item := range c.disk {
+		if now.Sub(item.createdAt) >= c.ttl {
+			c.removeDisk(key)
+		}
+	}
+}
+
+func (c *Cache) addMemory(key string, entry CacheEntry, createdAt time.Time) {
+	if int64(len(entry.Data)) > c.maxMemory {
+		return
+	}
+
+	c.memory[key] = c.lru.PushFront(&cacheItem{key: key, entry: entry, createdAt: createdAt})
+	c.memorySize += int64(len(entry.Data))
+	for c.memorySize > c.maxMemory {
+		c.removeMemory(c.lru.Back())
+	}
+}
+
+func (c *Cache) removeMemory(element *list.Element) {
+	item := c.lru.Remove(element).(*cacheItem)
+	delete(c.memory, item.key)
+	c.memorySize -= int64(len(item.entry.Data))
+}
+
+// evictDisk removes the least recently used documents until the disk tier
+// fits its limit.
+func (c *Cache) evictDisk() {
+	if c.maxDisk <= 0 || c.diskSize <= c.maxDisk {
+		return
+	}
+
+	keys := make([]string, 0, len(c.disk))
+	for key := range c.disk {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool { return c.disk[keys[a]].usedAt.Before(c.disk[keys[b]].usedAt) })
+
+	for _, key := range keys {
+		if c.diskSize <= c.maxDisk {
+			return
+		}
+		c.removeDisk(key)
+	}
+}
+
+func (c *Cache) removeDisk(key string) {
+	_ = os.Remove(filepath.Join(c.dir, key))
+	c.diskSize -= c.disk[key].size
+	delete(c.disk, key)
+}
+
+// RenderCacheKey is the hash of a render of HTML. The backend must be the
+// name of the backend that renders it, not the one of the request that can
+// be empty.
+func RenderCacheKey(backend string, options RenderOptions, input []byte) string {
+	options.Backend = backend
+	options.PageSize = strings.ToUpper(options.PageSize)
+	options.Orientation = strings.ToLower(options.Orientation)
+	normalized, _ := json.Marshal(options)
+
+	return cacheKey(EndpointHTMLToPDF, normalized, input)
+}
+
+// DIANCacheKey is the hash of the DIAN forms. The rows are normalized so the
+// order of their keys doesn't matter, and the timestamps of the records are
+// ignored because they aren't in the document.
+func DIANCacheKey(data DIANForms220Relation) string {
+	return cacheKey(EndpointDIANForm220, nil, dianCacheInput(data))
+}
+
+// dianCacheKey is the hash of the DIAN forms of the request and the options
+// that change the document, like the watermarks.
+func dianCacheKey(req requestDIANForm220) string {
+	if req.Metadata == nil && len(req.Watermarks) == 0 && req.Optimize == nil && req.Profile == "" {
+		return DIANCacheKey(req.Data)
+	}
+
+	options, _ := json.Marshal(struct {
+		Metadata   *Metadata     `json:"metadata,omitempty"`
+		Watermarks []Watermark   `json:"watermarks,omitempty"`
+		Optimize   *Optimization `json:"optimize,omitempty"`
+		Profile    string        `json:"profile,omitempty"`
+	}{req.Metadata, req.Watermarks, req.Optimize, strings.ToLower(req.Profile)})
+
+	return cacheKey(EndpointDIANForm220, options, dianCacheInput(req.Data))
+}
+
+// dianCacheInput returns the normalized JSON of the forms.
+func dianCacheInput(data DIANForms220Relation) []byte {
+	normalized := make(DIANForms220Relation, len(data))
+	for i, item := range data {
+		item.CreatedAt = time.Time{}
+		item.UpdatedAt = time.Time{}
+
+		var rows any
+		if json.Unmarshal(item.Records, &rows) == nil {
+			item.Records, _ = json.Marshal(rows)
+		}
+		normalized[i] = item
+	}
+	input, _ := json.Marshal(normalized)
+
+	return input
+}
+
+// cacheKey hashes the parts with their length, so they can't be confused.
+func cacheKey(kind string, options, input []byte) string {
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(kind), options, input} {
+		fmt.Fprintf(h, "%d:", len(part))
+		h.Write(part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
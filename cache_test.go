@@ -0,0 +1,206 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCache_memory(t *testing.T) {
+	cache, err := NewCache(CacheConfig{MaxMemoryBytes: 10})
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the cache: %v", err)
+	}
+
+	cache.Set("a", CacheEntry{Data: []byte("aaaa")})
+	cache.Set("b", CacheEntry{Data: []byte("bbbb")})
+	// a is the most recently used, b is evicted by c.
+	cache.Get("a")
+	cache.Set("c", CacheEntry{Data: []byte("cccc")})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("b must be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%s must be in the cache", key)
+		}
+	}
+
+	cache.Set("big", CacheEntry{Data: []byte("more than ten bytes")})
+	if _, ok := cache.Get("big"); ok {
+		t.Errorf("a document bigger than the cache must not be saved")
+	}
+}
+
+func TestCache_ttl(t *testing.T) {
+	cache, _ := NewCache(CacheConfig{TTL: time.Millisecond, Dir: t.TempDir()})
+	cache.Set("a", CacheEntry{Data: []byte("aaaa")})
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("the document must expire")
+	}
+}
+
+func TestCache_removeExpired(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := NewCache(CacheConfig{TTL: time.Hour, Dir: dir})
+	cache.Set("a", CacheEntry{Data: []byte("%PDF-a")})
+
+	cache.removeExpired(time.Now())
+	if _, err := os.Stat(filepath.Join(dir, "a")); err != nil {
+		t.Fatalf("a document that didn't expire must be kept: %v", err)
+	}
+
+	cache.removeExpired(time.Now().Add(2 * time.Hour))
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("the expired document must be removed from the disk: %v", err)
+	}
+	if len(cache.memory) != 0 || cache.memorySize != 0 || cache.diskSize != 0 {
+		t.Errorf("the expired document must be removed from the tiers")
+	}
+}
+
+func TestCache_disk(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := NewCache(CacheConfig{MaxMemoryBytes: 4, Dir: dir, MaxDiskBytes: 8})
+	cache.Set("a", CacheEntry{Data: []byte("aaaa")})
+	cache.Set("b", CacheEntry{Data: []byte("bbbb")})
+
+	// a isn't in memory anymore, it's read from disk.
+	data, ok := cache.Get("a")
+	if !ok || string(data.Data) != "aaaa" {
+		t.Fatalf("Get(a) = %q, %v", data.Data, ok)
+	}
+
+	// b is the least recently used on disk.
+	cache.Set("c", CacheEntry{Data: []byte("cccc")})
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("b must be evicted from the disk")
+	}
+
+	// A new cache reuses the documents of the directory.
+	cache, err := NewCache(CacheConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the cache: %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%s must be on disk", key)
+		}
+	}
+}
+
+func TestCache_sensitive(t *testing.T) {
+	dir := t.TempDir()
+	report := OptimizeReport{Documents: 1, SizeBefore: 10, SizeAfter: 4, Linearized: 1}
+	cache, _ := NewCache(CacheConfig{Dir: dir})
+	cache.Set("form", CacheEntry{Data: []byte("%PDF-form"), Optimization: report, Sensitive: true})
+	cache.Set("html", CacheEntry{Data: []byte("%PDF-html"), Optimization: report})
+
+	if _, err := os.Stat(filepath.Join(dir, "form")); !os.IsNotExist(err) {
+		t.Errorf("the sensitive document must not be on disk: %v", err)
+	}
+	if entry, ok := cache.Get("form"); !ok || string(entry.Data) != "%PDF-form" {
+		t.Errorf("the sensitive document must be in memory")
+	}
+
+	// The report is saved with the document on disk.
+	cache, _ = NewCache(CacheConfig{Dir: dir, DiskSensitive: true})
+	entry, ok := cache.Get("html")
+	if !ok || string(entry.Data) != "%PDF-html" || entry.Optimization != report {
+		t.Errorf("Get(html) = %q, %+v, %v", entry.Data, entry.Optimization, ok)
+	}
+	cache.Set("form", CacheEntry{Data: []byte("%PDF-form"), Sensitive: true})
+	if _, err := os.Stat(filepath.Join(dir, "form")); err != nil {
+		t.Errorf("the sensitive document must be on disk with DiskSensitive: %v", err)
+	}
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	margin := 10.0
+	key := RenderCacheKey(BackendMaroto, RenderOptions{PageSize: "a4", Orientation: "Portrait", MarginTop: &margin}, []byte("<p>a</p>"))
+
+	same := RenderCacheKey(BackendMaroto, RenderOptions{PageSize: "A4", Orientation: "portrait", MarginTop: &margin}, []byte("<p>a</p>"))
+	if key != same {
+		t.Errorf("the case of the options must not change the key")
+	}
+
+	different := []string{
+		RenderCacheKey(BackendWKHTMLToPDF, RenderOptions{PageSize: "A4", Orientation: "portrait", MarginTop: &margin}, []byte("<p>a</p>")),
+		RenderCacheKey(BackendMaroto, RenderOptions{PageSize: "A4", Orientation: "portrait"}, []byte("<p>a</p>")),
+		RenderCacheKey(BackendMaroto, RenderOptions{PageSize: "A4", Orientation: "portrait", MarginTop: &margin}, []byte("<p>b</p>")),
+	}
+	for i, d := range different {
+		if d == key {
+			t.Errorf("the key %d must be different", i)
+		}
+	}
+}
+
+func TestDIANCacheKey(t *testing.T) {
+	item := DIANForm220Relation{DIANForm220: DIANForm220{Year: 2022, Records: json.RawMessage(`{"36": 1, "37": 2}`)}, IdentificationNumber: "1"}
+	key := DIANCacheKey(DIANForms220Relation{item})
+
+	item.Records = json.RawMessage(`{ "37": 2, "36": 1 }`)
+	item.UpdatedAt = time.Now()
+	if DIANCacheKey(DIANForms220Relation{item}) != key {
+		t.Errorf("the order of the rows and the timestamps must not change the key")
+	}
+
+	item.IdentificationNumber = "2"
+	if DIANCacheKey(DIANForms220Relation{item}) == key {
+		t.Errorf("another employee must change the key")
+	}
+}
+
+func TestHandler_cache(t *testing.T) {
+	cache, _ := NewCache(CacheConfig{})
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen, Cache: cache})
+
+	requests := []struct {
+		path string
+		body string
+		want string
+	}{
+		{"/html-to-pdf", `{"data": "<h1>Hola</h1>"}`, CacheMiss},
+		{"/html-to-pdf", `{"data": "<h1>Hola</h1>", "options": {"backend": "maroto"}}`, CacheHit},
+		{"/html-to-pdf", `{"data": "<h1>Hola</h1>", "no_cache": true}`, CacheMiss},
+		{"/dian-form-220", `{"data": [{"year": 2022, "rows": {}}]}`, CacheMiss},
+		{"/dian-form-220", `{"data": [{"year": 2022, "rows": {}}]}`, CacheHit},
+	}
+	var pdf []byte
+	for _, r := range requests {
+		rec := doJSON(e, http.MethodPost, r.path, r.body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := rec.Header().Get(HeaderCache); got != r.want {
+			t.Errorf("%s %s: %s = %q, want %q", r.path, r.body, HeaderCache, got, r.want)
+		}
+
+		resp := map[string][]byte{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		if r.want == CacheHit && !bytes.Equal(resp["data"], pdf) {
+			t.Errorf("the cached PDF must be the previous one")
+		}
+		pdf = resp["data"]
+	}
+
+	// The hits have the headers of the optimization.
+	for _, want := range []string{CacheMiss, CacheHit} {
+		rec := doJSON(e, http.MethodPost, "/dian-form-220", `{"data": [{"year": 2022, "rows": {}}], "optimize": {}}`)
+		if rec.Header().Get(HeaderCache) != want || rec.Header().Get(HeaderOptimizedSize) == "" || rec.Header().Get(HeaderLinearized) == "" {
+			t.Errorf("%s: headers = %v, want the optimization", want, rec.Header())
+		}
+	}
+}
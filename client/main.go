@@ -72,12 +72,13 @@ func main() {
 
 	config := parseEnvToConfig()
 
-	url := fmt.Sprintf("http://localhost:%s/html-to-pdf/%s", config.port, config.internalCode)
+	url := fmt.Sprintf("http://localhost:%s/html-to-pdf", config.port)
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(data))
 	if err != nil {
 		log.Fatalf("error creating the request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", config.internalCode)
 
 	client := http.Client{}
 	resp, err := client.Do(req)
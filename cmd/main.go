@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
@@ -13,13 +21,84 @@ import (
 )
 
 const (
-	InternalCodeKey = "INTERNAL_CODE"
-	PortKey         = "HTTP_PORT"
+	InternalCodeKey  = "INTERNAL_CODE"
+	APIKeysFileKey   = "API_KEYS_FILE"
+	JWKSFileKey      = "JWT_JWKS_FILE"
+	JWKSURLKey       = "JWT_JWKS_URL"
+	JWTIssuerKey     = "JWT_ISSUER"
+	JWTAudienceKey   = "JWT_AUDIENCE"
+	JWTScopeClaimKey = "JWT_SCOPE_CLAIM"
+	JWTClientKey     = "JWT_CLIENT_CLAIM"
+	PortKey          = "HTTP_PORT"
+	RenderBackendKey = "RENDER_BACKEND"
+	WKHTMLToPDFKey   = "WKHTMLTOPDF_PATH"
+	LogLevelKey      = "LOG_LEVEL"
+	OTLPEndpointKey  = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	ConcurrencyKey   = "RENDER_CONCURRENCY"
+	JobTTLKey        = "JOB_TTL"
+	JobTimeoutKey    = "JOB_TIMEOUT"
+	JobQueueSizeKey  = "JOB_QUEUE_SIZE"
+	WebhookSecretKey = "WEBHOOK_SECRET"
+	WebhookInlineKey = "WEBHOOK_INLINE_LIMIT"
+	PublicURLKey     = "PUBLIC_URL"
+	JobStoreDirKey   = "JOB_STORE_DIR"
+	StorageKey       = "STORAGE"
+	StorageDirKey    = "STORAGE_DIR"
+	StorageSecretKey = "STORAGE_SECRET"
+	ResultURLTTLKey  = "RESULT_URL_TTL"
+	RetentionKey     = "STORAGE_RETENTION"
+	S3EndpointKey    = "S3_ENDPOINT"
+	S3RegionKey      = "S3_REGION"
+	S3BucketKey      = "S3_BUCKET"
+	S3AccessKeyKey   = "S3_ACCESS_KEY"
+	S3SecretKeyKey   = "S3_SECRET_KEY"
+	S3InsecureKey    = "S3_INSECURE"
+	CacheKey         = "CACHE"
+	CacheMemoryKey   = "CACHE_MEMORY_BYTES"
+	CacheDirKey      = "CACHE_DIR"
+	CacheDiskKey     = "CACHE_DISK_BYTES"
+	CacheTTLKey      = "CACHE_TTL"
+	CacheDiskDIANKey = "CACHE_DISK_DIAN"
+	RateLimitsKey    = "RATE_LIMITS_FILE"
+	QuotaStoreDirKey = "QUOTA_STORE_DIR"
+	KeystoreKey      = "SIGNING_KEYSTORE"
+	KeystorePassKey  = "SIGNING_KEYSTORE_PASSWORD"
+	KeystoresFileKey = "SIGNING_KEYSTORES_FILE"
+	TSAURLKey        = "TSA_URL"
+	TrustedProxyKey  = "TRUSTED_PROXIES"
 )
 
 type Config struct {
-	internalCode string
-	port         string
+	internalCode  string
+	apiKeysFile   string
+	jwt           gohtmltopdf.JWTConfig
+	port          string
+	renderBackend string
+	wkhtmltopdf   string
+	logLevel      slog.Level
+	otlpEndpoint  string
+	concurrency   int
+	jobTTL        time.Duration
+	jobTimeout    time.Duration
+	jobQueueSize  int
+	webhookSecret string
+	webhookInline int
+	publicURL     string
+	jobStoreDir   string
+	storage       string
+	storageDir    string
+	storageSecret string
+	resultURLTTL  time.Duration
+	retention     time.Duration
+	s3            gohtmltopdf.S3Config
+	cache         bool
+	cacheConfig   gohtmltopdf.CacheConfig
+	rateLimits    string
+	quotaStoreDir string
+	keystore      gohtmltopdf.SigningKeystore
+	keystoresFile string
+	tsaURL        string
+	proxies       string
 }
 
 func main() {
@@ -28,18 +107,285 @@ func main() {
 
 	err := loadEnvs(*envFilePath)
 	if err != nil {
-		log.Fatalf("Couldn´t read de env file in %q path, error: %v", *envFilePath, err)
+		fatal("Couldn´t read de env file", "path", *envFilePath, "error", err)
 	}
 
 	config := parseEnvToConfig()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: config.logLevel})))
+
+	shutdownTracing, err := gohtmltopdf.SetupTracing(context.Background(), config.otlpEndpoint, "gohtmltopdf")
+	if err != nil {
+		fatal("Couldn´t configure the tracing", "error", err)
+	}
+
+	signers, err := newSigners(config)
+	if err != nil {
+		fatal("Couldn´t read the signing keystores", "error", err)
+	}
+
+	metrics := gohtmltopdf.NewMetrics()
+	genOpts := []gohtmltopdf.GeneratorOption{
+		gohtmltopdf.WithBinaryPath(config.wkhtmltopdf),
+		gohtmltopdf.WithMetrics(metrics),
+		gohtmltopdf.WithSigners(signers),
+	}
+	if config.renderBackend != "" {
+		genOpts = append(genOpts, gohtmltopdf.WithDefaultBackend(config.renderBackend))
+	}
+	gen := gohtmltopdf.NewGenerator(genOpts...)
+	defaultBackend, err := gen.Backend("")
+	if err != nil {
+		fatal("Couldn´t configure the render backend", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	capabilities := gen.DetectCapabilities(ctx)
+	cancel()
+	for name, caps := range capabilities {
+		slog.Info("backend detected", "backend", name, "available", caps.Available, "version", caps.Version, "features", caps.Features, "error", caps.Error)
+	}
+	if !capabilities[defaultBackend.Name()].Available {
+		fatal("The default render backend is not available", "backend", defaultBackend.Name(), "error", capabilities[defaultBackend.Name()].Error)
+	}
+
+	limiter, closeLimiter, err := newLimiter(config, metrics)
+	if err != nil {
+		fatal("Couldn´t configure the rate limits", "error", err)
+	}
+	defer closeLimiter()
+
+	pool := gohtmltopdf.NewPool(config.concurrency)
+	jobsConfig := gohtmltopdf.JobsConfig{
+		Pool:      pool,
+		Metrics:   metrics,
+		TTL:       config.jobTTL,
+		Timeout:   config.jobTimeout,
+		QueueSize: config.jobQueueSize,
+		Limiter:   limiter,
+		Signers:   signers,
+	}
+	if config.webhookSecret != "" {
+		jobsConfig.Webhooks = gohtmltopdf.NewWebhooks(config.webhookSecret, config.publicURL, config.webhookInline)
+	}
+	if config.jobStoreDir != "" {
+		store, err := gohtmltopdf.NewBoltJobStore(config.jobStoreDir)
+		if err != nil {
+			fatal("Couldn´t open the job store", "dir", config.jobStoreDir, "error", err)
+		}
+		defer store.Close()
+		jobsConfig.Store = store
+	}
+	jobs := gohtmltopdf.NewJobs(gen, jobsConfig)
+	err = jobs.Start(context.Background())
+	if err != nil {
+		fatal("Couldn´t start the jobs", "error", err)
+	}
+
+	results, err := newResults(config)
+	if err != nil {
+		fatal("Couldn´t configure the storage", "storage", config.storage, "error", err)
+	}
+
+	var cache *gohtmltopdf.Cache
+	if config.cache {
+		cache, err = gohtmltopdf.NewCache(config.cacheConfig)
+		if err != nil {
+			fatal("Couldn´t create the cache", "error", err)
+		}
+		cache.Start(context.Background())
+	}
+
+	auth, err := newAuth(config)
+	if err != nil {
+		fatal("Couldn´t configure the authentication", "error", err)
+	}
+
+	proxies, err := parseTrustedProxies(config.proxies)
+	if err != nil {
+		fatal("Couldn´t read the trusted proxies", "error", err)
+	}
 
 	e := echo.New()
-	gohtmltopdf.Router(e, config.internalCode)
+	e.HideBanner = true
+	gohtmltopdf.Router(e, gohtmltopdf.RouterConfig{
+		Auth:           auth,
+		Generator:      gen,
+		Metrics:        metrics,
+		Pool:           pool,
+		Jobs:           jobs,
+		Results:        results,
+		Cache:          cache,
+		Limiter:        limiter,
+		Signers:        signers,
+		TrustedProxies: proxies,
+	})
 
+	slog.Info("starting the server", "port", config.port)
 	err = e.Start(fmt.Sprintf(":%s", config.port))
+	_ = shutdownTracing(context.Background())
+	if err != nil {
+		fatal("Couldn´t start the server", "error", err)
+	}
+}
+
+// newAuth returns the API keys of the file and INTERNAL_CODE, which is a key
+// named internal with all the scopes, and the JWT validation if there is a
+// JWKS. Without them the renders are public.
+func newAuth(config Config) (gohtmltopdf.Authenticator, error) {
+	var auth gohtmltopdf.Authenticators
+	if config.jwt.JWKSFile != "" || config.jwt.JWKSURL != "" {
+		jwtAuth, err := gohtmltopdf.NewJWTAuth(config.jwt)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, jwtAuth)
+	}
+
+	var keys []gohtmltopdf.APIKey
+	if config.apiKeysFile != "" {
+		var err error
+		keys, err = gohtmltopdf.LoadAPIKeys(config.apiKeysFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.internalCode != "" {
+		keys = append(keys, gohtmltopdf.APIKey{
+			Name:   "internal",
+			Hash:   gohtmltopdf.HashAPIKey(config.internalCode),
+			Scopes: []string{gohtmltopdf.ScopeAll},
+		})
+	}
+	if len(keys) > 0 {
+		apiKeys, err := gohtmltopdf.NewAPIKeys(keys)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, apiKeys)
+	}
+
+	if len(auth) == 0 {
+		slog.Warn("there aren't API keys or JWKS, the renders are public")
+		return nil, nil
+	}
+
+	return auth, nil
+}
+
+// newSigners returns the keystores of SIGNING_KEYSTORES_FILE and the
+// default one of SIGNING_KEYSTORE. Without them the documents can't be
+// signed.
+func newSigners(config Config) (*gohtmltopdf.Signers, error) {
+	var keystores []gohtmltopdf.SigningKeystore
+	if config.keystoresFile != "" {
+		var err error
+		keystores, err = gohtmltopdf.LoadSigningKeystores(config.keystoresFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.keystore.Path != "" {
+		keystores = append(keystores, config.keystore)
+	}
+	if len(keystores) == 0 {
+		return nil, nil
+	}
+
+	return gohtmltopdf.LoadSigners(keystores, config.tsaURL)
+}
+
+// newLimiter returns the rate limits of RATE_LIMITS_FILE, the quotas are
+// kept in QUOTA_STORE_DIR or in memory. Without the file there aren't
+// limits.
+func newLimiter(config Config, metrics *gohtmltopdf.Metrics) (*gohtmltopdf.RateLimiter, func(), error) {
+	if config.rateLimits == "" {
+		return nil, func() {}, nil
+	}
+
+	limits, err := gohtmltopdf.LoadRateLimits(config.rateLimits)
 	if err != nil {
-		log.Fatalf("Couldn´t start the server, err: %v", err)
+		return nil, nil, err
 	}
+	if config.quotaStoreDir == "" {
+		return gohtmltopdf.NewRateLimiter(limits, nil, metrics), func() {}, nil
+	}
+
+	store, err := gohtmltopdf.NewBoltQuotaStore(config.quotaStoreDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return gohtmltopdf.NewRateLimiter(limits, store, metrics), func() { _ = store.Close() }, nil
+}
+
+// newResults returns the storage of the results configured with STORAGE,
+// local or s3. Without it the results can't be stored. The local documents
+// are removed after STORAGE_RETENTION, the ones of S3 by the lifecycle rules
+// of the bucket.
+func newResults(config Config) (*gohtmltopdf.Results, error) {
+	var storage gohtmltopdf.Storage
+	var err error
+	switch config.storage {
+	case "":
+		return nil, nil
+	case "local":
+		secret := config.storageSecret
+		if secret == "" {
+			// The download URLs aren't valid after a restart.
+			b := make([]byte, 32)
+			_, _ = rand.Read(b)
+			secret = hex.EncodeToString(b)
+			slog.Warn("STORAGE_SECRET isn't set, the download URLs expire on restart")
+		}
+		var local *gohtmltopdf.LocalStorage
+		local, err = gohtmltopdf.NewLocalStorage(config.storageDir, config.publicURL, secret)
+		if err != nil {
+			return nil, err
+		}
+		local.Start(context.Background(), config.retention)
+		storage = local
+	case "s3":
+		storage, err = gohtmltopdf.NewS3Storage(config.s3)
+	default:
+		return nil, fmt.Errorf("storage %q not supported", config.storage)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return gohtmltopdf.NewResults(storage, config.resultURLTTL), nil
+}
+
+// parseTrustedProxies reads the comma separated networks or IPs of
+// TRUSTED_PROXIES. Without them the client IP is the one of the connection.
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func loadEnvs(envFilePath string) error {
@@ -48,10 +394,146 @@ func loadEnvs(envFilePath string) error {
 
 func parseEnvToConfig() Config {
 	internalCode := os.Getenv(InternalCodeKey)
+	// API_KEYS_FILE is a JSON file with the name, the SHA-256 and the scopes
+	// of every key.
+	apiKeysFile := os.Getenv(APIKeysFileKey)
 	port := os.Getenv(PortKey)
+	renderBackend := os.Getenv(RenderBackendKey)
+	wkhtmltopdf := os.Getenv(WKHTMLToPDFKey)
+	// Without an OTLP endpoint the spans aren't exported.
+	otlpEndpoint := os.Getenv(OTLPEndpointKey)
+
+	// By default, there is a render per CPU.
+	concurrency, err := strconv.Atoi(os.Getenv(ConcurrencyKey))
+	if err != nil || concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+
+	jobTTL, err := time.ParseDuration(os.Getenv(JobTTLKey))
+	if err != nil || jobTTL <= 0 {
+		jobTTL = gohtmltopdf.DefaultJobTTL
+	}
+
+	// The big DIAN batches can need more than the default timeout.
+	jobTimeout, err := time.ParseDuration(os.Getenv(JobTimeoutKey))
+	if err != nil || jobTimeout <= 0 {
+		jobTimeout = gohtmltopdf.DefaultJobTimeout
+	}
+
+	jobQueueSize, err := strconv.Atoi(os.Getenv(JobQueueSizeKey))
+	if err != nil || jobQueueSize < 1 {
+		jobQueueSize = gohtmltopdf.DefaultJobQueueSize
+	}
+
+	// Without a secret the jobs can't have a callback URL.
+	webhookSecret := os.Getenv(WebhookSecretKey)
+	// PUBLIC_URL is used for the download links of the webhooks, e.g. https://pdf.example.com
+	publicURL := strings.TrimSuffix(os.Getenv(PublicURLKey), "/")
+	webhookInline, err := strconv.Atoi(os.Getenv(WebhookInlineKey))
+	if err != nil || webhookInline < 0 {
+		webhookInline = gohtmltopdf.DefaultWebhookInlineLimit
+	}
+
+	// Without a store directory the jobs are lost on a restart.
+	jobStoreDir := os.Getenv(JobStoreDirKey)
+
+	// STORAGE can be local or s3, by default the results aren't stored.
+	storage := os.Getenv(StorageKey)
+	storageDir := os.Getenv(StorageDirKey)
+	if storageDir == "" {
+		storageDir = "results"
+	}
+	resultURLTTL, err := time.ParseDuration(os.Getenv(ResultURLTTLKey))
+	if err != nil || resultURLTTL <= 0 {
+		resultURLTTL = gohtmltopdf.DefaultResultURLTTL
+	}
+	retention, err := time.ParseDuration(os.Getenv(RetentionKey))
+	if err != nil || retention <= 0 {
+		retention = gohtmltopdf.DefaultResultRetention
+	}
+	s3Insecure, _ := strconv.ParseBool(os.Getenv(S3InsecureKey))
+	s3 := gohtmltopdf.S3Config{
+		Endpoint:  os.Getenv(S3EndpointKey),
+		Region:    os.Getenv(S3RegionKey),
+		Bucket:    os.Getenv(S3BucketKey),
+		AccessKey: os.Getenv(S3AccessKeyKey),
+		SecretKey: os.Getenv(S3SecretKeyKey),
+		Insecure:  s3Insecure,
+	}
+
+	// CACHE=true enables the render cache, the disk tier needs CACHE_DIR.
+	cache, _ := strconv.ParseBool(os.Getenv(CacheKey))
+	cacheMemory, _ := strconv.ParseInt(os.Getenv(CacheMemoryKey), 10, 64)
+	cacheDisk, _ := strconv.ParseInt(os.Getenv(CacheDiskKey), 10, 64)
+	cacheTTL, _ := time.ParseDuration(os.Getenv(CacheTTLKey))
+	// The DIAN forms are only saved in CACHE_DIR with CACHE_DISK_DIAN=true,
+	// the files aren't encrypted.
+	cacheDiskDIAN, _ := strconv.ParseBool(os.Getenv(CacheDiskDIANKey))
+	cacheConfig := gohtmltopdf.CacheConfig{
+		MaxMemoryBytes: cacheMemory,
+		Dir:            os.Getenv(CacheDirKey),
+		MaxDiskBytes:   cacheDisk,
+		TTL:            cacheTTL,
+		DiskSensitive:  cacheDiskDIAN,
+	}
+
+	// SIGNING_KEYSTORE is a PKCS#12 file with the default certificate of the
+	// signatures, SIGNING_KEYSTORES_FILE is a JSON file with the keystores of
+	// the clients. Without a TSA_URL the signatures don't have a timestamp.
+	keystore := gohtmltopdf.SigningKeystore{
+		Path:     os.Getenv(KeystoreKey),
+		Password: os.Getenv(KeystorePassKey),
+	}
+
+	// TRUSTED_PROXIES are the networks of the proxies in front of the
+	// service, e.g. 10.0.0.0/8, their X-Forwarded-For header has the IP of
+	// the client for the rate limits.
+	proxies := os.Getenv(TrustedProxyKey)
+
+	// LOG_LEVEL can be debug, info, warn or error. By default, it's info.
+	var logLevel slog.Level
+	err = logLevel.UnmarshalText([]byte(os.Getenv(LogLevelKey)))
+	if err != nil {
+		logLevel = slog.LevelInfo
+	}
 
 	return Config{
 		internalCode: internalCode,
-		port:         port,
+		apiKeysFile:  apiKeysFile,
+		jwt: gohtmltopdf.JWTConfig{
+			JWKSFile:    os.Getenv(JWKSFileKey),
+			JWKSURL:     os.Getenv(JWKSURLKey),
+			Issuer:      os.Getenv(JWTIssuerKey),
+			Audience:    os.Getenv(JWTAudienceKey),
+			ScopeClaim:  os.Getenv(JWTScopeClaimKey),
+			ClientClaim: os.Getenv(JWTClientKey),
+		},
+		port:          port,
+		renderBackend: renderBackend,
+		wkhtmltopdf:   wkhtmltopdf,
+		logLevel:      logLevel,
+		otlpEndpoint:  otlpEndpoint,
+		concurrency:   concurrency,
+		jobTTL:        jobTTL,
+		jobTimeout:    jobTimeout,
+		jobQueueSize:  jobQueueSize,
+		webhookSecret: webhookSecret,
+		webhookInline: webhookInline,
+		publicURL:     publicURL,
+		jobStoreDir:   jobStoreDir,
+		rateLimits:    os.Getenv(RateLimitsKey),
+		quotaStoreDir: os.Getenv(QuotaStoreDirKey),
+		keystore:      keystore,
+		keystoresFile: os.Getenv(KeystoresFileKey),
+		tsaURL:        os.Getenv(TSAURLKey),
+		proxies:       proxies,
+		storage:       storage,
+		storageDir:    storageDir,
+		storageSecret: os.Getenv(StorageSecretKey),
+		resultURLTTL:  resultURLTTL,
+		retention:     retention,
+		s3:            s3,
+		cache:         cache,
+		cacheConfig:   cacheConfig,
 	}
 }
@@ -1,10 +1,10 @@
 package gohtmltopdf
 
 import (
+	"context"
 	"fmt"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
-	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -23,60 +23,188 @@ import (
 	"github.com/johnfercher/maroto/v2/pkg/consts/pagesize"
 	"github.com/johnfercher/maroto/v2/pkg/core"
 	"github.com/johnfercher/maroto/v2/pkg/props"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type DIAN struct {
 	isDebug bool
+	metrics *Metrics
+	// Metadata of the PDF, by default the title is "Certificado 220 {Year}
+	// - {IdentificationNumber}", without the number for several employees.
+	Metadata *Metadata
+	// Watermarks are texts and images added to the pages, like BORRADOR.
+	Watermarks []Watermark
+	// Optimize reduces the size of the PDF.
+	Optimize *Optimization
+	// Profile of the output, pdfa-2b for archival documents.
+	Profile string
+	// Encryption protects the PDF with passwords and permissions.
+	Encryption *Encryption
+	// Signature signs the PDF, by default in the Firma area of the last
+	// page.
+	Signature *Signature
+	signers   *Signers
 }
 
 func NewDIAN(isDebug bool) DIAN {
 	return DIAN{isDebug: isDebug}
 }
 
-func (d DIAN) CreateDIANForm220(data DIANForms220Relation) ([]byte, error) {
-	if len(data) == 0 {
-		return nil, fmt.Errorf("no data to generate PDF")
+// dianForm returns the DIAN that renders the forms with the options of the
+// request.
+func (r requestDIANForm220) dianForm(metrics *Metrics, signers *Signers) DIAN {
+	return DIAN{
+		metrics:    metrics,
+		Metadata:   r.Metadata,
+		Watermarks: r.Watermarks,
+		Optimize:   r.Optimize,
+		Profile:    r.Profile,
+		Encryption: r.Encryption,
+		Signature:  r.Signature,
+		signers:    signers,
 	}
+}
+
+// validate checks the request before the forms are rendered. With split
+// every employee is a PDF, so the encryption is checked for each one.
+func (r requestDIANForm220) validate(split bool) error {
+	if len(r.Data) == 0 {
+		return ErrorProcess{Msg: "no data to generate PDF"}
+	}
+
+	err := validateDocument(r.Metadata, r.Watermarks, r.Optimize, r.Profile, r.Encryption, r.Signature)
+	if err != nil || r.Encryption == nil {
+		return err
+	}
+	if !split {
+		return validateDIANEncryption(*r.Encryption, r.Data)
+	}
+	for _, item := range r.Data {
+		err = validateDIANEncryption(*r.Encryption, DIANForms220Relation{item})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateDIANForm220 creates a PDF with a page for every employee. The data has
+// personal information, so only the year and the number of employees are logged.
+func (d DIAN) CreateDIANForm220(ctx context.Context, data DIANForms220Relation) ([]byte, error) {
+	err := requestDIANForm220{
+		Data:       data,
+		Metadata:   d.Metadata,
+		Watermarks: d.Watermarks,
+		Optimize:   d.Optimize,
+		Profile:    d.Profile,
+		Encryption: d.Encryption,
+		Signature:  d.Signature,
+	}.validate(false)
+	if err != nil {
+		return nil, err
+	}
+	if d.Signature != nil {
+		_, err = d.signers.signer(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger := LoggerFromContext(ctx).With("year", data[0].Year, "employees", len(data))
+	attrs := trace.WithAttributes(
+		attribute.Int("dian.year", int(data[0].Year)),
+		attribute.Int("dian.employees", len(data)),
+	)
 
+	_, span := tracer().Start(ctx, "dian.template", attrs)
 	var m core.Maroto
 	switch data[0].Year {
 	case 2022:
 		m = d.dIAN2022(data)
 	default:
-		log.Printf("Year %d not supported", data[0].Year)
-		return nil, ErrorProcess{Msg: fmt.Sprintf("year %d not supported", data[0].Year)}
+		logger.Warn("year not supported")
+		err := ErrorProcess{Msg: fmt.Sprintf("year %d not supported", data[0].Year)}
+		endSpan(span, err)
+		return nil, err
 	}
+	span.End()
 
+	_, span = tracer().Start(ctx, "dian.generate", attrs)
 	document, err := m.Generate()
+	if err == nil {
+		setOutputAttributes(span, document.GetBytes())
+	}
+	endSpan(span, err)
 	if err != nil {
-		log.Println("Error on generate PDF", err)
+		logger.Error("error on generate PDF", "error", err)
 		return nil, err
 	}
 
+	d.metrics.ObserveMarotoReport(document.GetReport())
+
 	if d.isDebug {
 		// Save the metrics report
 		err = document.GetReport().Save(fmt.Sprintf("./report-maroto-%s.txt", time.Now().Format("2006-01-02-15-04-05")))
 		if err != nil {
 			// We don't need to stop the process if the report can't be saved
-			log.Println("Error on save metrics report", err)
+			logger.Warn("error on save metrics report", "error", err)
+		}
+	}
+
+	metadata := d.metadata(data)
+	pdf, err := finishPDF(ctx, document.GetBytes(), &metadata, d.Watermarks, d.Optimize, d.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if d.Encryption != nil {
+		return encryptDIAN(ctx, pdf, data, *d.Encryption)
+	}
+	if d.Signature != nil {
+		signature := *d.Signature
+		if len(signature.Rect) == 0 {
+			signature.Rect = dianSignatureRect
 		}
+		return d.signers.Sign(ctx, pdf, signature)
 	}
 
-	return document.GetBytes(), nil
+	return linearizeOptimized(ctx, pdf, d.Optimize), nil
+}
+
+// metadata returns the Metadata of the PDF with the default title.
+func (d DIAN) metadata(data DIANForms220Relation) Metadata {
+	var metadata Metadata
+	if d.Metadata != nil {
+		metadata = *d.Metadata
+	}
+	if metadata.Title != "" {
+		return metadata
+	}
+
+	metadata.Title = fmt.Sprintf("Certificado 220 %d", data[0].Year)
+	if len(data) == 1 && data[0].IdentificationNumber != "" {
+		metadata.Title += " - " + data[0].IdentificationNumber
+	}
+
+	return metadata
 }
 
 // dIAN2022 Structure of the DIAN 220 form for the year 2022
 func (d DIAN) dIAN2022(data DIANForms220Relation) core.Maroto {
-	cfg := config.NewBuilder().
+	builder := config.NewBuilder().
 		WithPageSize(pagesize.Letter).
 		WithTopMargin(5).
 		WithBottomMargin(5).
 		WithMaxGridSize(28).
-		WithDebug(d.isDebug).
-		Build()
+		WithDebug(d.isDebug)
+	if isPDFA(d.Profile) {
+		builder = withPDFAFonts(builder)
+	}
+	cfg := builder.Build()
 
 	mrt := maroto.New(cfg)
-	if d.isDebug {
+	if d.isDebug || d.metrics != nil {
 		// Add a metrics report to the maroto instance
 		mrt = maroto.NewMetricsDecorator(mrt)
 	}
@@ -0,0 +1,135 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Permissions of an encrypted PDF.
+const (
+	PermissionPrint  = "print"
+	PermissionCopy   = "copy"
+	PermissionModify = "modify"
+)
+
+// permissionFlags are the bits of the PDF permissions of each option, the
+// revision 2 and 3 bits are both set so every reader applies them.
+var permissionFlags = map[string]model.PermissionFlags{
+	PermissionPrint:  model.PermissionPrintRev2 | model.PermissionPrintRev3,
+	PermissionCopy:   model.PermissionExtract | model.PermissionExtractRev3,
+	PermissionModify: model.PermissionModify | model.PermissionModAnnFillForm | model.PermissionFillRev3 | model.PermissionAssembleRev3,
+}
+
+// Encryption protects a PDF with AES-256. The user password opens the
+// document with the Permissions, the owner password opens it without
+// restrictions.
+type Encryption struct {
+	// UserPassword can be empty, so the document opens without a password
+	// but keeps the permissions.
+	UserPassword string `json:"user_password,omitempty"`
+	// OwnerPassword is random when it's empty, so nobody can remove the
+	// permissions.
+	OwnerPassword string `json:"owner_password,omitempty"`
+	// Permissions are print, copy and modify, nothing is allowed by default.
+	Permissions []string `json:"permissions,omitempty"`
+	// UserPasswordFromID uses the IdentificationNumber of the employee as
+	// the user password, only for the DIAN forms of a single employee.
+	UserPasswordFromID bool `json:"user_password_from_id,omitempty"`
+}
+
+// Validate checks the permissions.
+func (e Encryption) Validate() error {
+	for _, p := range e.Permissions {
+		if _, ok := permissionFlags[p]; !ok {
+			return ErrorProcess{Msg: fmt.Sprintf("permission %q not supported", p)}
+		}
+	}
+	if e.UserPasswordFromID && e.UserPassword != "" {
+		return ErrorProcess{Msg: "the user password can't be set with user_password_from_id"}
+	}
+
+	return nil
+}
+
+// EncryptPDF returns the PDF encrypted with the passwords and permissions.
+func EncryptPDF(ctx context.Context, pdf []byte, encryption Encryption) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "encrypt")
+	defer func() { endSpan(span, err) }()
+
+	err = encryption.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	owner := encryption.OwnerPassword
+	if owner == "" {
+		owner = newID()
+	}
+
+	conf := model.NewAESConfiguration(encryption.UserPassword, owner, 256)
+	conf.Permissions = model.PermissionsNone
+	for _, p := range encryption.Permissions {
+		conf.Permissions |= permissionFlags[p]
+	}
+
+	buf := bytes.Buffer{}
+	err = api.Encrypt(bytes.NewReader(pdf), &buf, conf)
+	if err != nil {
+		return nil, fmt.Errorf("can't encrypt the PDF: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// userPassword returns the password that opens the PDF of the employees,
+// the data is nil for the HTML renders.
+func (e *Encryption) userPassword(data DIANForms220Relation) string {
+	if e == nil {
+		return ""
+	}
+	if e.UserPasswordFromID && len(data) == 1 {
+		return data[0].IdentificationNumber
+	}
+
+	return e.UserPassword
+}
+
+// pageCount returns the pages of a PDF, encrypted or not.
+func pageCount(pdf []byte, password string) (int, error) {
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW = password
+
+	return api.PageCount(bytes.NewReader(pdf), conf)
+}
+
+// validateDIANEncryption checks the encryption of the DIAN forms before they
+// are rendered, the user password can only be the IdentificationNumber of a
+// single employee.
+func validateDIANEncryption(encryption Encryption, data DIANForms220Relation) error {
+	if encryption.UserPasswordFromID {
+		if len(data) != 1 {
+			return ErrorProcess{Msg: "user_password_from_id needs a document per employee, use a split job"}
+		}
+		if data[0].IdentificationNumber == "" {
+			return ErrorProcess{Msg: "the employee doesn't have an IdentificationNumber for the user password"}
+		}
+	}
+
+	return encryption.Validate()
+}
+
+// encryptDIAN encrypts the PDF of the employees, the user password can be
+// the IdentificationNumber of the only employee. The encryption must be
+// checked with validateDIANEncryption.
+func encryptDIAN(ctx context.Context, pdf []byte, data DIANForms220Relation, encryption Encryption) ([]byte, error) {
+	if encryption.UserPasswordFromID {
+		encryption.UserPassword = data[0].IdentificationNumber
+		encryption.UserPasswordFromID = false
+	}
+
+	return EncryptPDF(ctx, pdf, encryption)
+}
@@ -0,0 +1,166 @@
+package gohtmltopdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// permissions returns the permission flags of an encrypted PDF.
+func permissions(t *testing.T, pdf []byte, password string) model.PermissionFlags {
+	t.Helper()
+
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW = password
+	p, err := api.GetPermissions(bytes.NewReader(pdf), conf)
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the permissions: %v", err)
+	}
+	if p == nil {
+		t.Fatal("the PDF isn't encrypted")
+	}
+
+	return model.PermissionFlags(uint16(*p))
+}
+
+func TestGenerator_Render_encryption(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	pdf, err := gen.Render(context.Background(), strings.NewReader("<p>Salario</p>"), RenderOptions{
+		Encryption: &Encryption{UserPassword: "user", OwnerPassword: "owner", Permissions: []string{PermissionPrint}},
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	if _, err := pageCount(pdf, ""); err == nil {
+		t.Error("the PDF must need the user password")
+	}
+	if pages, err := pageCount(pdf, "user"); err != nil || pages != 1 {
+		t.Errorf("pageCount() = %d, %v, want 1 page with the user password", pages, err)
+	}
+
+	flags := permissions(t, pdf, "user")
+	if flags&model.PermissionPrintRev3 == 0 {
+		t.Error("the PDF must allow printing")
+	}
+	if flags&(model.PermissionExtract|model.PermissionModify) != 0 {
+		t.Error("the PDF must not allow copying or modifying")
+	}
+}
+
+func TestEncryption_Validate(t *testing.T) {
+	if err := (Encryption{Permissions: []string{"share"}}).Validate(); err == nil {
+		t.Error("an unknown permission must be rejected")
+	}
+
+	options := RenderOptions{Encryption: &Encryption{UserPasswordFromID: true}}
+	if err := options.Validate(); err == nil {
+		t.Error("user_password_from_id must be rejected in the HTML renders")
+	}
+}
+
+func TestDIAN_encryptionFromID(t *testing.T) {
+	item := DIANForm220Relation{DIANForm220: DIANForm220{Year: 2022, Records: json.RawMessage(`{}`)}, IdentificationNumber: "1098765432"}
+	dian := NewDIAN(false)
+	dian.Encryption = &Encryption{UserPasswordFromID: true, Permissions: []string{PermissionPrint, PermissionCopy}}
+
+	pdf, err := dian.CreateDIANForm220(context.Background(), DIANForms220Relation{item})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if pages, err := pageCount(pdf, "1098765432"); err != nil || pages != 1 {
+		t.Errorf("pageCount() = %d, %v, want the IdentificationNumber as password", pages, err)
+	}
+	if flags := permissions(t, pdf, "1098765432"); flags&model.PermissionExtract == 0 || flags&model.PermissionModify != 0 {
+		t.Errorf("permissions = %b, want print and copy", flags)
+	}
+
+	_, err = dian.CreateDIANForm220(context.Background(), DIANForms220Relation{item, item})
+	if err == nil {
+		t.Error("the password of several employees must be rejected")
+	}
+}
+
+func TestDIAN_validateOptions(t *testing.T) {
+	// The year isn't supported, the options must be rejected before the render.
+	item := DIANForm220Relation{DIANForm220: DIANForm220{Year: 1999, Records: json.RawMessage(`{}`)}, IdentificationNumber: "1"}
+
+	tests := map[string]func(*DIAN){
+		"metadata":   func(d *DIAN) { d.Metadata = &Metadata{Properties: map[string]string{"Title": "x"}} },
+		"watermarks": func(d *DIAN) { d.Watermarks = []Watermark{{}} },
+		"encryption": func(d *DIAN) { d.Encryption = &Encryption{UserPasswordFromID: true} },
+	}
+	for name, option := range tests {
+		dian := NewDIAN(false)
+		option(&dian)
+		_, err := dian.CreateDIANForm220(context.Background(), DIANForms220Relation{item, item})
+		if !errors.As(err, &ErrorProcess{}) || strings.Contains(err.Error(), "1999") {
+			t.Errorf("%s: err = %v, want the error of the option", name, err)
+		}
+	}
+}
+
+func TestJobs_dianSplitEncrypted(t *testing.T) {
+	e := newJobsServer(t)
+
+	rec := doJSON(e, http.MethodPost, "/jobs", `{"type": "dian-form-220", "split": true, "request": {
+		"encryption": {"user_password_from_id": true},
+		"data": [
+			{"year": 2022, "rows": {}, "IdentificationNumber": "1"},
+			{"year": 2022, "rows": {}, "IdentificationNumber": "2"}
+		]
+	}}`)
+	job := Job{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+	job = waitJob(t, e, job.ID)
+	if job.Status != JobDone {
+		t.Fatalf("unexpected job %+v", job)
+	}
+
+	rec = doJSON(e, http.MethodGet, "/jobs/"+job.ID+"/result", "")
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("the result isn't a ZIP: %v", err)
+	}
+	for i, f := range archive.File {
+		r, _ := f.Open()
+		pdf, _ := io.ReadAll(r)
+		_ = r.Close()
+
+		password := []string{"1", "2"}[i]
+		if _, err := pageCount(pdf, password); err != nil {
+			t.Errorf("%s: the PDF must open with the ID of its employee: %v", f.Name, err)
+		}
+		if _, err := pageCount(pdf, ""); err == nil {
+			t.Errorf("%s: the PDF must need a password", f.Name)
+		}
+	}
+}
+
+func TestHandler_encryptionNotCached(t *testing.T) {
+	cache, _ := NewCache(CacheConfig{})
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen, Cache: cache})
+
+	body := `{"data": "<p>a</p>", "options": {"encryption": {"user_password": "x"}}}`
+	for i := 0; i < 2; i++ {
+		rec := doJSON(e, http.MethodPost, "/html-to-pdf", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		if rec.Header().Get(HeaderCache) != "" {
+			t.Errorf("X-Cache = %q, the encrypted PDFs must not be cached", rec.Header().Get(HeaderCache))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package gohtmltopdf_test
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/alexyslozada/gohtmltopdf"
+)
+
+func ExampleNewGenerator() {
+	gen := gohtmltopdf.NewGenerator(
+		gohtmltopdf.WithBinaryPath("/usr/local/bin/wkhtmltopdf"),
+		gohtmltopdf.WithDefaultFlags("--quiet", "--encoding", "utf-8"),
+		gohtmltopdf.WithTempDir(os.TempDir()),
+	)
+
+	// The Generator can be used wherever a Renderer is expected.
+	var renderer gohtmltopdf.Renderer = gen
+	_ = renderer
+}
+
+func ExampleGenerator_Render() {
+	gen := gohtmltopdf.NewGenerator()
+
+	margin := 15.0
+	options := gohtmltopdf.RenderOptions{
+		PageSize:    "Letter",
+		Orientation: "Portrait",
+		MarginTop:   &margin,
+	}
+
+	input := strings.NewReader("<html><body><h1>Hola mundo</h1></body></html>")
+	pdf, err := gen.Render(context.Background(), input, options)
+	if err != nil {
+		log.Fatalf("can't create the PDF: %v", err)
+	}
+
+	err = os.WriteFile("hola.pdf", pdf, 0644)
+	if err != nil {
+		log.Fatalf("can't write the PDF: %v", err)
+	}
+}
+
+func ExampleWithDefaultBackend() {
+	// The maroto backend doesn't need the wkhtmltopdf executable, it supports
+	// a subset of HTML.
+	gen := gohtmltopdf.NewGenerator(gohtmltopdf.WithDefaultBackend(gohtmltopdf.BackendMaroto))
+
+	input := strings.NewReader(`<h1>Hola mundo</h1><table border="1"><tr><td>Uno</td><td>Dos</td></tr></table>`)
+	_, err := gen.Render(context.Background(), input, gohtmltopdf.RenderOptions{PageSize: "A4"})
+	if err != nil {
+		log.Fatalf("can't create the PDF: %v", err)
+	}
+}
@@ -0,0 +1,443 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/create"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/form"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Types of the fields of a form.
+const (
+	FormFieldText     = "text"
+	FormFieldDate     = "date"
+	FormFieldCheckBox = "checkbox"
+	FormFieldRadio    = "radio"
+	FormFieldComboBox = "combobox"
+	FormFieldListBox  = "listbox"
+)
+
+// Annotation flags of the widgets that aren't shown.
+const (
+	annotHidden = 1 << 1
+	annotNoView = 1 << 5
+)
+
+// FormField is a field of the AcroForm of a PDF template.
+type FormField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Value is the current value, Yes or Off for a checkbox and the
+	// selected option for a radio group.
+	Value    string   `json:"value,omitempty"`
+	Options  []string `json:"options,omitempty"`
+	Pages    []int    `json:"pages"`
+	ReadOnly bool     `json:"read_only"`
+}
+
+// formFieldTypes are the names of the types of the pdfcpu fields.
+var formFieldTypes = map[form.FieldType]string{
+	form.FTText:             FormFieldText,
+	form.FTDate:             FormFieldDate,
+	form.FTCheckBox:         FormFieldCheckBox,
+	form.FTRadioButtonGroup: FormFieldRadio,
+	form.FTComboBox:         FormFieldComboBox,
+	form.FTListBox:          FormFieldListBox,
+}
+
+// FormFields returns the fields of the AcroForm of the PDF, none if it
+// doesn't have a form.
+func FormFields(ctx context.Context, pdf []byte) (fields []FormField, err error) {
+	_, span := tracer().Start(ctx, "form_fields")
+	defer func() { endSpan(span, err) }()
+
+	pdfCtx, err := readForm(pdf, model.LISTFORMFIELDS)
+	if err != nil {
+		return nil, err
+	}
+
+	return formFields(pdfCtx)
+}
+
+// hasForm reports whether the PDF has an AcroForm, pdfcpu fails to list the
+// fields of the documents without one.
+func hasForm(pdfCtx *model.Context) bool {
+	rootDict, err := pdfCtx.Catalog()
+	if err != nil {
+		return false
+	}
+	acroForm, err := pdfCtx.DereferenceDict(rootDict["AcroForm"])
+
+	return err == nil && acroForm != nil
+}
+
+// FillForm fills the fields of the AcroForm of the PDF by name: the text
+// and date fields with strings or numbers, the checkboxes with booleans and
+// the radio groups with one of their options. A flattened form has the
+// values drawn in the pages and can't be edited anymore.
+func FillForm(ctx context.Context, pdf []byte, values map[string]any, flatten bool) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "form_fill")
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("form.values", len(values)),
+			attribute.Bool("form.flatten", flatten),
+		)
+		endSpan(span, err)
+	}()
+
+	pdfCtx, err := readForm(pdf, model.FILLFORMFIELDS)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := formFields(pdfCtx)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrorProcess{Msg: "the document doesn't have form fields"}
+	}
+
+	f, err := formValues(fields, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// The filled form would invalidate the signatures.
+	pdfCtx.RemoveSignature()
+	_, pages, err := form.FillForm(pdfCtx, form.FillDetails(&f, nil), nil, form.JSON)
+	if err != nil {
+		return nil, fmt.Errorf("can't fill the form: %w", err)
+	}
+	_, _, err = create.UpdatePageTree(pdfCtx, pages, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't update the pages of the form: %w", err)
+	}
+
+	if flatten {
+		err = flattenForm(pdfCtx)
+		if err != nil {
+			return nil, fmt.Errorf("can't flatten the form: %w", err)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	err = api.WriteContext(pdfCtx, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("can't write the filled form: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readForm reads the PDF for the form command.
+func readForm(pdf []byte, cmd model.CommandMode) (*model.Context, error) {
+	conf := model.NewDefaultConfiguration()
+	conf.Cmd = cmd
+	pdfCtx, _, _, _, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), conf, time.Now())
+	if err != nil {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or it's encrypted: %v", err)}
+	}
+
+	err = pdfCtx.EnsurePageCount()
+	if err != nil {
+		return nil, err
+	}
+
+	return pdfCtx, nil
+}
+
+// formFields converts the fields of pdfcpu.
+func formFields(pdfCtx *model.Context) ([]FormField, error) {
+	if !hasForm(pdfCtx) {
+		return []FormField{}, nil
+	}
+
+	pdfFields, _, err := form.FormFields(pdfCtx)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the form fields: %w", err)
+	}
+
+	fields := make([]FormField, 0, len(pdfFields))
+	for _, f := range pdfFields {
+		field := FormField{
+			Name:     f.Name,
+			Type:     formFieldTypes[f.Typ],
+			Value:    f.V,
+			Pages:    f.Pages,
+			ReadOnly: f.Locked,
+		}
+		if f.Opts != "" {
+			field.Options = strings.Split(f.Opts, ",")
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// formValues returns the pdfcpu form with the values of the fields. The
+// read only fields are filled and kept read only.
+func formValues(fields []FormField, values map[string]any) (form.Form, error) {
+	f := form.Form{}
+	for name, value := range values {
+		i := slices.IndexFunc(fields, func(field FormField) bool { return field.Name == name })
+		if i < 0 {
+			return form.Form{}, ErrorProcess{Msg: fmt.Sprintf("the form doesn't have the field %q", name)}
+		}
+		field := fields[i]
+
+		switch field.Type {
+		case FormFieldText, FormFieldDate:
+			var s string
+			switch v := value.(type) {
+			case string:
+				s = v
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return form.Form{}, ErrorProcess{Msg: fmt.Sprintf("the value of the field %q must be a string", name)}
+			}
+			if field.Type == FormFieldDate {
+				f.DateFields = append(f.DateFields, &form.DateField{Name: name, Value: s, Locked: field.ReadOnly})
+			} else {
+				f.TextFields = append(f.TextFields, &form.TextField{Name: name, Value: s, Locked: field.ReadOnly})
+			}
+		case FormFieldCheckBox:
+			checked, ok := value.(bool)
+			if !ok {
+				return form.Form{}, ErrorProcess{Msg: fmt.Sprintf("the value of the checkbox %q must be a boolean", name)}
+			}
+			f.CheckBoxes = append(f.CheckBoxes, &form.CheckBox{Name: name, Value: checked, Locked: field.ReadOnly})
+		case FormFieldRadio:
+			option, ok := value.(string)
+			if !ok || !slices.Contains(field.Options, option) {
+				return form.Form{}, ErrorProcess{Msg: fmt.Sprintf("the value of the radio group %q must be one of %v", name, field.Options)}
+			}
+			f.RadioButtonGroups = append(f.RadioButtonGroups, &form.RadioButtonGroup{Name: name, Value: option, Locked: field.ReadOnly})
+		default:
+			return form.Form{}, ErrorProcess{Msg: fmt.Sprintf("the fields of type %s like %q can't be filled", field.Type, name)}
+		}
+	}
+
+	return f, nil
+}
+
+// flattenForm draws the appearances of the widgets in the content of their
+// pages, and removes the widgets and the AcroForm.
+func flattenForm(pdfCtx *model.Context) error {
+	rootDict, err := pdfCtx.Catalog()
+	if err != nil {
+		return err
+	}
+	var defaultResources types.Dict
+	if acroForm, err := pdfCtx.DereferenceDict(rootDict["AcroForm"]); err == nil && acroForm != nil {
+		defaultResources, _ = pdfCtx.DereferenceDict(acroForm["DR"])
+	}
+
+	for i := 1; i <= pdfCtx.PageCount; i++ {
+		pageDict, _, inherited, err := pdfCtx.PageDict(i, false)
+		if err != nil {
+			return err
+		}
+		annots, err := pdfCtx.DereferenceArray(pageDict["Annots"])
+		if err != nil || len(annots) == 0 {
+			continue
+		}
+
+		var xobjects types.Dict
+		if inherited.Resources != nil {
+			xobjects, _ = pdfCtx.DereferenceDict(inherited.Resources["XObject"])
+		}
+		xobjects = cloneDict(xobjects)
+		content := bytes.Buffer{}
+		var kept types.Array
+		for _, annot := range annots {
+			d, err := pdfCtx.DereferenceDict(annot)
+			if err != nil || d == nil || d.Subtype() == nil || *d.Subtype() != "Widget" {
+				kept = append(kept, annot)
+				continue
+			}
+			if flags := d.IntEntry("F"); flags != nil && *flags&(annotHidden|annotNoView) != 0 {
+				continue
+			}
+
+			ref, cm, ok := widgetAppearance(pdfCtx, d, defaultResources)
+			if !ok {
+				continue
+			}
+			name := fmt.Sprintf("Fm%d", ref.ObjectNumber.Value())
+			for xobjects[name] != nil && xobjects[name] != ref {
+				name += "a"
+			}
+			xobjects[name] = ref
+			fmt.Fprintf(&content, "q %s cm /%s Do Q\n", cm, name)
+		}
+
+		if len(kept) == 0 {
+			delete(pageDict, "Annots")
+		} else {
+			pageDict["Annots"] = kept
+		}
+		if content.Len() == 0 {
+			continue
+		}
+
+		resources := cloneDict(inherited.Resources)
+		resources["XObject"] = xobjects
+		pageDict["Resources"] = resources
+		err = wrapPageContent(pdfCtx, pageDict, content.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(rootDict, "AcroForm")
+
+	return nil
+}
+
+// widgetAppearance returns the normal appearance of the widget in its
+// state, and the matrix that draws it in the rectangle of the widget.
+func widgetAppearance(pdfCtx *model.Context, d types.Dict, defaultResources types.Dict) (types.IndirectRef, string, bool) {
+	ap, err := pdfCtx.DereferenceDict(d["AP"])
+	if err != nil || ap == nil {
+		return types.IndirectRef{}, "", false
+	}
+	normal := ap["N"]
+	if states, err := pdfCtx.DereferenceDict(normal); err == nil && states != nil {
+		// The names of the states are compared as they are, pdfcpu encodes
+		// the ones it fills.
+		state, ok := d["AS"].(types.Name)
+		if !ok {
+			return types.IndirectRef{}, "", false
+		}
+		normal = states[string(state)]
+	}
+	ref, ok := normal.(types.IndirectRef)
+	if !ok {
+		return types.IndirectRef{}, "", false
+	}
+	sd, _, err := pdfCtx.DereferenceStreamDict(ref)
+	if err != nil || sd == nil {
+		return types.IndirectRef{}, "", false
+	}
+
+	rect, ok := pdfRect(pdfCtx, d["Rect"])
+	if !ok {
+		return types.IndirectRef{}, "", false
+	}
+	bbox, ok := pdfRect(pdfCtx, sd.Dict["BBox"])
+	if !ok {
+		return types.IndirectRef{}, "", false
+	}
+	m := identityMatrix
+	if a, err := pdfCtx.DereferenceArray(sd.Dict["Matrix"]); err == nil && len(a) == 6 {
+		for i, o := range a {
+			m[i], err = pdfCtx.DereferenceNumber(o)
+			if err != nil {
+				return types.IndirectRef{}, "", false
+			}
+		}
+	}
+
+	// The bounding box transformed by the matrix of the appearance is
+	// fitted in the rectangle, like the viewers do.
+	box := transformRect(bbox, m)
+	if box[2] == box[0] || box[3] == box[1] {
+		return types.IndirectRef{}, "", false
+	}
+	// The scales are rounded, the rectangles are rounded by the writers.
+	sx := math.Round((rect[2]-rect[0])/(box[2]-box[0])*1e6) / 1e6
+	sy := math.Round((rect[3]-rect[1])/(box[3]-box[1])*1e6) / 1e6
+	cm := strings.Join([]string{
+		formatFloat(sx), "0", "0", formatFloat(sy),
+		formatFloat(rect[0] - box[0]*sx), formatFloat(rect[1] - box[1]*sy),
+	}, " ")
+
+	// The appearances without resources use the ones of the AcroForm.
+	if sd.Dict["Resources"] == nil && defaultResources != nil {
+		sd.Dict["Resources"] = cloneDict(defaultResources)
+	}
+
+	return ref, cm, true
+}
+
+// pdfRect returns the rectangle [llx lly urx ury] with its corners in order.
+func pdfRect(pdfCtx *model.Context, o types.Object) ([4]float64, bool) {
+	a, err := pdfCtx.DereferenceArray(o)
+	if err != nil || len(a) != 4 {
+		return [4]float64{}, false
+	}
+
+	var r [4]float64
+	for i, o := range a {
+		r[i], err = pdfCtx.DereferenceNumber(o)
+		if err != nil {
+			return [4]float64{}, false
+		}
+	}
+
+	return [4]float64{math.Min(r[0], r[2]), math.Min(r[1], r[3]), math.Max(r[0], r[2]), math.Max(r[1], r[3])}, true
+}
+
+// transformRect returns the bounding box of the rectangle transformed by
+// the matrix.
+func transformRect(r [4]float64, m matrix) [4]float64 {
+	box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range [][2]float64{{r[0], r[1]}, {r[0], r[3]}, {r[2], r[1]}, {r[2], r[3]}} {
+		x := p[0]*m[0] + p[1]*m[2] + m[4]
+		y := p[0]*m[1] + p[1]*m[3] + m[5]
+		box = [4]float64{math.Min(box[0], x), math.Min(box[1], y), math.Max(box[2], x), math.Max(box[3], y)}
+	}
+
+	return box
+}
+
+// wrapPageContent saves the graphics state around the content of the page,
+// so the content added after it isn't affected by its transformations.
+func wrapPageContent(pdfCtx *model.Context, pageDict types.Dict, content []byte) error {
+	before, err := pdfCtx.StreamDictIndRef([]byte("q\n"))
+	if err != nil {
+		return err
+	}
+	after, err := pdfCtx.StreamDictIndRef(append([]byte("Q\n"), content...))
+	if err != nil {
+		return err
+	}
+
+	contents := types.Array{*before}
+	switch o := pageDict["Contents"].(type) {
+	case types.IndirectRef:
+		if a, err := pdfCtx.DereferenceArray(o); err == nil && a != nil {
+			contents = append(contents, a...)
+		} else {
+			contents = append(contents, o)
+		}
+	case types.Array:
+		contents = append(contents, o...)
+	}
+	pageDict["Contents"] = append(contents, *after)
+
+	return nil
+}
+
+// cloneDict returns a copy of the dictionary to change it, an empty one if
+// it's nil.
+func cloneDict(d types.Dict) types.Dict {
+	if d == nil {
+		return types.Dict{}
+	}
+
+	return d.Clone().(types.Dict)
+}
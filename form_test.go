@@ -0,0 +1,185 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// testFormJSON is a pdfcpu form with a text field, a checkbox and a radio
+// group.
+const testFormJSON = `{
+	"paper": "A4P",
+	"origin": "LowerLeft",
+	"fonts": {"input": {"name": "Helvetica", "size": 12}, "label": {"name": "Helvetica", "size": 10}},
+	"pages": {"1": {"content": {
+		"textfield": [{"id": "nombre", "value": "", "pos": [100, 700], "width": 200, "font": {"name": "$input"}}],
+		"checkbox": [{"id": "acepta", "value": false, "pos": [100, 650], "width": 12}],
+		"radiobuttongroup": [{"id": "tipo", "value": "", "pos": [100, 600], "width": 12,
+			"buttons": {"values": ["natural", "juridica"], "label": {"value": "tipo", "width": 50, "gap": 5, "pos": "right"}}}]
+	}}}
+}`
+
+// testForm returns a PDF with an AcroForm.
+func testForm(t *testing.T) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	if err := api.Create(nil, strings.NewReader(testFormJSON), &buf, nil); err != nil {
+		t.Fatalf("Got an unexpected error creating the form: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestFormFields(t *testing.T) {
+	fields, err := FormFields(context.Background(), testForm(t))
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	want := []FormField{
+		{Name: "nombre", Type: FormFieldText, Pages: []int{1}},
+		{Name: "acepta", Type: FormFieldCheckBox, Pages: []int{1}},
+		{Name: "tipo", Type: FormFieldRadio, Options: []string{"natural", "juridica"}, Pages: []int{1}},
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("fields = %+v, want %+v", fields, want)
+	}
+	for i := range want {
+		if fields[i].Name != want[i].Name || fields[i].Type != want[i].Type || !slices.Equal(fields[i].Options, want[i].Options) || !slices.Equal(fields[i].Pages, want[i].Pages) {
+			t.Errorf("field %d = %+v, want %+v", i, fields[i], want[i])
+		}
+	}
+
+	fields, err = FormFields(context.Background(), renderTestPDF(t, "<p>a</p>"))
+	if err != nil || len(fields) != 0 {
+		t.Errorf("fields = %+v, err = %v, a PDF without form has no fields", fields, err)
+	}
+}
+
+func TestFillForm(t *testing.T) {
+	form := testForm(t)
+	values := map[string]any{"nombre": "Ana Pérez", "acepta": true, "tipo": "juridica"}
+
+	filled, err := FillForm(context.Background(), form, values, false)
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	fields, err := FormFields(context.Background(), filled)
+	if err != nil {
+		t.Fatalf("Got an unexpected error listing the fields: %v", err)
+	}
+	got := map[string]string{}
+	for _, field := range fields {
+		got[field.Name] = field.Value
+	}
+	if got["nombre"] != "Ana Pérez" || got["acepta"] != "Yes" || got["tipo"] != "juridica" {
+		t.Errorf("values = %v", got)
+	}
+
+	flat, err := FillForm(context.Background(), form, values, true)
+	if err != nil {
+		t.Fatalf("Got an unexpected error flattening: %v", err)
+	}
+	if err := api.Validate(bytes.NewReader(flat), nil); err != nil {
+		t.Errorf("the flattened PDF isn't valid: %v", err)
+	}
+	fields, err = FormFields(context.Background(), flat)
+	if err != nil || len(fields) != 0 {
+		t.Errorf("fields = %+v, err = %v, the flattened form can't have fields", fields, err)
+	}
+	// The text field, the checkbox and the two radio buttons are drawn.
+	ctx, err := api.ReadContext(bytes.NewReader(flat), nil)
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the PDF: %v", err)
+	}
+	_ = ctx.EnsurePageCount()
+	pageDict, _, _, _ := ctx.PageDict(1, false)
+	content, _ := ctx.PageContent(pageDict)
+	if n := strings.Count(string(content), " Do Q"); n != 4 {
+		t.Errorf("%d appearances drawn, want 4", n)
+	}
+	if pageDict["Annots"] != nil {
+		t.Error("the widgets must be removed")
+	}
+	if texts := pageTexts(t, flat); len(texts) != 1 || !strings.Contains(texts[0], "Ana Pérez") {
+		t.Errorf("texts = %q, the value must be drawn", texts)
+	}
+
+	for name, value := range map[string]any{"apellido": "x", "acepta": "si", "tipo": "otro", "nombre": true} {
+		_, err = FillForm(context.Background(), form, map[string]any{name: value}, false)
+		if !errors.As(err, &ErrorProcess{}) {
+			t.Errorf("%s=%v: err = %v, want an ErrorProcess", name, value, err)
+		}
+	}
+	_, err = FillForm(context.Background(), renderTestPDF(t, "<p>a</p>"), values, false)
+	if !errors.As(err, &ErrorProcess{}) {
+		t.Errorf("err = %v, a PDF without form can't be filled", err)
+	}
+}
+
+func TestHandler_form(t *testing.T) {
+	storage, _ := NewLocalStorage(t.TempDir(), "", "secret")
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto)), Results: NewResults(storage, time.Minute)})
+
+	// The templates are stored like the results of the other operations.
+	rec := doPDF(e, "/pdf/optimize", []testPart{{name: "pdf", data: string(testForm(t))}, {name: "store", data: "true"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	stored := StoredResult{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &stored)
+
+	req := httptest.NewRequest(http.MethodGet, "/pdf/form/"+stored.Key+"/fields", nil)
+	req.Header.Set(HeaderAPIKey, "secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"tipo","type":"radio"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/pdf/form/missing.pdf/fields", nil)
+	req.Header.Set(HeaderAPIKey, "secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = doPDF(e, "/pdf/form/fill", []testPart{{name: "key", data: stored.Key}, {name: "values", data: `{"nombre": "Ana"}`}, {name: "flatten", data: "true"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	res := map[string][]byte{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	if fields, err := FormFields(context.Background(), res["data"]); err != nil || len(fields) != 0 {
+		t.Errorf("fields = %+v, err = %v, the form must be flattened", fields, err)
+	}
+
+	rec = doPDF(e, "/pdf/form/fields", []testPart{{name: "pdf", data: string(testForm(t))}})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"acepta","type":"checkbox"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, parts := range [][]testPart{
+		{{name: "key", data: "missing.pdf"}, {name: "values", data: `{"nombre": "Ana"}`}},
+		{{name: "key", data: stored.Key}, {name: "values", data: `["Ana"]`}},
+		{{name: "key", data: stored.Key}, {name: "values", data: `{"acepta": "si"}`}},
+	} {
+		rec = doPDF(e, "/pdf/form/fill", parts)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want %d", parts, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
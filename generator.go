@@ -0,0 +1,470 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Renderer creates a PDF document from the input source.
+type Renderer interface {
+	Render(ctx context.Context, input io.Reader, options RenderOptions) ([]byte, error)
+}
+
+// RenderOptions are the settings of a single document. The zero value uses
+// the defaults of the renderer.
+type RenderOptions struct {
+	// Backend is the name of the rendering backend, by default the one
+	// configured in the Generator.
+	Backend string `json:"backend,omitempty"`
+	// PageSize is the paper size, e.g. A4, Letter, Legal.
+	PageSize string `json:"page_size,omitempty"`
+	// Orientation must be Portrait or Landscape.
+	Orientation string `json:"orientation,omitempty"`
+	// Margins in millimeters. nil uses the default margin of the renderer.
+	MarginTop    *float64 `json:"margin_top,omitempty"`
+	MarginBottom *float64 `json:"margin_bottom,omitempty"`
+	MarginLeft   *float64 `json:"margin_left,omitempty"`
+	MarginRight  *float64 `json:"margin_right,omitempty"`
+	// Grayscale generates the PDF in grayscale.
+	Grayscale bool `json:"grayscale,omitempty"`
+	// Title of the document, by default it's the title of the HTML.
+	Title string `json:"title,omitempty"`
+	// HeaderHTML and FooterHTML are HTML documents repeated on every page.
+	HeaderHTML string `json:"header_html,omitempty"`
+	FooterHTML string `json:"footer_html,omitempty"`
+	// TOC adds a table of contents built from the headings of the document.
+	TOC bool `json:"toc,omitempty"`
+	// Metadata replaces the properties of the backend, its Title is the
+	// Title of the options when it's empty.
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// Watermarks are texts and images added to the pages after the render.
+	Watermarks []Watermark `json:"watermarks,omitempty"`
+	// Optimize reduces the size of the PDF, e.g. with photos.
+	Optimize *Optimization `json:"optimize,omitempty"`
+	// Profile of the output, pdfa-2b for archival documents.
+	Profile string `json:"profile,omitempty"`
+	// Encryption protects the PDF with passwords and permissions.
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// Signature signs the PDF with the certificate of the client, it can't
+	// be encrypted.
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+var (
+	validPageSizes    = []string{"A3", "A4", "A5", "Letter", "Legal", "Tabloid"}
+	validOrientations = []string{"Portrait", "Landscape"}
+)
+
+// Validate checks that the options have supported values.
+func (o RenderOptions) Validate() error {
+	if o.PageSize != "" && !containsFold(validPageSizes, o.PageSize) {
+		return ErrorProcess{Msg: fmt.Sprintf("page size %q not supported", o.PageSize)}
+	}
+
+	if o.Orientation != "" && !containsFold(validOrientations, o.Orientation) {
+		return ErrorProcess{Msg: fmt.Sprintf("orientation %q not supported", o.Orientation)}
+	}
+
+	for _, margin := range []*float64{o.MarginTop, o.MarginBottom, o.MarginLeft, o.MarginRight} {
+		if margin != nil && *margin < 0 {
+			return ErrorProcess{Msg: "margins can't be negative"}
+		}
+	}
+
+	err := validateDocument(o.Metadata, o.Watermarks, o.Optimize, o.Profile, o.Encryption, o.Signature)
+	if err != nil {
+		return err
+	}
+
+	if o.Encryption != nil {
+		if o.Encryption.UserPasswordFromID {
+			return ErrorProcess{Msg: "user_password_from_id is only supported by the DIAN forms"}
+		}
+		return o.Encryption.Validate()
+	}
+
+	return nil
+}
+
+// metadata returns the Metadata of the document, with the Title of the
+// options when it doesn't have one. It's nil when the document keeps the
+// metadata of the backend.
+func (o RenderOptions) metadata() *Metadata {
+	if o.Metadata == nil && !isPDFA(o.Profile) {
+		return nil
+	}
+
+	var metadata Metadata
+	if o.Metadata != nil {
+		metadata = *o.Metadata
+	}
+	if metadata.Title == "" {
+		metadata.Title = o.Title
+	}
+
+	return &metadata
+}
+
+// validateDocument checks the options of the documents shared by the HTML
+// renders and the DIAN forms, each one checks its encryption.
+func validateDocument(metadata *Metadata, watermarks []Watermark, optimization *Optimization, profile string, encryption *Encryption, signature *Signature) error {
+	if metadata != nil {
+		err := metadata.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := validateWatermarks(watermarks)
+	if err != nil {
+		return err
+	}
+
+	if optimization != nil {
+		err = optimization.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateProfile(profile, watermarks, encryption)
+	if err != nil {
+		return err
+	}
+
+	return validateSignature(signature, encryption)
+}
+
+// needsPatchedQt reports whether the options use features that wkhtmltopdf
+// only supports when it's built with the patched Qt.
+func (o RenderOptions) needsPatchedQt() bool {
+	return o.patchedQtOption() != ""
+}
+
+// patchedQtOption returns the first option that needs the patched Qt, empty
+// when there isn't one.
+func (o RenderOptions) patchedQtOption() string {
+	switch {
+	case o.HeaderHTML != "":
+		return "header_html"
+	case o.FooterHTML != "":
+		return "footer_html"
+	case o.TOC:
+		return "toc"
+	default:
+		return ""
+	}
+}
+
+// ErrBackendUnavailable is returned when the backend isn't installed or
+// can't run in this installation.
+var ErrBackendUnavailable = errors.New("the backend is not available")
+
+// Backend is a rendering engine used by the Generator. Its name is the
+// value that selects it in the RenderOptions.
+type Backend interface {
+	Renderer
+	Name() string
+}
+
+// Features reported in the Capabilities of a backend.
+const (
+	FeatureHeaderFooter = "header_footer"
+	FeatureTOC          = "toc"
+)
+
+// Capabilities describe a backend in this installation: whether it can
+// render and which optional features it supports.
+type Capabilities struct {
+	Available bool     `json:"available"`
+	Version   string   `json:"version,omitempty"`
+	Path      string   `json:"path,omitempty"`
+	Features  []string `json:"features"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Has reports whether the feature is supported.
+func (c Capabilities) Has(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CapabilityDetector is implemented by the backends that depend on the
+// installation, e.g. on an external executable.
+type CapabilityDetector interface {
+	DetectCapabilities(ctx context.Context) Capabilities
+}
+
+// CapabilitiesReporter is implemented by the renderers that know the
+// capabilities of their backends.
+type CapabilitiesReporter interface {
+	Capabilities() map[string]Capabilities
+}
+
+// Generator is a Renderer that delegates every document to one of its
+// backends. By default, it has the wkhtmltopdf and the maroto backends and
+// uses wkhtmltopdf.
+type Generator struct {
+	backends       map[string]Backend
+	defaultBackend string
+
+	mu           sync.RWMutex
+	capabilities map[string]Capabilities
+
+	// Settings of the wkhtmltopdf backend
+	binaryPath   string
+	defaultFlags []string
+	tempDir      string
+
+	metrics *Metrics
+	signers *Signers
+}
+
+// GeneratorOption configures a Generator.
+type GeneratorOption func(*Generator)
+
+// WithBinaryPath sets the path of the wkhtmltopdf executable. By default,
+// it's resolved from the PATH.
+func WithBinaryPath(path string) GeneratorOption {
+	return func(g *Generator) {
+		g.binaryPath = path
+	}
+}
+
+// WithDefaultFlags sets flags passed to wkhtmltopdf on every render, before
+// the flags of the RenderOptions.
+func WithDefaultFlags(flags ...string) GeneratorOption {
+	return func(g *Generator) {
+		g.defaultFlags = flags
+	}
+}
+
+// WithTempDir sets the directory used by wkhtmltopdf for its temporary files.
+func WithTempDir(dir string) GeneratorOption {
+	return func(g *Generator) {
+		g.tempDir = dir
+	}
+}
+
+// WithMetrics records the resources of the wkhtmltopdf processes.
+func WithMetrics(metrics *Metrics) GeneratorOption {
+	return func(g *Generator) {
+		g.metrics = metrics
+	}
+}
+
+// WithSigners sets the certificates of the signatures, without them the
+// renders can't be signed.
+func WithSigners(signers *Signers) GeneratorOption {
+	return func(g *Generator) {
+		g.signers = signers
+	}
+}
+
+// WithBackend registers a backend, replacing the one with the same name.
+func WithBackend(backend Backend) GeneratorOption {
+	return func(g *Generator) {
+		g.backends[backend.Name()] = backend
+	}
+}
+
+// WithDefaultBackend sets the backend used when the RenderOptions don't
+// select one.
+func WithDefaultBackend(name string) GeneratorOption {
+	return func(g *Generator) {
+		g.defaultBackend = name
+	}
+}
+
+func NewGenerator(opts ...GeneratorOption) *Generator {
+	g := &Generator{
+		backends:       map[string]Backend{},
+		defaultBackend: BackendWKHTMLToPDF,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	// The built-in backends are only added if they weren't replaced by WithBackend.
+	if _, ok := g.backends[BackendWKHTMLToPDF]; !ok {
+		wkhtmltopdf := NewWKHTMLToPDF(g.binaryPath, g.defaultFlags, g.tempDir)
+		wkhtmltopdf.metrics = g.metrics
+		g.backends[BackendWKHTMLToPDF] = wkhtmltopdf
+	}
+	if _, ok := g.backends[BackendMaroto]; !ok {
+		g.backends[BackendMaroto] = NewMarotoHTML()
+	}
+
+	return g
+}
+
+// Render creates a PDF from input with the backend selected in the options,
+// finishes it with the watermarks, optimization, metadata and profile, and
+// encrypts or signs it when the options have an Encryption or a Signature.
+func (g *Generator) Render(ctx context.Context, input io.Reader, options RenderOptions) (pdf []byte, err error) {
+	ctx, span := tracer().Start(ctx, "render")
+	defer func() {
+		setOutputAttributes(span, pdf)
+		endSpan(span, err)
+	}()
+
+	backend, err := g.Backend(options.Backend)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("render.backend", backend.Name()))
+	// The signer is checked before the render, so it isn't wasted.
+	if options.Signature != nil {
+		_, err = g.signers.signer(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pdf, err = backend.Render(ctx, input, options)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf, err = finishPDF(ctx, pdf, options.metadata(), options.Watermarks, options.Optimize, options.Profile)
+	switch {
+	case err != nil:
+		return nil, err
+	case options.Encryption != nil:
+		return EncryptPDF(ctx, pdf, *options.Encryption)
+	case options.Signature != nil:
+		return g.signers.Sign(ctx, pdf, *options.Signature)
+	default:
+		return linearizeOptimized(ctx, pdf, options.Optimize), nil
+	}
+}
+
+// finishPDF applies the options of the output to a rendered PDF: the
+// watermarks, the optimization, and the metadata or the PDF/A profile that
+// includes it. The optimization goes before the metadata, its rewrite would
+// change the dates of the PDF/A.
+func finishPDF(ctx context.Context, pdf []byte, metadata *Metadata, watermarks []Watermark, optimization *Optimization, profile string) ([]byte, error) {
+	var err error
+	if len(watermarks) > 0 {
+		pdf, err = WatermarkPDF(ctx, pdf, watermarks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if optimization != nil {
+		var report OptimizeReport
+		pdf, report, err = optimizePDF(ctx, pdf, *optimization)
+		if err != nil {
+			return nil, err
+		}
+		addOptimizeReport(ctx, report)
+	}
+
+	switch {
+	case isPDFA(profile):
+		var m Metadata
+		if metadata != nil {
+			m = *metadata
+		}
+		return ConvertToPDFA(ctx, pdf, m)
+	case metadata != nil:
+		return SetMetadata(ctx, pdf, *metadata)
+	default:
+		return pdf, nil
+	}
+}
+
+// linearizeOptimized linearizes the finished PDF when it's optimized, as the
+// last change of the document. The encrypted and signed documents aren't
+// linearized, their rewrite or incremental update would undo it.
+func linearizeOptimized(ctx context.Context, pdf []byte, optimization *Optimization) []byte {
+	if optimization == nil {
+		return pdf
+	}
+
+	out, linearized := linearizePDF(ctx, pdf)
+	// The size after the optimization includes the one of the linearization.
+	addOptimizeReport(ctx, OptimizeReport{SizeAfter: len(out) - len(pdf), Linearized: linearized})
+
+	return out
+}
+
+// Backend returns the backend registered with the name, an empty name
+// returns the default backend.
+func (g *Generator) Backend(name string) (Backend, error) {
+	if name == "" {
+		name = g.defaultBackend
+	}
+
+	backend, ok := g.backends[name]
+	if !ok {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("backend %q not supported", name)}
+	}
+
+	return backend, nil
+}
+
+// Backends returns the names of the registered backends.
+func (g *Generator) Backends() []string {
+	names := make([]string, 0, len(g.backends))
+	for name := range g.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DetectCapabilities checks every backend and keeps the result, it's meant
+// to be called at startup. The backends that aren't a CapabilityDetector
+// are always available.
+func (g *Generator) DetectCapabilities(ctx context.Context) map[string]Capabilities {
+	capabilities := make(map[string]Capabilities, len(g.backends))
+	for name, backend := range g.backends {
+		detector, ok := backend.(CapabilityDetector)
+		if !ok {
+			capabilities[name] = Capabilities{Available: true, Features: []string{}}
+			continue
+		}
+
+		capabilities[name] = detector.DetectCapabilities(ctx)
+	}
+
+	g.mu.Lock()
+	g.capabilities = capabilities
+	g.mu.Unlock()
+
+	return capabilities
+}
+
+// Capabilities returns the result of the last DetectCapabilities, nil if
+// it was never called.
+func (g *Generator) Capabilities() map[string]Capabilities {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.capabilities
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,50 +1,137 @@
 package gohtmltopdf
 
 import (
-	"bytes"
-	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
-type Handler struct{}
+type Handler struct {
+	renderer  Renderer
+	readiness *Readiness
+	metrics   *Metrics
+	pool      *Pool
+	jobs      *Jobs
+	results   *Results
+	cache     *Cache
+	limiter   *RateLimiter
+	signers   *Signers
+}
+
+// HandlerConfig are the optional dependencies of the Handler, a nil
+// dependency disables its feature.
+type HandlerConfig struct {
+	Readiness *Readiness
+	Metrics   *Metrics
+	Pool      *Pool
+	Jobs      *Jobs
+	Results   *Results
+	Cache     *Cache
+	// Limiter counts the pages of the renders in the quotas.
+	Limiter *RateLimiter
+	// Signers sign the DIAN forms, the Generator has its own for the HTML
+	// renders.
+	Signers *Signers
+}
 
-func NewHandler() Handler {
-	return Handler{}
+func NewHandler(renderer Renderer, cfg HandlerConfig) Handler {
+	return Handler{
+		renderer:  renderer,
+		readiness: cfg.Readiness,
+		metrics:   cfg.Metrics,
+		pool:      cfg.Pool,
+		jobs:      cfg.Jobs,
+		results:   cfg.Results,
+		cache:     cfg.Cache,
+		limiter:   cfg.Limiter,
+		signers:   cfg.Signers,
+	}
 }
 
 func (h Handler) CreateHTMLToPDF(c echo.Context) error {
+	defer h.metrics.TrackInFlight(EndpointHTMLToPDF)()
+	h.metrics.ObserveInput(EndpointHTMLToPDF, c.Request().ContentLength)
+
 	req := requestHTML{}
+	_, span := tracer().Start(c.Request().Context(), "bind")
 	err := c.Bind(&req)
+	endSpan(span, err)
 	if err != nil {
-		errMsg := map[string]string{"msg": "can't bind requestHTML", "error": err.Error()}
-		c.Logger().Error(errMsg)
-		return c.JSON(http.StatusBadRequest, errMsg)
+		return errorResponse(c, http.StatusBadRequest, "can't bind requestHTML", err)
+	}
+	if req.Store && h.results == nil {
+		return errorResponse(c, http.StatusBadRequest, "can't create the PDF", errStorageDisabled)
+	}
+
+	ctx := c.Request().Context()
+	backend := backendName(h.renderer, req.Options)
+	// The encrypted PDFs aren't cached, their passwords would be in the key,
+	// neither the signed ones, their signing time must be the one of the
+	// request.
+	var key string
+	if h.cache != nil && req.Options.Encryption == nil && req.Options.Signature == nil {
+		key = RenderCacheKey(backend, req.Options, []byte(req.Data))
+	}
+	if entry, ok := h.cacheGet(c, EndpointHTMLToPDF, key, req.NoCache); ok {
+		h.limiter.AddPages(ctx, entry.Data, "")
+		return h.respondPDF(c, entry.Data, req.Store)
 	}
 
-	src := bytes.NewBufferString(req.Data)
-	gen := NewGenerator(src)
-	pdf, err := gen.run(context.Background())
+	src := strings.NewReader(req.Data)
+	start := time.Now()
+	var pdf []byte
+	renderCtx, optimized := contextWithOptimizeReport(ctx)
+	err = h.pool.Do(ctx, func() error {
+		pdf, err = h.renderer.Render(renderCtx, src, req.Options)
+		return err
+	})
+	h.metrics.ObserveRender(ctx, EndpointHTMLToPDF, backend, outcome(err), time.Since(start), len(pdf))
 	if err != nil {
-		errMsg := map[string]string{"msg": "can't create the PDF", "error": err.Error()}
-		c.Logger().Error(errMsg)
-		return c.JSON(http.StatusInternalServerError, errMsg)
+		if errors.As(err, &ErrorProcess{}) {
+			return errorResponse(c, http.StatusBadRequest, "can't create the PDF", err)
+		}
+		if errors.Is(err, ErrBackendUnavailable) {
+			return errorResponse(c, http.StatusServiceUnavailable, "can't create the PDF", err)
+		}
+
+		return errorResponse(c, http.StatusInternalServerError, "can't create the PDF", err)
 	}
 
-	return c.JSON(http.StatusOK, map[string][]byte{"data": pdf})
+	h.cache.Set(key, CacheEntry{Data: pdf, Optimization: *optimized})
+	h.limiter.AddPages(ctx, pdf, req.Options.Encryption.userPassword(nil))
+	LoggerFromContext(ctx).Info("pdf rendered",
+		"endpoint", EndpointHTMLToPDF,
+		"backend", backend,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"size_bytes", len(pdf),
+	)
+	setOptimizeHeaders(c, optimized)
+
+	return h.respondPDF(c, pdf, req.Store)
 }
 
 func (h Handler) CreateDianForm220(c echo.Context) error {
+	defer h.metrics.TrackInFlight(EndpointDIANForm220)()
+	h.metrics.ObserveInput(EndpointDIANForm220, c.Request().ContentLength)
+
 	req := requestDIANForm220{}
+	_, span := tracer().Start(c.Request().Context(), "bind")
 	err := c.Bind(&req)
+	endSpan(span, err)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "can't bind requestDIANForm220", err)
+	}
+	if req.Store && h.results == nil {
+		return errorResponse(c, http.StatusBadRequest, "can't create the PDF", errStorageDisabled)
+	}
+	err = req.validate(false)
 	if err != nil {
-		errMsg := map[string]string{"msg": "can't bind requestDIANForm220", "error": err.Error()}
-		c.Logger().Error(errMsg)
-		return c.JSON(http.StatusBadRequest, errMsg)
+		return errorResponse(c, http.StatusBadRequest, "can't create the PDF", err)
 	}
 
 	// If we need to debug the performance, we can set the query param debug=true
@@ -54,37 +141,267 @@ func (h Handler) CreateDianForm220(c echo.Context) error {
 		isDebug = true
 	}
 
-	dian := NewDIAN(isDebug)
-	pdf, err := dian.CreateDIANForm220(req.Data)
+	ctx := c.Request().Context()
+	var key string
+	if h.cache != nil && req.Encryption == nil && req.Signature == nil {
+		key = dianCacheKey(req)
+	}
+	if entry, ok := h.cacheGet(c, EndpointDIANForm220, key, req.NoCache || isDebug); ok {
+		h.limiter.AddPages(ctx, entry.Data, "")
+		return h.respondPDF(c, entry.Data, req.Store)
+	}
+
+	dian := req.dianForm(h.metrics, h.signers)
+	dian.isDebug = isDebug
+	start := time.Now()
+	var pdf []byte
+	renderCtx, optimized := contextWithOptimizeReport(ctx)
+	err = h.pool.Do(ctx, func() error {
+		pdf, err = dian.CreateDIANForm220(renderCtx, req.Data)
+		return err
+	})
+	h.metrics.ObserveRender(ctx, EndpointDIANForm220, BackendMaroto, outcome(err), time.Since(start), len(pdf))
 	if err != nil {
 		if errors.As(err, &ErrorProcess{}) {
-			errMsg := map[string]string{"msg": "can't create the PDF", "error": err.Error()}
-			c.Logger().Error(errMsg)
-			return c.JSON(http.StatusBadRequest, errMsg)
+			return errorResponse(c, http.StatusBadRequest, "can't create the PDF", err)
 		}
 
-		errMsg := map[string]string{"msg": "can't create the PDF", "error": err.Error()}
-		c.Logger().Error(errMsg)
-		return c.JSON(http.StatusInternalServerError, errMsg)
+		return errorResponse(c, http.StatusInternalServerError, "can't create the PDF", err)
 	}
 
-	return c.JSON(http.StatusOK, map[string][]byte{"data": pdf})
+	// The forms have the income of the employees, they are sensitive.
+	h.cache.Set(key, CacheEntry{Data: pdf, Optimization: *optimized, Sensitive: true})
+	h.limiter.AddPages(ctx, pdf, req.Encryption.userPassword(req.Data))
+	LoggerFromContext(ctx).Info("pdf rendered",
+		"endpoint", EndpointDIANForm220,
+		"backend", BackendMaroto,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"size_bytes", len(pdf),
+		"employees", len(req.Data),
+	)
+	setOptimizeHeaders(c, optimized)
+
+	return h.respondPDF(c, pdf, req.Store)
 }
 
-func (h Handler) Health(c echo.Context) error {
-	return c.JSON(http.StatusOK, map[string]string{"date": time.Now().String()})
+// cacheGet returns the PDF of the cache and sets the X-Cache header and the
+// headers of its optimization. The bypass skips the lookup, the new PDF is
+// still saved. An empty key isn't cached.
+func (h Handler) cacheGet(c echo.Context, endpoint, key string, bypass bool) (CacheEntry, bool) {
+	if h.cache == nil || key == "" {
+		return CacheEntry{}, false
+	}
+
+	var entry CacheEntry
+	ok := false
+	if !bypass {
+		entry, ok = h.cache.Get(key)
+	}
+	h.metrics.ObserveCache(endpoint, ok)
+	if !ok {
+		c.Response().Header().Set(HeaderCache, CacheMiss)
+		return CacheEntry{}, false
+	}
+
+	c.Response().Header().Set(HeaderCache, CacheHit)
+	setOptimizeHeaders(c, &entry.Optimization)
+	LoggerFromContext(c.Request().Context()).Info("pdf from cache", "endpoint", endpoint, "size_bytes", len(entry.Data))
+
+	return entry, true
 }
 
-const ParamInternalCode = "x-internalcode"
+// setOptimizeHeaders sets the sizes of the optimized documents, the response
+// doesn't have them when the document wasn't optimized.
+func setOptimizeHeaders(c echo.Context, report *OptimizeReport) {
+	if report.Documents == 0 {
+		return
+	}
+
+	c.Response().Header().Set(HeaderOriginalSize, strconv.Itoa(report.SizeBefore))
+	c.Response().Header().Set(HeaderOptimizedSize, strconv.Itoa(report.SizeAfter))
+	c.Response().Header().Set(HeaderLinearized, strconv.FormatBool(report.Linearized == report.Documents))
+}
+
+// CreateJob queues an asynchronous render, the response has the job ID to
+// poll its status.
+func (h Handler) CreateJob(c echo.Context) error {
+	req := JobRequest{}
+	err := c.Bind(&req)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "can't bind JobRequest", err)
+	}
+
+	ctx := c.Request().Context()
+	if client, ok := ClientFromContext(ctx); ok && !client.Has(jobScope(req.Type)) {
+		return errorResponse(c, http.StatusForbidden, "can't authorize the request", fmt.Errorf("the client doesn't have the scope %q", jobScope(req.Type)))
+	}
 
-// ValidateInternalCode to validate the internal code
-func (h Handler) ValidateInternalCode(next echo.HandlerFunc, internalCode string) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		internalReceived := c.Request().Header.Get(ParamInternalCode)
-		if internalReceived != internalCode {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "The header x-internal code sent is not valid"})
+	job, err := h.jobs.Submit(ctx, req)
+	if err != nil {
+		if errors.As(err, &ErrorProcess{}) {
+			return errorResponse(c, http.StatusBadRequest, "can't create the job", err)
+		}
+		if errors.Is(err, ErrQueueFull) {
+			return errorResponse(c, http.StatusServiceUnavailable, "can't create the job", err)
 		}
 
-		return next(c)
+		return errorResponse(c, http.StatusInternalServerError, "can't create the job", err)
 	}
+
+	LoggerFromContext(ctx).Info("job queued", "job_id", job.ID, "job_type", job.Type)
+	c.Response().Header().Set(echo.HeaderLocation, "/jobs/"+job.ID)
+
+	return c.JSON(http.StatusAccepted, job)
 }
+
+// GetJob returns the status and progress of a job.
+func (h Handler) GetJob(c echo.Context) error {
+	job, err := h.jobs.Get(c.Param("id"))
+	if err == nil && !ownsJob(c, job) {
+		err = ErrJobNotFound
+	}
+	if err != nil {
+		return errorResponse(c, http.StatusNotFound, "can't get the job", err)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// GetJobResult downloads the PDF or ZIP of a done job.
+func (h Handler) GetJobResult(c echo.Context) error {
+	job, result, err := h.jobs.Result(c.Param("id"))
+	if err == nil && !ownsJob(c, job) {
+		err = ErrJobNotFound
+	}
+	if err != nil {
+		return errorResponse(c, http.StatusNotFound, "can't get the job result", err)
+	}
+	if job.Status != JobDone {
+		return c.JSON(http.StatusConflict, job)
+	}
+
+	extension := "pdf"
+	if job.ContentType == ContentTypeZIP {
+		extension = "zip"
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", job.ID+"."+extension))
+
+	return c.Blob(http.StatusOK, job.ContentType, result)
+}
+
+// ownsJob reports whether the client of the request can see the job, the
+// jobs of the other clients are only visible with ScopeAll.
+func ownsJob(c echo.Context, job Job) bool {
+	client, ok := ClientFromContext(c.Request().Context())
+	if !ok {
+		return true
+	}
+
+	return job.Client == client.Name || client.Has(ScopeAll)
+}
+
+// jobScope is the scope needed to create a job of the type.
+func jobScope(jobType string) string {
+	if jobType == EndpointDIANForm220 {
+		return ScopeDIANRender
+	}
+
+	return ScopeHTMLRender
+}
+
+// DownloadResult serves a document of the LocalStorage, the URL must have a
+// valid signature instead of an API key.
+func (h Handler) DownloadResult(c echo.Context) error {
+	local, ok := h.results.storage.(*LocalStorage)
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	key := c.Param("key")
+	err := local.Verify(key, c.QueryParam("expires"), c.QueryParam("signature"))
+	if err != nil {
+		return errorResponse(c, http.StatusForbidden, "can't download the result", err)
+	}
+
+	data, err := local.Get(c.Request().Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrResultNotFound) {
+			return errorResponse(c, http.StatusNotFound, "can't download the result", err)
+		}
+
+		return errorResponse(c, http.StatusInternalServerError, "can't download the result", err)
+	}
+
+	contentType := ContentTypePDF
+	if strings.HasSuffix(key, ".zip") {
+		contentType = ContentTypeZIP
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", key))
+
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+var errStorageDisabled = errors.New("the storage of the results isn't enabled")
+
+// respondPDF writes the PDF as base64 in the JSON response, or stores it and
+// responds its download URL.
+func (h Handler) respondPDF(c echo.Context, pdf []byte, store bool) error {
+	return h.respondFile(c, pdf, ContentTypePDF, store)
+}
+
+// respondFile is respondPDF for a PDF or a ZIP.
+func (h Handler) respondFile(c echo.Context, data []byte, contentType string, store bool) error {
+	if store {
+		result, err := h.results.Store(c.Request().Context(), data, contentType)
+		if err != nil {
+			return errorResponse(c, http.StatusInternalServerError, "can't store the result", err)
+		}
+
+		return c.JSON(http.StatusOK, result)
+	}
+
+	_, span := tracer().Start(c.Request().Context(), "respond")
+	defer span.End()
+
+	return c.JSON(http.StatusOK, map[string][]byte{"data": data})
+}
+
+// backendName returns the backend that renders the options, for the metrics.
+func backendName(renderer Renderer, options RenderOptions) string {
+	gen, ok := renderer.(*Generator)
+	if !ok {
+		return "custom"
+	}
+
+	// The name of an unknown backend isn't used as label, it comes from the request.
+	backend, err := gen.Backend(options.Backend)
+	if err != nil {
+		return "unknown"
+	}
+
+	return backend.Name()
+}
+
+func (h Handler) Health(c echo.Context) error {
+	resp := map[string]any{"date": time.Now().String()}
+	if reporter, ok := h.renderer.(CapabilitiesReporter); ok {
+		resp["backends"] = reporter.Capabilities()
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Ready renders a small document with every backend, it responds 503 if the
+// service can't create PDFs.
+func (h Handler) Ready(c echo.Context) error {
+	report := h.readiness.Check(c.Request().Context())
+	if !report.Ready {
+		return c.JSON(http.StatusServiceUnavailable, report)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// ParamInternalCode is the header of the old clients, its value is
+// authenticated as an API key.
+const ParamInternalCode = "x-internalcode"
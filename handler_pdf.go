@@ -0,0 +1,477 @@
+package gohtmltopdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Limits of the multipart requests of the /pdf endpoints.
+const (
+	maxPDFParts = 50
+	maxPDFBytes = 100 << 20
+)
+
+var (
+	errEncryptedPart = errors.New("the documents can't be encrypted")
+	errSignedPart    = errors.New("the documents can't be signed, the operation would invalidate the signature")
+)
+
+// pdfInput is a document of a /pdf request, an uploaded PDF, a stored one
+// or a render.
+type pdfInput struct {
+	pdf      []byte
+	key      string
+	html     *requestHTML
+	dian     *requestDIANForm220
+	bookmark string
+}
+
+// pdfOperation processes the documents of a request with its fields, and
+// returns the result and its content type.
+type pdfOperation func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error)
+
+// MergePDF joins the documents of a multipart request in order: uploaded
+// PDFs in the `pdf` parts, stored ones in the `key` parts and renders in the
+// `html` and `dian` parts. The bookmark of an upload or a stored document is
+// its X-Bookmark header, the one of a render the `bookmark` field of its
+// JSON.
+func (h Handler) MergePDF(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFMerge, maxPDFParts, nil, func(ctx context.Context, docs []MergePart, _ url.Values) ([]byte, string, error) {
+		pdf, err := MergePDFs(ctx, docs)
+		return pdf, ContentTypePDF, err
+	})
+}
+
+// SplitPDF responds a ZIP with the parts of the document, by `every` pages
+// or by each `range` field.
+func (h Handler) SplitPDF(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFSplit, 1, []string{"every", "range"}, func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error) {
+		var parts [][]byte
+		var err error
+		if ranges := fields["range"]; len(ranges) > 0 {
+			parts, err = SplitPDFByRanges(ctx, docs[0].PDF, ranges)
+		} else {
+			every, convErr := strconv.Atoi(fields.Get("every"))
+			if convErr != nil {
+				return nil, "", ErrorProcess{Msg: "the split needs the every or range fields"}
+			}
+			parts, err = SplitPDF(ctx, docs[0].PDF, every)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		archive, err := zipPDFs(parts)
+		return archive, ContentTypeZIP, err
+	})
+}
+
+// ExtractPDFPages responds a PDF with the selected `pages`.
+func (h Handler) ExtractPDFPages(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFExtract, 1, []string{"pages"}, func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error) {
+		pdf, err := ExtractPages(ctx, docs[0].PDF, fields.Get("pages"))
+		return pdf, ContentTypePDF, err
+	})
+}
+
+// RotatePDFPages rotates the `pages`, all by default, by the `degrees`.
+func (h Handler) RotatePDFPages(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFRotate, 1, []string{"degrees", "pages"}, func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error) {
+		degrees, err := strconv.Atoi(fields.Get("degrees"))
+		if err != nil {
+			return nil, "", ErrorProcess{Msg: "the degrees must be a number"}
+		}
+
+		pdf, err := RotatePages(ctx, docs[0].PDF, degrees, fields.Get("pages"))
+		return pdf, ContentTypePDF, err
+	})
+}
+
+// ReorderPDFPages responds a PDF with the pages in the `order`.
+func (h Handler) ReorderPDFPages(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFReorder, 1, []string{"order"}, func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error) {
+		pdf, err := ReorderPages(ctx, docs[0].PDF, fields.Get("order"))
+		return pdf, ContentTypePDF, err
+	})
+}
+
+// OptimizePDF responds the document optimized with the `image_dpi` and
+// `jpeg_quality` fields, its sizes are in the X-Original-Size and
+// X-Optimized-Size headers and its linearization in X-Linearized.
+func (h Handler) OptimizePDF(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFOptimize, 1, []string{"image_dpi", "jpeg_quality"}, func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error) {
+		var optimization Optimization
+		var err error
+		if dpi := fields.Get("image_dpi"); dpi != "" {
+			optimization.ImageDPI, err = strconv.Atoi(dpi)
+			if err != nil {
+				return nil, "", ErrorProcess{Msg: "the image_dpi must be a number"}
+			}
+		}
+		if quality := fields.Get("jpeg_quality"); quality != "" {
+			optimization.JPEGQuality, err = strconv.Atoi(quality)
+			if err != nil {
+				return nil, "", ErrorProcess{Msg: "the jpeg_quality must be a number"}
+			}
+		}
+
+		pdf, _, err := OptimizePDF(ctx, docs[0].PDF, optimization)
+		return pdf, ContentTypePDF, err
+	})
+}
+
+// ListPDFFormFields responds the fields of the AcroForm of the document.
+func (h Handler) ListPDFFormFields(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFFormFields, 1, nil, func(ctx context.Context, docs []MergePart, _ url.Values) ([]byte, string, error) {
+		fields, err := FormFields(ctx, docs[0].PDF)
+		if err != nil {
+			return nil, "", err
+		}
+
+		report, err := json.Marshal(map[string][]FormField{"fields": fields})
+		return report, ContentTypeJSON, err
+	})
+}
+
+// GetPDFFormFields responds the fields of the AcroForm of a stored
+// template.
+func (h Handler) GetPDFFormFields(c echo.Context) error {
+	if h.results == nil {
+		return errorResponse(c, http.StatusBadRequest, "can't get the form fields", errStorageDisabled)
+	}
+
+	ctx := c.Request().Context()
+	withoutRender(ctx)
+	pdf, err := h.results.Load(ctx, c.Param("key"))
+	if err == nil {
+		var fields []FormField
+		fields, err = FormFields(ctx, pdf)
+		if err == nil {
+			return c.JSON(http.StatusOK, map[string][]FormField{"fields": fields})
+		}
+	}
+	switch {
+	case errors.Is(err, ErrResultNotFound):
+		return errorResponse(c, http.StatusNotFound, "can't get the form fields", err)
+	case errors.As(err, &ErrorProcess{}):
+		return errorResponse(c, http.StatusBadRequest, "can't get the form fields", err)
+	default:
+		return errorResponse(c, http.StatusInternalServerError, "can't get the form fields", err)
+	}
+}
+
+// FillPDFForm fills the AcroForm of the document with the `values` field, a
+// JSON object by field name, and flattens it when `flatten` is true.
+func (h Handler) FillPDFForm(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFFormFill, 1, []string{"values", "flatten"}, func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error) {
+		var values map[string]any
+		err := json.Unmarshal([]byte(fields.Get("values")), &values)
+		if err != nil || len(values) == 0 {
+			return nil, "", ErrorProcess{Msg: "the values must be a JSON object with the values of the fields"}
+		}
+
+		pdf, err := FillForm(ctx, docs[0].PDF, values, strings.EqualFold(fields.Get("flatten"), "true"))
+		return pdf, ContentTypePDF, err
+	})
+}
+
+// InspectPDF responds the Inspection of the document, opened with the
+// `password` field, with the texts of the pages when `text` is true.
+func (h Handler) InspectPDF(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFInspect, 1, []string{"password", "text"}, func(ctx context.Context, docs []MergePart, fields url.Values) ([]byte, string, error) {
+		inspection, err := InspectPDF(ctx, docs[0].PDF, InspectOptions{
+			Password: fields.Get("password"),
+			Text:     strings.EqualFold(fields.Get("text"), "true"),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		report, err := json.Marshal(inspection)
+		return report, ContentTypeJSON, err
+	})
+}
+
+// VerifyPDFSignatures responds the Verification of the signatures of the
+// document.
+func (h Handler) VerifyPDFSignatures(c echo.Context) error {
+	return h.processPDF(c, EndpointPDFVerify, 1, nil, func(ctx context.Context, docs []MergePart, _ url.Values) ([]byte, string, error) {
+		verification, err := VerifyPDF(ctx, docs[0].PDF)
+		if err != nil {
+			return nil, "", err
+		}
+
+		report, err := json.Marshal(verification)
+		return report, ContentTypeJSON, err
+	})
+}
+
+// processPDF reads the documents and fields of a multipart request, renders
+// the documents and applies the operation to them. Only the renders and
+// their pages are counted in the quota, the uploaded and stored documents
+// aren't produced by the request. The JSON results are reports, they are
+// responded as they are and aren't stored.
+func (h Handler) processPDF(c echo.Context, endpoint string, maxDocs int, fields []string, op pdfOperation) error {
+	defer h.metrics.TrackInFlight(endpoint)()
+	h.metrics.ObserveInput(endpoint, c.Request().ContentLength)
+
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxPDFBytes)
+	inputs, values, err := readPDFParts(c, fields)
+	if err == nil && len(inputs) > maxDocs {
+		err = fmt.Errorf("the request can't have more than %d documents", maxDocs)
+	}
+	if err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			return errorResponse(c, http.StatusRequestEntityTooLarge, "can't read the parts", err)
+		}
+
+		return errorResponse(c, http.StatusBadRequest, "can't read the parts", err)
+	}
+	store := strings.EqualFold(values.Get("store"), "true")
+	if store && h.results == nil {
+		return errorResponse(c, http.StatusBadRequest, "can't process the PDF", errStorageDisabled)
+	}
+
+	ctx := c.Request().Context()
+	if client, ok := ClientFromContext(ctx); ok {
+		for _, input := range inputs {
+			if scope := input.scope(); !client.Has(scope) {
+				return errorResponse(c, http.StatusForbidden, "can't authorize the request", fmt.Errorf("the client doesn't have the scope %q", scope))
+			}
+		}
+	}
+
+	start := time.Now()
+	var docs []MergePart
+	var result []byte
+	var contentType string
+	// The optimizations of the renders aren't in the report of the
+	// operation.
+	opCtx, optimized := contextWithOptimizeReport(ctx)
+	err = h.pool.Do(ctx, func() error {
+		docs, err = h.renderInputs(ctx, inputs)
+		if err != nil {
+			return err
+		}
+
+		result, contentType, err = op(opCtx, docs, values)
+		return err
+	})
+	h.metrics.ObserveRender(ctx, endpoint, BackendPDFCPU, outcome(err), time.Since(start), len(result))
+	if err != nil {
+		if errors.As(err, &ErrorProcess{}) {
+			return errorResponse(c, http.StatusBadRequest, "can't process the PDF", err)
+		}
+		if errors.Is(err, ErrBackendUnavailable) {
+			return errorResponse(c, http.StatusServiceUnavailable, "can't process the PDF", err)
+		}
+
+		return errorResponse(c, http.StatusInternalServerError, "can't process the PDF", err)
+	}
+
+	rendered := false
+	for i, doc := range docs {
+		if inputs[i].scope() != "" {
+			h.limiter.AddPages(ctx, doc.PDF, "")
+			rendered = true
+		}
+	}
+	if !rendered {
+		withoutRender(ctx)
+	}
+	LoggerFromContext(ctx).Info("pdf processed",
+		"endpoint", endpoint,
+		"documents", len(docs),
+		"duration_ms", time.Since(start).Milliseconds(),
+		"size_bytes", len(result),
+	)
+	if contentType == ContentTypeJSON {
+		return c.JSONBlob(http.StatusOK, result)
+	}
+	setOptimizeHeaders(c, optimized)
+
+	return h.respondFile(c, result, contentType, store)
+}
+
+// renderInputs renders the documents of the html and dian parts and loads
+// the stored ones, the uploaded PDFs are used as they are.
+func (h Handler) renderInputs(ctx context.Context, inputs []pdfInput) ([]MergePart, error) {
+	docs := make([]MergePart, len(inputs))
+	for i, input := range inputs {
+		docs[i] = MergePart{PDF: input.pdf, Bookmark: input.bookmark}
+
+		var err error
+		switch {
+		case input.key != "":
+			docs[i].PDF, err = h.storedPDF(ctx, input.key)
+		case input.html != nil:
+			docs[i].PDF, err = h.renderer.Render(ctx, strings.NewReader(input.html.Data), input.html.Options)
+		case input.dian != nil:
+			docs[i].PDF, err = input.dian.dianForm(h.metrics, h.signers).CreateDIANForm220(ctx, input.dian.Data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't render the document %d: %w", i+1, err)
+		}
+	}
+
+	return docs, nil
+}
+
+// storedPDF loads a document of the storage of the results.
+func (h Handler) storedPDF(ctx context.Context, key string) ([]byte, error) {
+	if h.results == nil {
+		return nil, ErrorProcess{Msg: errStorageDisabled.Error()}
+	}
+
+	pdf, err := h.results.Load(ctx, key)
+	if errors.Is(err, ErrResultNotFound) {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the document %q isn't stored", key)}
+	}
+
+	return pdf, err
+}
+
+// scope is the scope needed to render the document.
+func (i pdfInput) scope() string {
+	switch {
+	case i.html != nil:
+		return ScopeHTMLRender
+	case i.dian != nil:
+		return ScopeDIANRender
+	default:
+		return ""
+	}
+}
+
+// readPDFParts reads the documents in their order, and the values of the
+// fields and `store`.
+func readPDFParts(c echo.Context, fields []string) ([]pdfInput, url.Values, error) {
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var inputs []pdfInput
+	values := url.Values{}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := part.FormName()
+		input := pdfInput{}
+		switch {
+		case name == "store" || slices.Contains(fields, name):
+			values.Add(name, string(data))
+			continue
+		case name == "pdf":
+			input.pdf = data
+			input.bookmark = part.Header.Get("X-Bookmark")
+		case name == "key":
+			input.key = strings.TrimSpace(string(data))
+			input.bookmark = part.Header.Get("X-Bookmark")
+			if input.key == "" {
+				err = errors.New("the key of the stored document is empty")
+			}
+		case name == "html":
+			input.html = &requestHTML{}
+			input.bookmark, err = decodePDFPart(data, input.html)
+			if err == nil {
+				err = input.html.Options.Validate()
+			}
+			if err == nil && input.html.Options.Encryption != nil {
+				err = errEncryptedPart
+			}
+			if err == nil && input.html.Options.Signature != nil {
+				err = errSignedPart
+			}
+		case name == "dian":
+			input.dian = &requestDIANForm220{}
+			input.bookmark, err = decodePDFPart(data, input.dian)
+			if err == nil && len(input.dian.Data) == 0 {
+				err = errors.New("no data to generate PDF")
+			}
+			if err == nil && input.dian.Encryption != nil {
+				err = errEncryptedPart
+			}
+			if err == nil && input.dian.Signature != nil {
+				err = errSignedPart
+			}
+		default:
+			err = fmt.Errorf("unknown part %q", name)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("part %d: %w", len(inputs)+1, err)
+		}
+
+		inputs = append(inputs, input)
+		if len(inputs) > maxPDFParts {
+			return nil, nil, fmt.Errorf("the request can't have more than %d documents", maxPDFParts)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, nil, errors.New("there aren't documents, send a pdf, key, html or dian part")
+	}
+
+	return inputs, values, nil
+}
+
+// decodePDFPart reads the JSON of a render part and its bookmark.
+func decodePDFPart(data []byte, req any) (string, error) {
+	err := json.Unmarshal(data, req)
+	if err != nil {
+		return "", err
+	}
+
+	bookmark := struct {
+		Bookmark string `json:"bookmark"`
+	}{}
+	_ = json.Unmarshal(data, &bookmark)
+
+	return bookmark.Bookmark, nil
+}
+
+// zipPDFs returns a ZIP with the documents named part-1.pdf, part-2.pdf...
+func zipPDFs(pdfs [][]byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	archive := zip.NewWriter(&buf)
+	for i, pdf := range pdfs {
+		f, err := archive.Create(fmt.Sprintf("part-%d.pdf", i+1))
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(pdf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,562 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// wordSpacing is the adjustment of a TJ array, in thousandths of the font
+// size, taken as a space between words.
+const wordSpacing = -200
+
+// InspectOptions are the password of an encrypted PDF, and whether the
+// texts of the pages are extracted.
+type InspectOptions struct {
+	Password string `json:"password,omitempty"`
+	Text     bool   `json:"text,omitempty"`
+}
+
+// Inspection describes a PDF.
+type Inspection struct {
+	Version   string     `json:"version"`
+	Pages     int        `json:"pages"`
+	PageSizes []PageSize `json:"page_sizes"`
+	// Metadata has the text entries of the Info dictionary.
+	Metadata  map[string]string `json:"metadata"`
+	Fonts     []PDFFont         `json:"fonts"`
+	Encrypted bool              `json:"encrypted"`
+	// Texts are the texts of the pages when InspectOptions.Text is set, a
+	// line by text line.
+	Texts []string `json:"texts,omitempty"`
+}
+
+// PageSize is the size in points of a page as it's shown, after its
+// rotation.
+type PageSize struct {
+	Width    float64 `json:"width"`
+	Height   float64 `json:"height"`
+	Rotation int     `json:"rotation"`
+}
+
+// PDFFont is a font of the pages, its name doesn't have the prefix of the
+// subsets.
+type PDFFont struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Embedded bool   `json:"embedded"`
+	Subset   bool   `json:"subset"`
+	Pages    []int  `json:"pages"`
+}
+
+// InspectPDF returns the pages, metadata, fonts and encryption of the PDF,
+// and the texts of its pages with the Text option. The texts are extracted
+// from the content of the pages and their forms, in the order they are
+// drawn; the fonts without a Unicode map or a known encoding aren't
+// decoded.
+func InspectPDF(ctx context.Context, pdf []byte, options InspectOptions) (inspection Inspection, err error) {
+	_, span := tracer().Start(ctx, "inspect")
+	defer func() {
+		span.SetAttributes(attribute.Int("inspect.pages", inspection.Pages))
+		endSpan(span, err)
+	}()
+
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW = options.Password
+	pdfCtx, err := api.ReadContext(bytes.NewReader(pdf), conf)
+	if err == nil {
+		err = pdfCtx.EnsurePageCount()
+	}
+	if err != nil {
+		return Inspection{}, ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or its password is wrong: %v", err)}
+	}
+
+	inspection = Inspection{
+		Version:   pdfCtx.VersionString(),
+		Pages:     pdfCtx.PageCount,
+		Encrypted: pdfCtx.Encrypt != nil,
+		Fonts:     []PDFFont{},
+	}
+	inspection.Metadata, err = pdfInfo(pdfCtx)
+	if err != nil {
+		return Inspection{}, fmt.Errorf("can't read the metadata: %w", err)
+	}
+
+	boundaries, err := pdfCtx.PageBoundaries(nil)
+	if err != nil {
+		return Inspection{}, fmt.Errorf("can't read the page sizes: %w", err)
+	}
+	for _, pb := range boundaries {
+		dim := pb.CropBox().Dimensions()
+		if pb.Rot%180 != 0 {
+			dim.Width, dim.Height = dim.Height, dim.Width
+		}
+		inspection.PageSizes = append(inspection.PageSizes, PageSize{Width: dim.Width, Height: dim.Height, Rotation: pb.Rot})
+	}
+
+	fonts := map[int]int{}
+	decoders := map[int]*fontDecoder{}
+	for i := 1; i <= pdfCtx.PageCount; i++ {
+		pageDict, _, inherited, err := pdfCtx.PageDict(i, false)
+		if err != nil {
+			return Inspection{}, err
+		}
+		inspectFonts(pdfCtx, inherited.Resources, i, fonts, &inspection.Fonts, 0)
+
+		if !options.Text {
+			continue
+		}
+		// A page that can't be decoded doesn't have text.
+		content, err := pdfCtx.PageContent(pageDict)
+		if err != nil {
+			inspection.Texts = append(inspection.Texts, "")
+			continue
+		}
+		text := textExtractor{pdfCtx: pdfCtx, decoders: decoders}
+		text.scan(content, inherited.Resources, 0)
+		inspection.Texts = append(inspection.Texts, text.String())
+	}
+
+	return inspection, nil
+}
+
+// inspectFonts adds the fonts of the resources and the ones of their forms
+// to the list. The fonts are indexed by object number.
+func inspectFonts(pdfCtx *model.Context, resources types.Dict, page int, index map[int]int, fonts *[]PDFFont, depth int) {
+	if resources == nil || depth > maxFormDepth {
+		return
+	}
+
+	if fontDict, err := pdfCtx.DereferenceDict(resources["Font"]); err == nil {
+		for _, o := range fontDict {
+			ref, ok := o.(types.IndirectRef)
+			if !ok {
+				continue
+			}
+			objNr := ref.ObjectNumber.Value()
+			if i, ok := index[objNr]; ok {
+				if !slices.Contains((*fonts)[i].Pages, page) {
+					(*fonts)[i].Pages = append((*fonts)[i].Pages, page)
+				}
+				continue
+			}
+			d, err := pdfCtx.DereferenceDict(ref)
+			if err != nil || d == nil {
+				continue
+			}
+			index[objNr] = len(*fonts)
+			*fonts = append(*fonts, pdfFont(pdfCtx, d, page))
+		}
+	}
+
+	xobjects, err := pdfCtx.DereferenceDict(resources["XObject"])
+	if err != nil {
+		return
+	}
+	for _, o := range xobjects {
+		sd, _, err := pdfCtx.DereferenceStreamDict(o)
+		if err != nil || sd == nil || sd.Subtype() == nil || *sd.Subtype() != "Form" {
+			continue
+		}
+		if r, err := pdfCtx.DereferenceDict(sd.Dict["Resources"]); err == nil && r != nil {
+			inspectFonts(pdfCtx, r, page, index, fonts, depth+1)
+		}
+	}
+}
+
+// pdfFont describes the font dictionary. The Type3 fonts are always
+// embedded, the other ones when their descriptor has a font file.
+func pdfFont(pdfCtx *model.Context, d types.Dict, page int) PDFFont {
+	font := PDFFont{Pages: []int{page}}
+	if subtype := d.Subtype(); subtype != nil {
+		font.Type = *subtype
+	}
+	if name := d.NameEntry("BaseFont"); name != nil {
+		font.Name = *name
+	}
+	// The subsets have a prefix of six capital letters, like ABCDEF+Arial.
+	if prefix, name, ok := strings.Cut(font.Name, "+"); ok && len(prefix) == 6 && strings.ToUpper(prefix) == prefix {
+		font.Name = name
+		font.Subset = true
+	}
+
+	descriptorOf := d
+	if font.Type == "Type0" {
+		descendants, err := pdfCtx.DereferenceArray(d["DescendantFonts"])
+		if err != nil || len(descendants) == 0 {
+			return font
+		}
+		descriptorOf, err = pdfCtx.DereferenceDict(descendants[0])
+		if err != nil || descriptorOf == nil {
+			return font
+		}
+	}
+	descriptor, err := pdfCtx.DereferenceDict(descriptorOf["FontDescriptor"])
+	if err == nil && descriptor != nil {
+		for _, key := range []string{"FontFile", "FontFile2", "FontFile3"} {
+			if descriptor[key] != nil {
+				font.Embedded = true
+			}
+		}
+	}
+	font.Embedded = font.Embedded || font.Type == "Type3"
+
+	return font
+}
+
+// textExtractor writes the texts shown by a content stream, in lines by the
+// position of the text.
+type textExtractor struct {
+	pdfCtx   *model.Context
+	decoders map[int]*fontDecoder
+	out      strings.Builder
+	font     *fontDecoder
+	// lineY is the vertical position of the current text line, lastY the
+	// one of the last text shown.
+	lineY, lastY, leading float64
+	moved, shown          bool
+}
+
+func (e *textExtractor) String() string {
+	lines := strings.Split(e.out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// scan writes the texts of the content and the forms it draws.
+func (e *textExtractor) scan(content []byte, resources types.Dict, depth int) {
+	scanContent(content, func(op string, operands []string) {
+		switch op {
+		case "BT":
+			e.lineY = 0
+			e.moved = true
+		case "Tf":
+			if len(operands) >= 2 {
+				e.font = e.decoder(resources, operands[len(operands)-2])
+			}
+		case "TL":
+			e.leading = lastNumber(operands)
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				ty, _ := strconv.ParseFloat(operands[len(operands)-1], 64)
+				e.lineY += ty
+				if op == "TD" {
+					e.leading = -ty
+				}
+			}
+			e.moved = true
+		case "Tm":
+			e.lineY = lastNumber(operands)
+			e.moved = true
+		case "T*":
+			e.lineY -= e.leading
+			e.moved = true
+		case "Tj":
+			e.show(operands)
+		case "'", "\"":
+			e.lineY -= e.leading
+			e.moved = true
+			e.show(operands[len(operands)-min(len(operands), 1):])
+		case "TJ":
+			e.show(operands)
+		case "Do":
+			if len(operands) > 0 && depth < maxFormDepth {
+				e.scanForm(resources, operands[len(operands)-1], depth)
+			}
+		}
+	})
+}
+
+// scanForm writes the texts of a form of the resources.
+func (e *textExtractor) scanForm(resources types.Dict, name string, depth int) {
+	xobjects, err := e.pdfCtx.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return
+	}
+	sd, _, err := e.pdfCtx.DereferenceStreamDict(xobjects[strings.TrimPrefix(name, "/")])
+	if err != nil || sd == nil || sd.Subtype() == nil || *sd.Subtype() != "Form" || sd.Decode() != nil {
+		return
+	}
+	if r, err := e.pdfCtx.DereferenceDict(sd.Dict["Resources"]); err == nil && r != nil {
+		resources = r
+	}
+
+	// The positions in the form aren't the ones of the page.
+	font, lineY := e.font, e.lineY
+	e.moved = true
+	e.scan(sd.Content, resources, depth+1)
+	e.font, e.lineY = font, lineY
+	e.moved = true
+}
+
+// show writes the strings of the operands with the current font, the large
+// adjustments of a TJ array are spaces.
+func (e *textExtractor) show(operands []string) {
+	if e.font == nil {
+		return
+	}
+
+	text := strings.Builder{}
+	for _, operand := range operands {
+		if operand[0] == '(' || operand[0] == '<' {
+			text.WriteString(e.font.decode(pdfStringBytes(operand)))
+		} else if f, err := strconv.ParseFloat(operand, 64); err == nil && f < wordSpacing {
+			text.WriteByte(' ')
+		}
+	}
+	if text.Len() == 0 {
+		return
+	}
+
+	switch {
+	case e.shown && e.lineY != e.lastY:
+		e.out.WriteByte('\n')
+	case e.shown && e.moved:
+		e.out.WriteByte(' ')
+	}
+	e.out.WriteString(text.String())
+	e.lastY = e.lineY
+	e.moved = false
+	e.shown = true
+}
+
+// decoder returns the decoder of a font of the resources.
+func (e *textExtractor) decoder(resources types.Dict, name string) *fontDecoder {
+	fonts, err := e.pdfCtx.DereferenceDict(resources["Font"])
+	if err != nil || fonts == nil {
+		return nil
+	}
+	o := fonts[strings.TrimPrefix(name, "/")]
+	ref, ok := o.(types.IndirectRef)
+	if ok {
+		if decoder, ok := e.decoders[ref.ObjectNumber.Value()]; ok {
+			return decoder
+		}
+	}
+
+	d, err := e.pdfCtx.DereferenceDict(o)
+	if err != nil || d == nil {
+		return nil
+	}
+	decoder := newFontDecoder(e.pdfCtx, d)
+	if ok {
+		e.decoders[ref.ObjectNumber.Value()] = decoder
+	}
+
+	return decoder
+}
+
+// fontDecoder converts the codes of the strings of a font to text, with its
+// ToUnicode map or its encoding.
+type fontDecoder struct {
+	codeBytes int
+	toUnicode map[int]string
+	encoding  *charmap.Charmap
+	// differences are the codes of the encoding changed by the font.
+	differences map[int]rune
+}
+
+// newFontDecoder reads the encoding of the font. The Type0 fonts have codes
+// of two bytes, they and the symbol fonts are only decoded with a ToUnicode
+// map.
+func newFontDecoder(pdfCtx *model.Context, d types.Dict) *fontDecoder {
+	decoder := &fontDecoder{codeBytes: 1}
+	if subtype := d.Subtype(); subtype != nil && *subtype == "Type0" {
+		decoder.codeBytes = 2
+	}
+
+	if sd, _, err := pdfCtx.DereferenceStreamDict(d["ToUnicode"]); err == nil && sd != nil && sd.Decode() == nil {
+		decoder.toUnicode = parseToUnicode(sd.Content)
+	}
+	// The codes of the Type0 fonts and the symbols aren't characters.
+	baseFont := d.NameEntry("BaseFont")
+	if decoder.codeBytes == 2 || baseFont != nil && (*baseFont == "ZapfDingbats" || *baseFont == "Symbol") {
+		return decoder
+	}
+
+	decoder.encoding = charmap.Windows1252
+	encoding := d["Encoding"]
+	if encodingDict, err := pdfCtx.DereferenceDict(encoding); err == nil && encodingDict != nil {
+		encoding = encodingDict["BaseEncoding"]
+		differences, _ := pdfCtx.DereferenceArray(encodingDict["Differences"])
+		decoder.differences = encodingDifferences(differences)
+	}
+	if name, ok := encoding.(types.Name); ok && name == "MacRomanEncoding" {
+		decoder.encoding = charmap.Macintosh
+	}
+
+	return decoder
+}
+
+// decode returns the text of the bytes of a string.
+func (d *fontDecoder) decode(b []byte) string {
+	text := strings.Builder{}
+	for i := 0; i+d.codeBytes <= len(b); i += d.codeBytes {
+		code := int(b[i])
+		if d.codeBytes == 2 {
+			code = code<<8 | int(b[i+1])
+		}
+
+		if s, ok := d.toUnicode[code]; ok {
+			text.WriteString(s)
+		} else if r, ok := d.differences[code]; ok {
+			text.WriteRune(r)
+		} else if d.encoding != nil {
+			text.WriteRune(d.encoding.DecodeByte(byte(code)))
+		}
+	}
+
+	return text.String()
+}
+
+// glyphRunes are the glyph names of the Differences of an encoding that
+// aren't a character or uniXXXX, of the Spanish letters and punctuation.
+var glyphRunes = map[string]rune{
+	"space": ' ', "period": '.', "comma": ',', "colon": ':', "semicolon": ';', "hyphen": '-',
+	"quoteright": '’', "quoteleft": '‘', "quotedbl": '"', "quotesingle": '\'', "slash": '/',
+	"parenleft": '(', "parenright": ')', "dollar": '$', "percent": '%', "numbersign": '#',
+	"aacute": 'á', "eacute": 'é', "iacute": 'í', "oacute": 'ó', "uacute": 'ú', "ntilde": 'ñ', "udieresis": 'ü',
+	"Aacute": 'Á', "Eacute": 'É', "Iacute": 'Í', "Oacute": 'Ó', "Uacute": 'Ú', "Ntilde": 'Ñ', "Udieresis": 'Ü',
+	"questiondown": '¿', "exclamdown": '¡', "degree": '°', "ordfeminine": 'ª', "ordmasculine": 'º',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4',
+	"five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+}
+
+// encodingDifferences returns the codes changed by the Differences array,
+// a code followed by the names of its glyph and the next ones.
+func encodingDifferences(differences types.Array) map[int]rune {
+	runes := map[int]rune{}
+	code := 0
+	for _, o := range differences {
+		switch v := o.(type) {
+		case types.Integer:
+			code = v.Value()
+		case types.Name:
+			name := v.Value()
+			if r, ok := glyphRunes[name]; ok {
+				runes[code] = r
+			} else if hexCode, ok := strings.CutPrefix(name, "uni"); ok && len(hexCode) == 4 {
+				if n, err := strconv.ParseUint(hexCode, 16, 16); err == nil {
+					runes[code] = rune(n)
+				}
+			} else if r := []rune(name); len(r) == 1 {
+				runes[code] = r[0]
+			}
+			code++
+		}
+	}
+
+	return runes
+}
+
+// parseToUnicode returns the texts of the codes of a ToUnicode CMap, from
+// its bfchar and bfrange sections.
+func parseToUnicode(cmap []byte) map[int]string {
+	texts := map[int]string{}
+	scanContent(cmap, func(op string, operands []string) {
+		switch op {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				texts[pdfStringCode(operands[i])] = utf16Text(pdfStringBytes(operands[i+1]))
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); {
+				low, high := pdfStringCode(operands[i]), pdfStringCode(operands[i+1])
+				if high-low > 0xffff {
+					return
+				}
+				if operands[i+2] != "[" {
+					// The last unit of the text increments with the code.
+					dst := utf16.Decode(utf16Units(pdfStringBytes(operands[i+2])))
+					for code := low; code <= high && len(dst) > 0; code++ {
+						texts[code] = string(dst)
+						dst[len(dst)-1]++
+					}
+					i += 3
+					continue
+				}
+
+				i += 3
+				for code := low; i < len(operands) && operands[i] != "]"; code, i = code+1, i+1 {
+					texts[code] = utf16Text(pdfStringBytes(operands[i]))
+				}
+				i++
+			}
+		}
+	})
+
+	return texts
+}
+
+// pdfStringBytes returns the bytes of a literal or hexadecimal string of a
+// content stream, with its delimiters.
+func pdfStringBytes(s string) []byte {
+	if strings.HasPrefix(s, "<") {
+		h := strings.Map(func(r rune) rune {
+			if isPDFWhitespace(byte(r)) {
+				return -1
+			}
+			return r
+		}, strings.Trim(s, "<>"))
+		if len(h)%2 == 1 {
+			h += "0"
+		}
+		b, _ := hex.DecodeString(h)
+		return b
+	}
+
+	b, err := types.Unescape(strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"), false)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+// pdfStringCode returns the code of a hexadecimal string of a CMap.
+func pdfStringCode(s string) int {
+	code := 0
+	for _, b := range pdfStringBytes(s) {
+		code = code<<8 | int(b)
+	}
+
+	return code
+}
+
+// utf16Units returns the UTF-16BE units of the bytes.
+func utf16Units(b []byte) []uint16 {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+
+	return units
+}
+
+func utf16Text(b []byte) string {
+	return string(utf16.Decode(utf16Units(b)))
+}
+
+// lastNumber returns the last operand as a number, 0 if it isn't one.
+func lastNumber(operands []string) float64 {
+	if len(operands) == 0 {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(operands[len(operands)-1], 64)
+
+	return f
+}
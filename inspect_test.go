@@ -0,0 +1,115 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestInspectPDF(t *testing.T) {
+	ctx := context.Background()
+	pdf, err := RotatePages(ctx, testPages(t, "one", "two"), 90, "2")
+	if err != nil {
+		t.Fatalf("Got an unexpected error rotating the page: %v", err)
+	}
+
+	inspection, err := InspectPDF(ctx, pdf, InspectOptions{Text: true})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if inspection.Pages != 2 || inspection.Encrypted {
+		t.Errorf("inspection = %+v", inspection)
+	}
+	want := []PageSize{{Width: 595.28, Height: 841.89}, {Width: 841.89, Height: 595.28, Rotation: 90}}
+	if !slices.Equal(inspection.PageSizes, want) {
+		t.Errorf("page sizes = %+v, want %+v", inspection.PageSizes, want)
+	}
+	if !slices.Equal(inspection.Texts, []string{"one", "two"}) {
+		t.Errorf("texts = %q", inspection.Texts)
+	}
+	if len(inspection.Fonts) == 0 || inspection.Fonts[0].Embedded || !slices.Equal(inspection.Fonts[0].Pages, []int{1, 2}) {
+		t.Errorf("fonts = %+v, the standard fonts of both pages aren't embedded", inspection.Fonts)
+	}
+
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	encrypted, err := gen.Render(ctx, strings.NewReader("<p>Salario</p>"), RenderOptions{
+		Metadata:   &Metadata{Title: "Título"},
+		Encryption: &Encryption{UserPassword: "user"},
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error rendering: %v", err)
+	}
+	_, err = InspectPDF(ctx, encrypted, InspectOptions{})
+	if !errors.As(err, &ErrorProcess{}) {
+		t.Errorf("err = %v, the encrypted PDF needs its password", err)
+	}
+	inspection, err = InspectPDF(ctx, encrypted, InspectOptions{Password: "user"})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if !inspection.Encrypted || inspection.Metadata["Title"] != "Título" {
+		t.Errorf("inspection = %+v", inspection)
+	}
+
+	// The PDF/A documents embed their fonts, with a Unicode map.
+	archived, err := gen.Render(ctx, strings.NewReader("<h1>Certificado</h1><p>Año 2024, señor Núñez</p>"), RenderOptions{Profile: ProfilePDFA2B})
+	if err != nil {
+		t.Fatalf("Got an unexpected error rendering: %v", err)
+	}
+	inspection, err = InspectPDF(ctx, archived, InspectOptions{Text: true})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	for _, font := range inspection.Fonts {
+		if !font.Embedded {
+			t.Errorf("the font %+v of the PDF/A isn't embedded", font)
+		}
+	}
+	if len(inspection.Texts) != 1 || inspection.Texts[0] != "Certificado\nAño 2024, señor Núñez" {
+		t.Errorf("texts = %q", inspection.Texts)
+	}
+
+	_, err = InspectPDF(ctx, []byte("not a pdf"), InspectOptions{})
+	if !errors.As(err, &ErrorProcess{}) {
+		t.Errorf("err = %v, want an ErrorProcess", err)
+	}
+}
+
+func TestParseToUnicode(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin 12 dict begin begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar <0003> <0020> <0011> <00F1> endbfchar
+2 beginbfrange <0024> <0026> <0041> <0030> <0031> [<00E1> <D83DDE00>] endbfrange
+endcmap CMapName currentdict /CMap defineresource pop end end`
+
+	decoder := fontDecoder{codeBytes: 2, toUnicode: parseToUnicode([]byte(cmap))}
+	if got := decoder.decode([]byte{0, 0x24, 0, 0x26, 0, 0x03, 0, 0x11, 0, 0x30, 0, 0x31}); got != "AC ñá😀" {
+		t.Errorf("text = %q, want %q", got, "AC ñá😀")
+	}
+}
+
+func TestHandler_inspect(t *testing.T) {
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+
+	rec := doPDF(e, "/pdf/inspect", []testPart{{name: "html", data: `{"data": "<p>Nómina</p>", "options": {"metadata": {"author": "RRHH"}}}`}, {name: "text", data: "true"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	inspection := Inspection{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &inspection)
+	if inspection.Pages != 1 || inspection.Metadata["Author"] != "RRHH" || !slices.Equal(inspection.Texts, []string{"Nómina"}) {
+		t.Errorf("inspection = %+v", inspection)
+	}
+
+	rec = doPDF(e, "/pdf/inspect", []testPart{{name: "pdf", data: string(testPages(t, "one"))}})
+	if !strings.Contains(rec.Body.String(), `"pages":1`) || strings.Contains(rec.Body.String(), `"texts"`) {
+		t.Errorf("status = %d: %s, the texts are optional", rec.Code, rec.Body)
+	}
+}
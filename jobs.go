@@ -0,0 +1,725 @@
+package gohtmltopdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status of a job.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Error codes of a failed job.
+const (
+	JobErrorInvalidRequest = "invalid_request"
+	JobErrorRender         = "render_error"
+	JobErrorTimeout        = "timeout"
+	// JobErrorInterrupted is a job that was running on every restart of the
+	// service, it isn't retried again.
+	JobErrorInterrupted = "interrupted"
+)
+
+const (
+	ContentTypePDF = "application/pdf"
+	ContentTypeZIP = "application/zip"
+	// ContentTypeJSON are the reports of the /pdf endpoints, like the
+	// verification of the signatures.
+	ContentTypeJSON = "application/json"
+
+	DefaultJobTTL       = time.Hour
+	DefaultJobTimeout   = 10 * time.Minute
+	DefaultJobQueueSize = 100
+	// maxJobAttempts is how many times a job starts before it's considered
+	// the cause of the restarts.
+	maxJobAttempts = 3
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrQueueFull   = errors.New("the job queue is full, try again later")
+)
+
+// errStoreJob rejects the store of the inner request, the result of a job is
+// always downloaded from the job.
+var errStoreJob = ErrorProcess{Msg: "store isn't supported by the jobs, download the result from /jobs/{id}/result"}
+
+// JobRequest is the body of a job submission. The request is the body of the
+// endpoint of the type: html-to-pdf or dian-form-220.
+type JobRequest struct {
+	Type    string          `json:"type"`
+	Request json.RawMessage `json:"request,omitempty"`
+	// Split creates a ZIP with a PDF per employee, only for dian-form-220.
+	Split bool `json:"split,omitempty"`
+	// CallbackURL receives a WebhookEvent when the job finishes.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Validate checks the type and the request, so an invalid job fails on the
+// submission instead of on the worker.
+func (r JobRequest) Validate() error {
+	if r.CallbackURL != "" {
+		err := validateCallbackURL(r.CallbackURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch r.Type {
+	case EndpointHTMLToPDF:
+		if r.Split {
+			return ErrorProcess{Msg: "split is only supported by dian-form-220"}
+		}
+
+		req := requestHTML{}
+		err := json.Unmarshal(r.Request, &req)
+		if err != nil {
+			return ErrorProcess{Msg: fmt.Sprintf("can't read the request: %v", err)}
+		}
+		if req.Store {
+			return errStoreJob
+		}
+
+		return req.Options.Validate()
+	case EndpointDIANForm220:
+		req := requestDIANForm220{}
+		err := json.Unmarshal(r.Request, &req)
+		if err != nil {
+			return ErrorProcess{Msg: fmt.Sprintf("can't read the request: %v", err)}
+		}
+		if req.Store {
+			return errStoreJob
+		}
+
+		return req.validate(r.Split)
+	default:
+		return ErrorProcess{Msg: fmt.Sprintf("job type %q not supported", r.Type)}
+	}
+}
+
+// Job is the state of an asynchronous render.
+type Job struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	// Progress goes from 0 to 1.
+	Progress float64 `json:"progress"`
+	// Client is the name of the API key that created the job.
+	Client      string     `json:"client,omitempty"`
+	ErrorCode   string     `json:"error_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Size        int        `json:"size,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// Attempts is how many times the job started, a job interrupted by a
+	// restart is retried.
+	Attempts int `json:"attempts,omitempty"`
+	// ExpiresAt is when the job and its result are removed.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Webhook is replaced on every delivery, it's never modified in place.
+	Webhook *JobWebhook `json:"webhook,omitempty"`
+	// Optimization has the sizes of the optimized documents of the result.
+	Optimization *OptimizeReport `json:"optimization,omitempty"`
+}
+
+type jobEntry struct {
+	job     Job
+	request JobRequest
+	// result is only kept in memory without a store.
+	result         []byte
+	resultLocation string
+	// quotaKey is the quota of the pages of the result, it isn't stored so
+	// the jobs resumed after a restart use the quota of their client.
+	quotaKey string
+}
+
+func (e *jobEntry) stored() StoredJob {
+	return StoredJob{Job: e.job, Request: e.request, ResultLocation: e.resultLocation}
+}
+
+// Jobs runs renders in the background, for the documents that take longer
+// than the timeout of the clients. The jobs share the Pool with the
+// synchronous renders, and they are removed after the ttl.
+type Jobs struct {
+	renderer Renderer
+	pool     *Pool
+	metrics  *Metrics
+	ttl      time.Duration
+	timeout  time.Duration
+	webhooks *Webhooks
+	store    JobStore
+	limiter  *RateLimiter
+	signers  *Signers
+
+	mu    sync.RWMutex
+	jobs  map[string]*jobEntry
+	queue chan string
+}
+
+// JobsConfig are the dependencies and limits of the jobs, the zero values
+// use the defaults.
+type JobsConfig struct {
+	Pool    *Pool
+	Metrics *Metrics
+	// TTL is how long a finished job and its result are kept.
+	TTL       time.Duration
+	Timeout   time.Duration
+	QueueSize int
+	// Webhooks notifies the callback URL of the jobs, without it the jobs
+	// with a callback URL are rejected.
+	Webhooks *Webhooks
+	// Store persists the jobs, without it they are lost on a restart.
+	Store JobStore
+	// Limiter counts the pages of the results in the quotas.
+	Limiter *RateLimiter
+	// Signers sign the DIAN forms.
+	Signers *Signers
+}
+
+func NewJobs(renderer Renderer, cfg JobsConfig) *Jobs {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultJobTTL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultJobTimeout
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultJobQueueSize
+	}
+
+	return &Jobs{
+		renderer: renderer,
+		pool:     cfg.Pool,
+		metrics:  cfg.Metrics,
+		ttl:      cfg.TTL,
+		timeout:  cfg.Timeout,
+		webhooks: cfg.Webhooks,
+		store:    cfg.Store,
+		limiter:  cfg.Limiter,
+		signers:  cfg.Signers,
+		jobs:     map[string]*jobEntry{},
+		queue:    make(chan string, cfg.QueueSize),
+	}
+}
+
+// Start resumes the jobs of the store, and runs the workers and the cleanup
+// of the expired jobs until the context is done. There is a worker per slot
+// of the pool.
+func (j *Jobs) Start(ctx context.Context) error {
+	pending, err := j.restore(ctx)
+	if err != nil {
+		return err
+	}
+
+	workers := j.pool.Size()
+	if workers == 0 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		go j.work(ctx)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				j.removeExpired(now)
+			}
+		}
+	}()
+
+	// The pending jobs can be more than the size of the queue.
+	go func() {
+		for _, id := range pending {
+			select {
+			case j.queue <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// restore loads the jobs of the store and returns the ones to run, oldest
+// first. The jobs that were running are interrupted, they run again.
+func (j *Jobs) restore(ctx context.Context) ([]string, error) {
+	if j.store == nil {
+		return nil, nil
+	}
+
+	stored, err := j.store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("can't load the jobs: %w", err)
+	}
+	sort.Slice(stored, func(a, b int) bool { return stored[a].Job.CreatedAt.Before(stored[b].Job.CreatedAt) })
+
+	logger := LoggerFromContext(ctx)
+	now := time.Now()
+	var pending []string
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, s := range stored {
+		entry := &jobEntry{job: s.Job, request: s.Request, resultLocation: s.ResultLocation}
+		if entry.job.ExpiresAt != nil && now.After(*entry.job.ExpiresAt) {
+			j.deleteStored(ctx, entry.job.ID)
+			continue
+		}
+		j.jobs[entry.job.ID] = entry
+
+		switch entry.job.Status {
+		case JobRunning:
+			if entry.job.Attempts >= maxJobAttempts {
+				j.finish(entry, now, ErrorProcess{Msg: fmt.Sprintf("the job was interrupted %d times", entry.job.Attempts)}, JobErrorInterrupted)
+				logger.Warn("job interrupted too many times", "job_id", entry.job.ID, "attempts", entry.job.Attempts)
+				break
+			}
+
+			logger.Info("job interrupted, retrying", "job_id", entry.job.ID, "attempts", entry.job.Attempts)
+			entry.job.Status = JobQueued
+			entry.job.Progress = 0
+			j.persist(ctx, entry)
+			pending = append(pending, entry.job.ID)
+		case JobQueued:
+			pending = append(pending, entry.job.ID)
+		}
+
+		// The webhook of a finished job could be interrupted too.
+		if entry.job.Webhook != nil && entry.job.Webhook.Status == WebhookPending &&
+			(entry.job.Status == JobDone || entry.job.Status == JobFailed) {
+			go j.notify(ContextWithLogger(ctx, logger.With("job_id", entry.job.ID, "job_type", entry.job.Type)), entry.job.ID)
+		}
+	}
+
+	return pending, nil
+}
+
+// Submit validates and queues a job of the client of the context.
+func (j *Jobs) Submit(ctx context.Context, req JobRequest) (Job, error) {
+	err := req.Validate()
+	if err != nil {
+		return Job{}, err
+	}
+	if req.CallbackURL != "" && j.webhooks == nil {
+		return Job{}, ErrorProcess{Msg: "the callbacks aren't enabled"}
+	}
+
+	entry := &jobEntry{
+		job: Job{
+			ID:        newID(),
+			Type:      req.Type,
+			Status:    JobQueued,
+			CreatedAt: time.Now(),
+		},
+		request:  req,
+		quotaKey: quotaKeyFromContext(ctx),
+	}
+	if client, ok := ClientFromContext(ctx); ok {
+		entry.job.Client = client.Name
+	}
+	if req.CallbackURL != "" {
+		entry.job.Webhook = &JobWebhook{URL: req.CallbackURL, Status: WebhookPending}
+	}
+
+	if j.store != nil {
+		err = j.store.Save(entry.stored())
+		if err != nil {
+			return Job{}, fmt.Errorf("can't save the job: %w", err)
+		}
+	}
+
+	j.mu.Lock()
+	j.jobs[entry.job.ID] = entry
+	j.mu.Unlock()
+
+	select {
+	case j.queue <- entry.job.ID:
+	default:
+		j.mu.Lock()
+		delete(j.jobs, entry.job.ID)
+		j.mu.Unlock()
+		j.deleteStored(ctx, entry.job.ID)
+		return Job{}, ErrQueueFull
+	}
+
+	return entry.job, nil
+}
+
+// Get returns the state of the job.
+func (j *Jobs) Get(id string) (Job, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	entry, ok := j.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+
+	return entry.job, nil
+}
+
+// Result returns the state of the job and its result, the result is nil
+// until the job is done.
+func (j *Jobs) Result(id string) (Job, []byte, error) {
+	j.mu.RLock()
+	entry, ok := j.jobs[id]
+	if !ok {
+		j.mu.RUnlock()
+		return Job{}, nil, ErrJobNotFound
+	}
+	job, result, location := entry.job, entry.result, entry.resultLocation
+	j.mu.RUnlock()
+
+	if result != nil || location == "" {
+		return job, result, nil
+	}
+
+	result, err := j.store.LoadResult(location)
+	if err != nil {
+		return Job{}, nil, fmt.Errorf("can't load the result: %w", err)
+	}
+
+	return job, result, nil
+}
+
+func (j *Jobs) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-j.queue:
+			j.run(ctx, id)
+		}
+	}
+}
+
+func (j *Jobs) run(ctx context.Context, id string) {
+	j.mu.Lock()
+	entry, ok := j.jobs[id]
+	if !ok {
+		j.mu.Unlock()
+		return
+	}
+	logger := LoggerFromContext(ctx).With("job_id", id, "job_type", entry.request.Type)
+	if entry.job.Client != "" {
+		logger = logger.With("client", entry.job.Client)
+		ctx = ContextWithClient(ctx, Client{Name: entry.job.Client})
+	}
+	switch {
+	case entry.quotaKey != "":
+		ctx = contextWithQuotaKey(ctx, entry.quotaKey)
+	case entry.job.Client != "":
+		ctx = contextWithQuotaKey(ctx, "client:"+entry.job.Client)
+	}
+	ctx = ContextWithLogger(ctx, logger)
+
+	now := time.Now()
+	entry.job.Status = JobRunning
+	entry.job.StartedAt = &now
+	entry.job.Attempts++
+	j.persist(ctx, entry)
+	req := entry.request
+	j.mu.Unlock()
+
+	renderCtx, cancel := context.WithTimeout(ctx, j.timeout)
+	defer cancel()
+	renderCtx, optimized := contextWithOptimizeReport(renderCtx)
+
+	defer j.metrics.TrackInFlight(req.Type)()
+
+	var result []byte
+	var contentType string
+	err := j.pool.Do(renderCtx, func() error {
+		var err error
+		result, contentType, err = j.render(renderCtx, id, req)
+		return err
+	})
+
+	finished := time.Now()
+	j.metrics.ObserveRender(ctx, req.Type, j.backendName(req), outcome(err), finished.Sub(now), len(result))
+
+	// The service is stopping, the job stays running in the store so it's
+	// retried on the next start.
+	if ctx.Err() != nil {
+		logger.Warn("job interrupted", "error", err)
+		return
+	}
+
+	location := ""
+	if err == nil && j.store != nil {
+		location, err = j.store.SaveResult(id, result)
+		if err != nil {
+			err = fmt.Errorf("can't save the result: %w", err)
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err != nil {
+		j.finish(entry, finished, err, jobErrorCode(err))
+		logger.Warn("job failed", "error_code", entry.job.ErrorCode, "error", err)
+	} else {
+		entry.job.ContentType = contentType
+		entry.job.Size = len(result)
+		if optimized.Documents > 0 {
+			entry.job.Optimization = optimized
+		}
+		entry.resultLocation = location
+		if location == "" {
+			entry.result = result
+		}
+		j.finish(entry, finished, nil, "")
+		logger.Info("job done", "duration_ms", finished.Sub(now).Milliseconds(), "size_bytes", len(result))
+	}
+
+	if req.CallbackURL != "" {
+		go j.notify(ctx, id)
+	}
+}
+
+// finish sets the final state of the job and persists it, the lock must be
+// held.
+func (j *Jobs) finish(entry *jobEntry, finished time.Time, err error, code string) {
+	expires := finished.Add(j.ttl)
+	entry.job.FinishedAt = &finished
+	entry.job.ExpiresAt = &expires
+	if err != nil {
+		entry.job.Status = JobFailed
+		entry.job.Error = err.Error()
+		entry.job.ErrorCode = code
+	} else {
+		entry.job.Status = JobDone
+		entry.job.Progress = 1
+	}
+
+	j.persist(context.Background(), entry)
+}
+
+// persist saves the job in the store, the lock must be held. A failure is
+// only logged, the job goes on in memory.
+func (j *Jobs) persist(ctx context.Context, entry *jobEntry) {
+	if j.store == nil {
+		return
+	}
+
+	err := j.store.Save(entry.stored())
+	if err != nil {
+		LoggerFromContext(ctx).Error("can't save the job", "job_id", entry.job.ID, "error", err)
+	}
+}
+
+// deleteStored removes the job from the store, if any.
+func (j *Jobs) deleteStored(ctx context.Context, id string) {
+	if j.store == nil {
+		return
+	}
+
+	err := j.store.Delete(id)
+	if err != nil {
+		LoggerFromContext(ctx).Error("can't delete the job", "job_id", id, "error", err)
+	}
+}
+
+// render creates the PDF, or the ZIP of a split DIAN job. The pages of
+// every PDF are counted in the quota of the client.
+func (j *Jobs) render(ctx context.Context, id string, req JobRequest) ([]byte, string, error) {
+	switch req.Type {
+	case EndpointHTMLToPDF:
+		r := requestHTML{}
+		err := json.Unmarshal(req.Request, &r)
+		if err != nil {
+			return nil, "", ErrorProcess{Msg: err.Error()}
+		}
+
+		pdf, err := j.renderer.Render(ctx, strings.NewReader(r.Data), r.Options)
+		if err == nil {
+			j.limiter.AddPages(ctx, pdf, r.Options.Encryption.userPassword(nil))
+		}
+		return pdf, ContentTypePDF, err
+	case EndpointDIANForm220:
+		r := requestDIANForm220{}
+		err := json.Unmarshal(req.Request, &r)
+		if err != nil {
+			return nil, "", ErrorProcess{Msg: err.Error()}
+		}
+
+		dian := r.dianForm(j.metrics, j.signers)
+		if !req.Split {
+			pdf, err := dian.CreateDIANForm220(ctx, r.Data)
+			if err == nil {
+				j.limiter.AddPages(ctx, pdf, r.Encryption.userPassword(r.Data))
+			}
+			return pdf, ContentTypePDF, err
+		}
+
+		buf := bytes.Buffer{}
+		archive := zip.NewWriter(&buf)
+		for i, item := range r.Data {
+			err = ctx.Err()
+			if err != nil {
+				return nil, "", err
+			}
+
+			pdf, err := dian.CreateDIANForm220(ctx, DIANForms220Relation{item})
+			if err != nil {
+				return nil, "", err
+			}
+
+			f, err := archive.Create(dianFileName(i+1, item))
+			if err != nil {
+				return nil, "", err
+			}
+			_, err = f.Write(pdf)
+			if err != nil {
+				return nil, "", err
+			}
+
+			j.limiter.AddPages(ctx, pdf, r.Encryption.userPassword(DIANForms220Relation{item}))
+			j.setProgress(id, float64(i+1)/float64(len(r.Data)))
+		}
+
+		err = archive.Close()
+		if err != nil {
+			return nil, "", err
+		}
+
+		return buf.Bytes(), ContentTypeZIP, nil
+	default:
+		return nil, "", ErrorProcess{Msg: fmt.Sprintf("job type %q not supported", req.Type)}
+	}
+}
+
+// notify posts the event of a finished job to its callback URL, recording
+// every delivery in the job.
+func (j *Jobs) notify(ctx context.Context, id string) {
+	// The jobs of the store could have a callback after the webhooks were
+	// disabled.
+	if j.webhooks == nil {
+		return
+	}
+
+	logger := LoggerFromContext(ctx)
+	job, result, err := j.Result(id)
+	if err != nil {
+		logger.Warn("webhook failed", "error", err)
+		return
+	}
+
+	record := func(delivery WebhookDelivery) {
+		j.updateWebhook(id, func(w *JobWebhook) {
+			w.Deliveries = append(w.Deliveries, delivery)
+		})
+	}
+
+	err = j.webhooks.deliver(ctx, job.Webhook.URL, j.webhooks.event(job, result), record)
+	if err != nil {
+		logger.Warn("webhook failed", "error", err)
+		j.updateWebhook(id, func(w *JobWebhook) { w.Status = WebhookFailed })
+		return
+	}
+
+	logger.Info("webhook delivered")
+	j.updateWebhook(id, func(w *JobWebhook) { w.Status = WebhookDelivered })
+}
+
+// updateWebhook replaces the webhook of the job with a modified copy, the
+// previous one could be in use by a response.
+func (j *Jobs) updateWebhook(id string, update func(*JobWebhook)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, ok := j.jobs[id]
+	if !ok || entry.job.Webhook == nil {
+		return
+	}
+
+	webhook := *entry.job.Webhook
+	webhook.Deliveries = append([]WebhookDelivery(nil), webhook.Deliveries...)
+	update(&webhook)
+	entry.job.Webhook = &webhook
+	j.persist(context.Background(), entry)
+}
+
+func (j *Jobs) setProgress(id string, progress float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if entry, ok := j.jobs[id]; ok {
+		entry.job.Progress = progress
+	}
+}
+
+func (j *Jobs) removeExpired(now time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for id, entry := range j.jobs {
+		if entry.job.ExpiresAt != nil && now.After(*entry.job.ExpiresAt) {
+			delete(j.jobs, id)
+			j.deleteStored(context.Background(), id)
+		}
+	}
+}
+
+// backendName returns the backend of the job for the metrics.
+func (j *Jobs) backendName(req JobRequest) string {
+	if req.Type != EndpointHTMLToPDF {
+		return BackendMaroto
+	}
+
+	r := requestHTML{}
+	_ = json.Unmarshal(req.Request, &r)
+
+	return backendName(j.renderer, r.Options)
+}
+
+func jobErrorCode(err error) string {
+	switch {
+	case errors.As(err, &ErrorProcess{}):
+		return JobErrorInvalidRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return JobErrorTimeout
+	default:
+		return JobErrorRender
+	}
+}
+
+// dianFileName is the name of the PDF of an employee in the ZIP of a split
+// job. The position of the employee keeps the names unique when the
+// identifications are repeated or empty.
+func dianFileName(position int, item DIANForm220Relation) string {
+	// The identification comes from the request, it can't have path separators.
+	id := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-' {
+			return r
+		}
+		return '_'
+	}, item.IdentificationNumber)
+
+	name := fmt.Sprintf("certificado-220-%d-%03d", item.Year, position)
+	if id != "" {
+		name += "-" + id
+	}
+
+	return name + ".pdf"
+}
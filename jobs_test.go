@@ -0,0 +1,186 @@
+package gohtmltopdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newJobsServer(t *testing.T) *echo.Echo {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	pool := NewPool(2)
+	jobs := NewJobs(gen, JobsConfig{Pool: pool})
+	if err := jobs.Start(ctx); err != nil {
+		t.Fatalf("Got an unexpected error starting the jobs: %v", err)
+	}
+
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen, Pool: pool, Jobs: jobs})
+
+	return e
+}
+
+func doJSON(e *echo.Echo, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(ParamInternalCode, "secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// waitJob polls the job until it finishes.
+func waitJob(t *testing.T, e *echo.Echo, id string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		job := Job{}
+		rec := doJSON(e, http.MethodGet, "/jobs/"+id, "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatalf("can't read the job: %v", err)
+		}
+		if job.Status == JobDone || job.Status == JobFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("the job %s didn't finish", id)
+	return Job{}
+}
+
+func TestJobs_html(t *testing.T) {
+	e := newJobsServer(t)
+
+	rec := doJSON(e, http.MethodPost, "/jobs", `{"type": "html-to-pdf", "request": {"data": "<h1>Hola mundo</h1>"}}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	job := Job{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+	if rec.Header().Get(echo.HeaderLocation) != "/jobs/"+job.ID {
+		t.Errorf("Location = %q", rec.Header().Get(echo.HeaderLocation))
+	}
+
+	job = waitJob(t, e, job.ID)
+	if job.Status != JobDone || job.Progress != 1 || job.ContentType != ContentTypePDF || job.ExpiresAt == nil {
+		t.Fatalf("unexpected job %+v", job)
+	}
+
+	rec = doJSON(e, http.MethodGet, "/jobs/"+job.ID+"/result", "")
+	if rec.Code != http.StatusOK || !bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF")) {
+		t.Errorf("status = %d, the result isn't a PDF", rec.Code)
+	}
+	if rec.Body.Len() != job.Size {
+		t.Errorf("size = %d, want %d", rec.Body.Len(), job.Size)
+	}
+}
+
+func TestJobs_dianSplit(t *testing.T) {
+	e := newJobsServer(t)
+
+	body := `{"type": "dian-form-220", "split": true, "request": {"data": [
+		{"year": 2022, "rows": {}, "IdentificationNumber": "1"},
+		{"year": 2022, "rows": {}, "IdentificationNumber": "../2"},
+		{"year": 2022, "rows": {}, "IdentificationNumber": "1"},
+		{"year": 2022, "rows": {}}
+	]}}`
+	rec := doJSON(e, http.MethodPost, "/jobs", body)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	job := Job{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+
+	job = waitJob(t, e, job.ID)
+	if job.Status != JobDone || job.ContentType != ContentTypeZIP {
+		t.Fatalf("unexpected job %+v", job)
+	}
+
+	rec = doJSON(e, http.MethodGet, "/jobs/"+job.ID+"/result", "")
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("the result isn't a ZIP: %v", err)
+	}
+	// The repeated and empty identifications have their own files.
+	want := []string{"certificado-220-2022-001-1.pdf", "certificado-220-2022-002-___2.pdf", "certificado-220-2022-003-1.pdf", "certificado-220-2022-004.pdf"}
+	if len(archive.File) != len(want) {
+		t.Fatalf("files = %d, want %d", len(archive.File), len(want))
+	}
+	for i, f := range archive.File {
+		if f.Name != want[i] {
+			t.Errorf("file %d = %q, want %q", i, f.Name, want[i])
+		}
+	}
+}
+
+func TestJobs_errors(t *testing.T) {
+	e := newJobsServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"unknown type", http.MethodPost, "/jobs", `{"type": "docx", "request": {}}`, http.StatusBadRequest},
+		{"split html", http.MethodPost, "/jobs", `{"type": "html-to-pdf", "split": true, "request": {"data": "<p>a</p>"}}`, http.StatusBadRequest},
+		{"empty dian", http.MethodPost, "/jobs", `{"type": "dian-form-220", "request": {"data": []}}`, http.StatusBadRequest},
+		{"password of several employees", http.MethodPost, "/jobs", `{"type": "dian-form-220", "request": {"encryption": {"user_password_from_id": true}, "data": [{"year": 2022, "rows": {}, "IdentificationNumber": "1"}, {"year": 2022, "rows": {}, "IdentificationNumber": "2"}]}}`, http.StatusBadRequest},
+		{"split password without id", http.MethodPost, "/jobs", `{"type": "dian-form-220", "split": true, "request": {"encryption": {"user_password_from_id": true}, "data": [{"year": 2022, "rows": {}, "IdentificationNumber": "1"}, {"year": 2022, "rows": {}}]}}`, http.StatusBadRequest},
+		{"unknown job", http.MethodGet, "/jobs/nope", "", http.StatusNotFound},
+		{"unknown result", http.MethodGet, "/jobs/nope/result", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doJSON(e, tt.method, tt.path, tt.body)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestJobs_resultNotDone(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	// The workers aren't started, so the job stays queued.
+	jobs := NewJobs(gen, JobsConfig{Pool: NewPool(1), QueueSize: 1})
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen, Jobs: jobs})
+
+	rec := doJSON(e, http.MethodPost, "/jobs", `{"type": "html-to-pdf", "request": {"data": "<p>a</p>"}}`)
+	job := Job{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+
+	rec = doJSON(e, http.MethodGet, "/jobs/"+job.ID+"/result", "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	rec = doJSON(e, http.MethodPost, "/jobs", `{"type": "html-to-pdf", "request": {"data": "<p>b</p>"}}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	_, err := jobs.Get("nope")
+	if !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrJobNotFound)
+	}
+}
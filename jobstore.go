@@ -0,0 +1,151 @@
+package gohtmltopdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StoredJob is what a JobStore keeps of a job to resume it after a restart.
+type StoredJob struct {
+	Job     Job        `json:"job"`
+	Request JobRequest `json:"request"`
+	// ResultLocation is where the store saved the result of a done job.
+	ResultLocation string `json:"result_location,omitempty"`
+}
+
+// finished returns the job without the body of its request once it's done
+// or failed, the body has the personal data and the passwords of the
+// encryption and it's only needed to render the job again.
+func (s StoredJob) finished() StoredJob {
+	if s.Job.Status == JobDone || s.Job.Status == JobFailed {
+		s.Request.Request = nil
+	}
+
+	return s
+}
+
+// JobStore persists the jobs and their results, so the queued jobs survive a
+// restart of the service.
+type JobStore interface {
+	// Save creates or replaces the job.
+	Save(job StoredJob) error
+	// Load returns all the jobs.
+	Load() ([]StoredJob, error)
+	// Delete removes the job and its result.
+	Delete(id string) error
+	// SaveResult saves the result of a job and returns its location.
+	SaveResult(id string, result []byte) (string, error)
+	LoadResult(location string) ([]byte, error)
+}
+
+var bucketJobs = []byte("jobs")
+
+// BoltJobStore keeps the jobs in a bbolt database and the results as files
+// next to it, the results can be too big for the database. The requests of
+// the pending jobs are in the database as they were sent, passwords
+// included, so only the service can read the directory.
+type BoltJobStore struct {
+	db         *bolt.DB
+	resultsDir string
+}
+
+// NewBoltJobStore opens or creates the store in the directory.
+func NewBoltJobStore(dir string) (*BoltJobStore, error) {
+	resultsDir := filepath.Join(dir, "results")
+	err := os.MkdirAll(resultsDir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the job store directory: %w", err)
+	}
+
+	// The timeout avoids waiting forever when another process has the lock.
+	db, err := bolt.Open(filepath.Join(dir, "jobs.db"), 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open the job store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketJobs)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("can't create the jobs bucket: %w", err)
+	}
+
+	return &BoltJobStore{db: db, resultsDir: resultsDir}, nil
+}
+
+func (s *BoltJobStore) Save(job StoredJob) error {
+	data, err := json.Marshal(job.finished())
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketJobs).Put([]byte(job.Job.ID), data)
+	})
+}
+
+func (s *BoltJobStore) Load() ([]StoredJob, error) {
+	var jobs []StoredJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketJobs).ForEach(func(k, v []byte) error {
+			job := StoredJob{}
+			err := json.Unmarshal(v, &job)
+			if err != nil {
+				return fmt.Errorf("can't read the job %s: %w", k, err)
+			}
+			jobs = append(jobs, job)
+
+			return nil
+		})
+	})
+
+	return jobs, err
+}
+
+func (s *BoltJobStore) Delete(id string) error {
+	err := os.Remove(s.resultPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketJobs).Delete([]byte(id))
+	})
+}
+
+// SaveResult writes the result to a temporary file and renames it, so a
+// restart never leaves a partial result.
+func (s *BoltJobStore) SaveResult(id string, result []byte) (string, error) {
+	path := s.resultPath(id)
+	err := os.WriteFile(path+".tmp", result, 0o600)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Base(path), nil
+}
+
+func (s *BoltJobStore) LoadResult(location string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.resultsDir, filepath.Base(location)))
+}
+
+func (s *BoltJobStore) Close() error {
+	return s.db.Close()
+}
+
+// resultPath is the file of the result, the IDs are generated by newID so
+// they are safe as file names.
+func (s *BoltJobStore) resultPath(id string) string {
+	return filepath.Join(s.resultsDir, filepath.Base(id))
+}
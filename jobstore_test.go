@@ -0,0 +1,140 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBoltJobStore(t *testing.T) {
+	store, err := NewBoltJobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Got an unexpected error opening the store: %v", err)
+	}
+	defer store.Close()
+
+	job := StoredJob{
+		Job:     Job{ID: newID(), Type: EndpointHTMLToPDF, Status: JobQueued},
+		Request: JobRequest{Type: EndpointHTMLToPDF, Request: json.RawMessage(`{"data":"<p>a</p>"}`)},
+	}
+	err = store.Save(job)
+	if err != nil {
+		t.Fatalf("Got an unexpected error saving the job: %v", err)
+	}
+	jobs, _ := store.Load()
+	req := requestHTML{}
+	_ = json.Unmarshal(jobs[0].Request.Request, &req)
+	if req.Data != "<p>a</p>" {
+		t.Errorf("the request of a queued job must be stored: %+v", jobs[0].Request)
+	}
+
+	job.ResultLocation, err = store.SaveResult(job.Job.ID, []byte("%PDF-1.4"))
+	if err != nil {
+		t.Fatalf("Got an unexpected error saving the result: %v", err)
+	}
+	job.Job.Status = JobDone
+	_ = store.Save(job)
+
+	jobs, err = store.Load()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Load() = %d jobs, %v, want 1 job", len(jobs), err)
+	}
+	// The body of a finished job, with its passwords, isn't kept.
+	if jobs[0].Job.Status != JobDone || jobs[0].Request.Type != EndpointHTMLToPDF || jobs[0].Request.Request != nil {
+		t.Errorf("unexpected job %+v", jobs[0])
+	}
+	result, err := store.LoadResult(jobs[0].ResultLocation)
+	if err != nil || string(result) != "%PDF-1.4" {
+		t.Errorf("LoadResult() = %q, %v", result, err)
+	}
+
+	err = store.Delete(job.Job.ID)
+	if err != nil {
+		t.Fatalf("Got an unexpected error deleting the job: %v", err)
+	}
+	jobs, _ = store.Load()
+	if len(jobs) != 0 {
+		t.Errorf("the job wasn't deleted")
+	}
+	_, err = store.LoadResult(job.ResultLocation)
+	if err == nil {
+		t.Errorf("the result wasn't deleted")
+	}
+}
+
+func TestJobs_restore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBoltJobStore(dir)
+	if err != nil {
+		t.Fatalf("Got an unexpected error opening the store: %v", err)
+	}
+
+	request := JobRequest{Type: EndpointHTMLToPDF, Request: json.RawMessage(`{"data":"<h1>Hola</h1>"}`)}
+	created := time.Now().Add(-time.Minute)
+	expired := time.Now().Add(-time.Second)
+	stored := []StoredJob{
+		{Job: Job{ID: "queued", Type: EndpointHTMLToPDF, Status: JobQueued, CreatedAt: created}, Request: request},
+		{Job: Job{ID: "running", Type: EndpointHTMLToPDF, Status: JobRunning, Attempts: 1, CreatedAt: created}, Request: request},
+		{Job: Job{ID: "poison", Type: EndpointHTMLToPDF, Status: JobRunning, Attempts: maxJobAttempts, CreatedAt: created}, Request: request},
+		{Job: Job{ID: "expired", Type: EndpointHTMLToPDF, Status: JobDone, CreatedAt: created, ExpiresAt: &expired}, Request: request},
+	}
+	for _, s := range stored {
+		_ = store.Save(s)
+	}
+	_ = store.Close()
+
+	// A new instance of the service with the same directory.
+	store, err = NewBoltJobStore(dir)
+	if err != nil {
+		t.Fatalf("Got an unexpected error opening the store: %v", err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	jobs := NewJobs(gen, JobsConfig{Pool: NewPool(1), Store: store})
+	if err := jobs.Start(ctx); err != nil {
+		t.Fatalf("Got an unexpected error starting the jobs: %v", err)
+	}
+
+	for _, id := range []string{"queued", "running"} {
+		var job Job
+		deadline := time.Now().Add(30 * time.Second)
+		for time.Now().Before(deadline) {
+			job, _ = jobs.Get(id)
+			if job.Status == JobDone {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if job.Status != JobDone {
+			t.Fatalf("the job %s wasn't resumed: %+v", id, job)
+		}
+
+		_, result, err := jobs.Result(id)
+		if err != nil || !bytes.HasPrefix(result, []byte("%PDF")) {
+			t.Errorf("the result of %s isn't a PDF: %v", id, err)
+		}
+	}
+
+	job, _ := jobs.Get("poison")
+	if job.Status != JobFailed || job.ErrorCode != JobErrorInterrupted {
+		t.Errorf("the job interrupted too many times must fail: %+v", job)
+	}
+	_, err = jobs.Get("expired")
+	if err != ErrJobNotFound {
+		t.Errorf("the expired job must be removed, got %v", err)
+	}
+
+	// The final state is in the store.
+	all, _ := store.Load()
+	for _, s := range all {
+		if s.Job.ID == "running" && (s.Job.Status != JobDone || s.Job.Attempts != 2 || s.ResultLocation == "") {
+			t.Errorf("unexpected stored job %+v", s)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultJWKSRefresh = 15 * time.Minute
+	// jwksMinRefresh limits the refreshes when the tokens have unknown key
+	// IDs, so they can't be used to flood the JWKS server.
+	jwksMinRefresh = time.Minute
+	jwtLeeway      = 30 * time.Second
+)
+
+// JWTClientPrefix is the prefix of the names of the JWT clients, so a
+// token can't take the jobs, limits or keystore of an API key with the name
+// of its subject.
+const JWTClientPrefix = "jwt:"
+
+var ErrUnknownKey = errors.New("the key of the token is not in the JWKS")
+
+// JWTConfig validates the bearer tokens of the other services.
+type JWTConfig struct {
+	// JWKSFile or JWKSURL has the public keys of the issuer.
+	JWKSFile string
+	JWKSURL  string
+	// Refresh is how often the JWKS is loaded again, to follow the rotations.
+	Refresh  time.Duration
+	Issuer   string
+	Audience string
+	// ScopeClaim has the scopes, as a string separated by spaces or a list.
+	// By default, it's "scope".
+	ScopeClaim string
+	// ClientClaim is the name of the client, with the JWTClientPrefix, in
+	// the logs, metrics, rate limits and keystores. By default, it's "sub".
+	ClientClaim string
+}
+
+// JWKS keeps the public keys of a JSON Web Key Set by their ID. Only the RSA
+// and P-256 keys are loaded, the tokens are RS256 or ES256.
+type JWKS struct {
+	file    string
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+	// attemptedAt is the last load, successful or not. The loads are at
+	// least jwksMinRefresh apart.
+	attemptedAt time.Time
+}
+
+// NewJWKS loads the keys of the file or the URL.
+func NewJWKS(file, url string, refresh time.Duration) (*JWKS, error) {
+	if (file == "") == (url == "") {
+		return nil, errors.New("the JWKS needs a file or a URL")
+	}
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+
+	k := &JWKS{file: file, url: url, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}, attemptedAt: time.Now()}
+	err := k.load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Key returns the key of the ID. The keys are loaded again when they are
+// older than the refresh or the ID is unknown, a failed load keeps the
+// previous keys.
+func (k *JWKS) Key(ctx context.Context, kid string) (any, error) {
+	k.mu.Lock()
+	key, ok := k.lookup(kid)
+	stale := time.Since(k.fetchedAt) > k.refresh || !ok
+	reload := stale && time.Since(k.attemptedAt) > jwksMinRefresh
+	if reload {
+		k.attemptedAt = time.Now()
+	}
+	k.mu.Unlock()
+
+	if reload {
+		err := k.load(ctx)
+		if err != nil {
+			LoggerFromContext(ctx).Warn("can't refresh the JWKS", "error", err)
+		}
+
+		k.mu.Lock()
+		key, ok = k.lookup(kid)
+		k.mu.Unlock()
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// lookup returns the key of the ID, a token without ID can only use a JWKS
+// with one key. The lock must be held.
+func (k *JWKS) lookup(kid string) (any, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *JWKS) load(ctx context.Context) error {
+	var data []byte
+	var err error
+	if k.file != "" {
+		data, err = os.ReadFile(k.file)
+	} else {
+		data, err = k.fetch(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("can't load the JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	k.fetchedAt = time.Now()
+
+	return nil
+}
+
+func (k *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the JWKS server responded %d", res.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// jwk has the fields of the RSA and EC keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signature keys of the set, the other keys are
+// ignored.
+func parseJWKS(data []byte) (map[string]any, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the JWKS: %w", err)
+	}
+
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key any
+		switch {
+		case k.Kty == "RSA":
+			key, err = rsaKey(k)
+		case k.Kty == "EC" && k.Crv == "P-256":
+			key, err = ecKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read the key %q of the JWKS: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("the JWKS doesn't have RSA or P-256 signature keys")
+	}
+
+	return keys, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+func ecKey(k jwk) (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("the point isn't on the curve")
+	}
+
+	return key, nil
+}
+
+// JWTAuth authenticates the requests with a bearer JWT signed by a key of
+// the JWKS. The scopes of the token are the scopes of the client.
+type JWTAuth struct {
+	jwks        *JWKS
+	parser      *jwt.Parser
+	scopeClaim  string
+	clientClaim string
+}
+
+func NewJWTAuth(cfg JWTConfig) (*JWTAuth, error) {
+	jwks, err := NewJWKS(cfg.JWKSFile, cfg.JWKSURL, cfg.Refresh)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ScopeClaim == "" {
+		cfg.ScopeClaim = "scope"
+	}
+	if cfg.ClientClaim == "" {
+		cfg.ClientClaim = "sub"
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &JWTAuth{
+		jwks:        jwks,
+		parser:      jwt.NewParser(options...),
+		scopeClaim:  cfg.ScopeClaim,
+		clientClaim: cfg.ClientClaim,
+	}, nil
+}
+
+// Authenticate validates the bearer JWT of the request.
+func (a *JWTAuth) Authenticate(req *http.Request) (Client, error) {
+	token, ok := bearerToken(req)
+	if !ok || !isJWT(token) {
+		return Client{}, ErrMissingCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.jwks.Key(req.Context(), kid)
+	})
+	if err != nil {
+		return Client{}, fmt.Errorf("the token is not valid: %w", err)
+	}
+
+	name, _ := claims[a.clientClaim].(string)
+	if name == "" {
+		return Client{}, fmt.Errorf("the token doesn't have the claim %q", a.clientClaim)
+	}
+
+	return Client{Name: JWTClientPrefix + name, Scopes: claimScopes(claims[a.scopeClaim])}, nil
+}
+
+// claimScopes reads the scopes as a string separated by spaces, like OAuth
+// 2.0, or as a list of strings.
+func claimScopes(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		scopes := make([]string, 0, len(value))
+		for _, v := range value {
+			if scope, ok := v.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes
+	default:
+		return nil
+	}
+}
+
+// isJWT reports whether the token looks like a JWT: three parts separated by
+// dots.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
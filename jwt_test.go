@@ -0,0 +1,236 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// jwksJSON returns the JWKS of the public keys by their ID.
+func jwksJSON(keys map[string]any) []byte {
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	var set []map[string]string
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set = append(set, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set = append(set, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))})
+		}
+	}
+	data, _ := json.Marshal(map[string]any{"keys": set})
+
+	return data
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Got an unexpected error signing the token: %v", err)
+	}
+
+	return signed
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/html-to-pdf", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	return req
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	_ = os.WriteFile(path, jwksJSON(map[string]any{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}), 0o600)
+
+	auth, err := NewJWTAuth(JWTConfig{JWKSFile: path, Issuer: "https://auth.example.com", Audience: "gohtmltopdf"})
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the auth: %v", err)
+	}
+
+	claims := func(changes jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":   "https://auth.example.com",
+			"aud":   "gohtmltopdf",
+			"sub":   "payroll",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"scope": "dian:render html:render",
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	client, err := auth.Authenticate(bearerRequest(signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil))))
+	if err != nil || client.Name != "jwt:payroll" || !client.Has(ScopeDIANRender) || !client.Has(ScopeHTMLRender) {
+		t.Errorf("RS256: Authenticate() = %+v, %v", client, err)
+	}
+
+	client, err = auth.Authenticate(bearerRequest(signToken(t, jwt.SigningMethodES256, "ec", ecKey, claims(jwt.MapClaims{"scope": []string{"html:render"}}))))
+	if err != nil || !client.Has(ScopeHTMLRender) || client.Has(ScopeDIANRender) {
+		t.Errorf("ES256: Authenticate() = %+v, %v", client, err)
+	}
+
+	invalid := map[string]string{
+		"expired":         signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"without expiry":  signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": nil})),
+		"other issuer":    signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"iss": "https://evil.example.com"})),
+		"other audience":  signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"aud": "other"})),
+		"without subject": signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"sub": nil})),
+		"other key":       signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, claims(nil)),
+		"unknown kid":     signToken(t, jwt.SigningMethodRS256, "nope", rsaKey, claims(nil)),
+		"HS256":           signToken(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(nil)),
+	}
+	for name, token := range invalid {
+		if _, err := auth.Authenticate(bearerRequest(token)); err == nil {
+			t.Errorf("%s: the token must be rejected", name)
+		}
+	}
+
+	if _, err := auth.Authenticate(bearerRequest("api-key")); err != ErrMissingCredentials {
+		t.Errorf("an API key must not be handled by the JWT auth, err = %v", err)
+	}
+}
+
+func TestJWKS_rotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var rotated atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if rotated.Load() {
+			_, _ = w.Write(jwksJSON(map[string]any{"new": &newKey.PublicKey}))
+			return
+		}
+		_, _ = w.Write(jwksJSON(map[string]any{"old": &oldKey.PublicKey}))
+	}))
+	defer server.Close()
+
+	jwks, err := NewJWKS("", server.URL, time.Hour)
+	if err != nil {
+		t.Fatalf("Got an unexpected error loading the JWKS: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := jwks.Key(ctx, "old"); err != nil {
+		t.Fatalf("Got an unexpected error getting the key: %v", err)
+	}
+
+	// The keys are cached, an unknown key ID only reloads them after a while.
+	rotated.Store(true)
+	if _, err := jwks.Key(ctx, "new"); err != ErrUnknownKey {
+		t.Errorf("err = %v, want %v", err, ErrUnknownKey)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("requests = %d, want 1", requests.Load())
+	}
+
+	jwks.mu.Lock()
+	jwks.attemptedAt = time.Now().Add(-2 * jwksMinRefresh)
+	jwks.mu.Unlock()
+	if _, err := jwks.Key(ctx, "new"); err != nil {
+		t.Errorf("the rotated key must be loaded: %v", err)
+	}
+}
+
+func TestRouter_jwt(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	_ = os.WriteFile(path, jwksJSON(map[string]any{"k": &key.PublicKey}), 0o600)
+	jwtAuth, err := NewJWTAuth(JWTConfig{JWKSFile: path, ClientClaim: "azp", ScopeClaim: "scp"})
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the auth: %v", err)
+	}
+
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	jobs := NewJobs(gen, JobsConfig{})
+	e := echo.New()
+	Router(e, RouterConfig{Auth: Authenticators{jwtAuth, testAPIKeys()}, Generator: gen, Jobs: jobs})
+
+	token := signToken(t, jwt.SigningMethodES256, "k", key, jwt.MapClaims{
+		"azp": "web",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"scp": []string{ScopeHTMLRender},
+	})
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"jwt", "/html-to-pdf", echo.HeaderAuthorization, "Bearer " + token, http.StatusOK},
+		{"jwt without scope", "/dian-form-220", echo.HeaderAuthorization, "Bearer " + token, http.StatusForbidden},
+		{"invalid jwt", "/html-to-pdf", echo.HeaderAuthorization, "Bearer " + token + "x", http.StatusUnauthorized},
+		{"api key", "/dian-form-220", HeaderAPIKey, "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"data": "<p>a</p>"}`
+			if tt.path == "/dian-form-220" {
+				body = `{"data": [{"year": 2022, "rows": {}}]}`
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	// A token with the name of an API key isn't its client.
+	impostor := signToken(t, jwt.SigningMethodES256, "k", key, jwt.MapClaims{
+		"azp": "test",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"scp": []string{ScopeHTMLRender},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"type": "html-to-pdf", "request": {"data": "<p>a</p>"}}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(HeaderAPIKey, "secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	job := Job{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+
+	req = httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID, nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+impostor)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, the job of the API key test isn't visible to the JWT client jwt:test", rec.Code)
+	}
+}
@@ -0,0 +1,100 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderRequestID is the header with the ID that correlates the logs of a
+// request. It's propagated from the client or generated.
+const HeaderRequestID = echo.HeaderXRequestID
+
+// contextKeyRequestID is the echo.Context key of the request ID.
+const contextKeyRequestID = "request_id"
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a context with the logger used by the renderers.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger of the context, or the default one.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+
+	return logger
+}
+
+// RequestLogger attaches a request ID and a logger with it to every request,
+// and logs the request when it completes. The bodies are never logged, they
+// have HTML and personal data.
+func RequestLogger(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			id := req.Header.Get(HeaderRequestID)
+			if id == "" || len(id) > 128 {
+				id = newID()
+			}
+			c.Set(contextKeyRequestID, id)
+			c.Response().Header().Set(HeaderRequestID, id)
+
+			reqLogger := logger.With("request_id", id)
+			c.SetRequest(req.WithContext(ContextWithLogger(req.Context(), reqLogger)))
+
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			reqLogger.Info("request completed",
+				"method", req.Method,
+				"path", c.Path(),
+				"status", c.Response().Status,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"bytes_in", req.ContentLength,
+				"bytes_out", c.Response().Size,
+				"client", c.Get(contextKeyClient),
+			)
+
+			return nil
+		}
+	}
+}
+
+// RequestID returns the ID of the request, empty without the RequestLogger.
+func RequestID(c echo.Context) string {
+	id, _ := c.Get(contextKeyRequestID).(string)
+	return id
+}
+
+// errorResponse logs the error and responds it with the request ID, so the
+// client can report it.
+func errorResponse(c echo.Context, status int, msg string, err error) error {
+	ctx := c.Request().Context()
+	level := slog.LevelError
+	if status < 500 {
+		level = slog.LevelWarn
+	}
+	LoggerFromContext(ctx).Log(ctx, level, msg, "status", status, "error", err)
+
+	return c.JSON(status, map[string]string{"msg": msg, "error": err.Error(), "request_id": RequestID(c)})
+}
+
+// newID returns a random ID of 32 hex characters.
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,93 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newLoggingServer(buf *bytes.Buffer) *echo.Echo {
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	handler := NewHandler(gen, HandlerConfig{Readiness: NewReadiness(gen, nil, DefaultReadinessTimeout, DefaultReadinessTTL)})
+
+	e := echo.New()
+	e.Use(RequestLogger(logger))
+	e.POST("/html-to-pdf", handler.CreateHTMLToPDF)
+	e.POST("/dian-form-220", handler.CreateDianForm220)
+
+	return e
+}
+
+func TestRequestLogger_requestID(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := newLoggingServer(buf)
+
+	req := httptest.NewRequest(http.MethodPost, "/html-to-pdf", strings.NewReader(`{"data": "<p>Hola</p>", "options": {"page_size": "B9"}}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(HeaderRequestID, "abc-123")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(HeaderRequestID); got != "abc-123" {
+		t.Errorf("response request ID = %q, want %q", got, "abc-123")
+	}
+
+	resp := map[string]string{}
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the response: %v", err)
+	}
+	if resp["request_id"] != "abc-123" {
+		t.Errorf("error response request_id = %q, want %q", resp["request_id"], "abc-123")
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := map[string]any{}
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("Got an unexpected error reading the log %q: %v", line, err)
+		}
+		if entry["request_id"] != "abc-123" {
+			t.Errorf("log without the request ID: %s", line)
+		}
+	}
+
+	// Without the header a new ID is generated
+	req = httptest.NewRequest(http.MethodPost, "/html-to-pdf", strings.NewReader(`{"data": "<p>Hola</p>"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Header().Get(HeaderRequestID) == "" {
+		t.Errorf("the response must have a generated request ID")
+	}
+}
+
+func TestRequestLogger_personalData(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := newLoggingServer(buf)
+
+	body := `{"data": [{"year": 2022, "rows": {}, "IdentificationNumber": "1098765432", "FirstName": "Juana"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/dian-form-220", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	for _, data := range []string{"1098765432", "Juana"} {
+		if strings.Contains(buf.String(), data) {
+			t.Errorf("the logs have personal data %q", data)
+		}
+	}
+	if !strings.Contains(buf.String(), `"msg":"pdf rendered"`) {
+		t.Errorf("the render must be logged: %s", buf.String())
+	}
+}
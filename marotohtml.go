@@ -0,0 +1,749 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/johnfercher/maroto/v2"
+	"github.com/johnfercher/maroto/v2/pkg/components/col"
+	"github.com/johnfercher/maroto/v2/pkg/components/image"
+	"github.com/johnfercher/maroto/v2/pkg/components/line"
+	"github.com/johnfercher/maroto/v2/pkg/components/page"
+	"github.com/johnfercher/maroto/v2/pkg/components/row"
+	"github.com/johnfercher/maroto/v2/pkg/components/text"
+	"github.com/johnfercher/maroto/v2/pkg/config"
+	"github.com/johnfercher/maroto/v2/pkg/consts/align"
+	"github.com/johnfercher/maroto/v2/pkg/consts/border"
+	"github.com/johnfercher/maroto/v2/pkg/consts/extension"
+	"github.com/johnfercher/maroto/v2/pkg/consts/fontstyle"
+	"github.com/johnfercher/maroto/v2/pkg/consts/orientation"
+	"github.com/johnfercher/maroto/v2/pkg/consts/pagesize"
+	"github.com/johnfercher/maroto/v2/pkg/core"
+	"github.com/johnfercher/maroto/v2/pkg/core/entity"
+	"github.com/johnfercher/maroto/v2/pkg/props"
+	"go.opentelemetry.io/otel/attribute"
+	nethtml "golang.org/x/net/html"
+)
+
+const (
+	BackendMaroto = "maroto"
+
+	// marotoGridSize is the number of columns of a row, the columns of a
+	// table are distributed in it.
+	marotoGridSize = 12
+	// pxToMM converts CSS pixels (96 per inch) to millimeters.
+	pxToMM = 25.4 / 96
+)
+
+// MarotoHTML is a pure Go Backend that converts a subset of HTML into maroto
+// components, so simple documents don't need an external process.
+//
+// The supported subset is:
+//   - Headings (h1 to h6), paragraphs, divs, blockquotes and lists (ul, ol, li).
+//   - Tables (table, tr, th, td) with colspan, up to 12 columns per row. The
+//     cells have borders when the table has the border attribute.
+//   - Images (img) with a data URI source in PNG or JPEG. The height attribute
+//     is the height of the image in pixels.
+//   - Horizontal rules (hr) and line breaks (br).
+//   - Page breaks with the page-break-before, page-break-after, break-before
+//     and break-after styles.
+//   - Inline styles: font-size, font-weight, font-style, text-align and color.
+//     The b, strong, i and em elements are applied when they wrap the whole
+//     text of the block, maroto can't mix styles in a line.
+//
+// Any other element is rendered as its text, and head, script and style are
+// ignored.
+type MarotoHTML struct{}
+
+func NewMarotoHTML() *MarotoHTML {
+	return &MarotoHTML{}
+}
+
+func (m *MarotoHTML) Name() string {
+	return BackendMaroto
+}
+
+// DetectCapabilities reports the version of maroto, the backend is always
+// available.
+func (m *MarotoHTML) DetectCapabilities(context.Context) Capabilities {
+	return Capabilities{
+		Available: true,
+		Version:   moduleVersion("github.com/johnfercher/maroto/v2"),
+		Features:  []string{},
+	}
+}
+
+// Render creates a PDF from the HTML read from input.
+func (m *MarotoHTML) Render(ctx context.Context, input io.Reader, options RenderOptions) ([]byte, error) {
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if options.Grayscale {
+		return nil, ErrorProcess{Msg: "grayscale is not supported by the maroto backend"}
+	}
+	if options.needsPatchedQt() {
+		return nil, ErrorProcess{Msg: "headers, footers and table of contents are not supported by the maroto backend"}
+	}
+
+	_, span := tracer().Start(ctx, "maroto.template")
+	doc, err := nethtml.Parse(input)
+	if err != nil {
+		err = ErrorProcess{Msg: fmt.Sprintf("can't parse the HTML: %v", err)}
+		endSpan(span, err)
+		return nil, err
+	}
+
+	conv := newHTMLConverter()
+	err = conv.convert(doc)
+	span.SetAttributes(attribute.Int("maroto.pages", len(conv.pages)))
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// The conversion can take a while with big documents, so we check the
+	// context before the generation.
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	title := options.Title
+	if title == "" {
+		title = conv.title
+	}
+
+	_, span = tracer().Start(ctx, "maroto.generate")
+	mrt := maroto.New(marotoConfig(options, title))
+	for _, rows := range conv.pages {
+		mrt.AddPages(page.New().Add(rows...))
+	}
+
+	document, err := mrt.Generate()
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return document.GetBytes(), nil
+}
+
+// marotoConfig translates the options to a maroto configuration.
+func marotoConfig(options RenderOptions, title string) *entity.Config {
+	builder := config.NewBuilder().WithMaxGridSize(marotoGridSize)
+	if options.PageSize != "" {
+		builder = builder.WithPageSize(pagesize.Type(strings.ToLower(options.PageSize)))
+	}
+	if strings.EqualFold(options.Orientation, "Landscape") {
+		builder = builder.WithOrientation(orientation.Horizontal)
+	}
+	if options.MarginTop != nil {
+		builder = builder.WithTopMargin(*options.MarginTop)
+	}
+	if options.MarginBottom != nil {
+		builder = builder.WithBottomMargin(*options.MarginBottom)
+	}
+	if options.MarginLeft != nil {
+		builder = builder.WithLeftMargin(*options.MarginLeft)
+	}
+	if options.MarginRight != nil {
+		builder = builder.WithRightMargin(*options.MarginRight)
+	}
+	if title != "" {
+		builder = builder.WithTitle(title, true)
+	}
+	if isPDFA(options.Profile) {
+		builder = withPDFAFonts(builder)
+	}
+
+	return builder.Build()
+}
+
+// textStyle is the inherited style of the text while the HTML is walked.
+type textStyle struct {
+	size   float64
+	bold   bool
+	italic bool
+	align  align.Type
+	color  *props.Color
+}
+
+func (s textStyle) props(bold, italic bool) props.Text {
+	p := props.Text{Size: s.size, Align: s.align, Color: s.color}
+	switch {
+	case bold && italic:
+		p.Style = fontstyle.BoldItalic
+	case bold:
+		p.Style = fontstyle.Bold
+	case italic:
+		p.Style = fontstyle.Italic
+	}
+
+	return p
+}
+
+// textRun is a piece of text of a block with its inline style. The neutral
+// runs, like spaces and list markers, don't change the style of the block.
+type textRun struct {
+	text    string
+	bold    bool
+	italic  bool
+	neutral bool
+}
+
+// htmlList keeps the state of an open ul or ol element.
+type htmlList struct {
+	ordered bool
+	count   int
+}
+
+// htmlConverter walks the HTML tree and builds the maroto rows of each page.
+type htmlConverter struct {
+	title string
+	pages [][]core.Row
+
+	rows  []core.Row
+	runs  []textRun
+	block textStyle
+	lists []*htmlList
+}
+
+var headingSizes = map[string]float64{"h1": 20, "h2": 16, "h3": 14, "h4": 12, "h5": 11, "h6": 10}
+
+const defaultFontSize = 10
+
+func newHTMLConverter() *htmlConverter {
+	return &htmlConverter{block: textStyle{size: defaultFontSize, align: align.Left}}
+}
+
+func (c *htmlConverter) convert(doc *nethtml.Node) error {
+	err := c.walk(doc, c.block)
+	if err != nil {
+		return err
+	}
+
+	c.pageBreak()
+
+	return nil
+}
+
+func (c *htmlConverter) walk(n *nethtml.Node, style textStyle) error {
+	switch n.Type {
+	case nethtml.TextNode:
+		c.addText(n.Data, style)
+		return nil
+	case nethtml.ElementNode:
+	default:
+		return c.walkChildren(n, style)
+	}
+
+	style = applyCSS(style, n)
+	css := parseStyleAttr(attr(n, "style"))
+	if isPageBreak(css, "before") {
+		c.pageBreak()
+	}
+
+	var err error
+	switch n.Data {
+	case "head", "script", "style":
+		if n.Data == "head" {
+			c.title = findTitle(n)
+		}
+	case "h1", "h2", "h3", "h4", "h5", "h6", "p", "blockquote":
+		err = c.walkBlock(n, style, 2, "")
+	case "div", "section", "article", "header", "footer", "main", "body":
+		err = c.walkBlock(n, style, 0, "")
+	case "ul", "ol":
+		c.flush(0)
+		c.lists = append(c.lists, &htmlList{ordered: n.Data == "ol"})
+		err = c.walkChildren(n, style)
+		c.lists = c.lists[:len(c.lists)-1]
+		c.flush(0)
+	case "li":
+		err = c.walkBlock(n, style, 1, c.listMarker())
+	case "br":
+		c.flush(0)
+	case "hr":
+		c.flush(0)
+		c.rows = append(c.rows, line.NewRow(4))
+	case "img":
+		c.flush(0)
+		err = c.image(n)
+	case "table":
+		c.flush(0)
+		err = c.table(n, style)
+	case "b", "strong":
+		style.bold = true
+		err = c.walkChildren(n, style)
+	case "i", "em":
+		style.italic = true
+		err = c.walkChildren(n, style)
+	default:
+		err = c.walkChildren(n, style)
+	}
+	if err != nil {
+		return err
+	}
+
+	if isPageBreak(css, "after") {
+		c.pageBreak()
+	}
+
+	return nil
+}
+
+func (c *htmlConverter) walkChildren(n *nethtml.Node, style textStyle) error {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		err := c.walk(child, style)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkBlock renders the children of a block element as its own text rows,
+// with the bottom space in millimeters. The prefix is added before the text,
+// e.g. the marker of a list item.
+func (c *htmlConverter) walkBlock(n *nethtml.Node, style textStyle, bottom float64, prefix string) error {
+	c.flush(0)
+	parent := c.block
+	c.block = style
+	if prefix != "" {
+		c.runs = append(c.runs, textRun{text: prefix, neutral: true})
+	}
+	err := c.walkChildren(n, style)
+	c.flush(bottom)
+	c.block = parent
+
+	return err
+}
+
+func (c *htmlConverter) addText(value string, style textStyle) {
+	space := textRun{text: " ", neutral: true}
+	words := strings.Fields(value)
+	if len(words) == 0 {
+		// Whitespace between inline elements separates the words.
+		if value != "" {
+			c.runs = append(c.runs, space)
+		}
+		return
+	}
+
+	if strings.TrimLeftFunc(value, unicode.IsSpace) != value {
+		c.runs = append(c.runs, space)
+	}
+	c.runs = append(c.runs, textRun{text: strings.Join(words, " "), bold: style.bold, italic: style.italic})
+	if strings.TrimRightFunc(value, unicode.IsSpace) != value {
+		c.runs = append(c.runs, space)
+	}
+}
+
+// flush adds the pending text as a row with the style of the current block.
+func (c *htmlConverter) flush(bottom float64) {
+	if len(c.runs) == 0 {
+		return
+	}
+
+	var sb strings.Builder
+	bold, italic := true, true
+	hasText := false
+	for _, run := range c.runs {
+		if run.neutral {
+			if run.text == " " && strings.HasSuffix(sb.String(), " ") {
+				continue
+			}
+			sb.WriteString(run.text)
+			continue
+		}
+
+		bold = bold && run.bold
+		italic = italic && run.italic
+		hasText = true
+		sb.WriteString(run.text)
+	}
+	c.runs = nil
+
+	value := strings.TrimSpace(sb.String())
+	if !hasText || value == "" {
+		return
+	}
+
+	p := c.block.props(bold, italic)
+	p.Bottom = bottom
+	c.rows = append(c.rows, text.NewAutoRow(value, p))
+}
+
+// pageBreak closes the current page, empty pages are discarded.
+func (c *htmlConverter) pageBreak() {
+	c.flush(0)
+	if len(c.rows) == 0 {
+		return
+	}
+
+	c.pages = append(c.pages, c.rows)
+	c.rows = nil
+}
+
+func (c *htmlConverter) listMarker() string {
+	if len(c.lists) == 0 {
+		return "• "
+	}
+
+	list := c.lists[len(c.lists)-1]
+	list.count++
+	indent := strings.Repeat("   ", len(c.lists)-1)
+	if list.ordered {
+		return fmt.Sprintf("%s%d. ", indent, list.count)
+	}
+
+	return indent + "• "
+}
+
+func (c *htmlConverter) image(n *nethtml.Node) error {
+	src := attr(n, "src")
+	data, ext, err := decodeDataURI(src)
+	if err != nil {
+		return err
+	}
+
+	rect := props.Rect{Center: true, Percent: 100}
+	height, err := strconv.ParseFloat(strings.TrimSuffix(attr(n, "height"), "px"), 64)
+	if err == nil && height > 0 {
+		c.rows = append(c.rows, image.NewFromBytesRow(height*pxToMM, data, ext, rect))
+		return nil
+	}
+
+	c.rows = append(c.rows, image.NewAutoFromBytesRow(data, ext, rect))
+
+	return nil
+}
+
+// decodeDataURI returns the bytes of a base64 data URI of a PNG or JPEG image.
+// Remote and local files aren't loaded for security reasons.
+func decodeDataURI(src string) ([]byte, extension.Type, error) {
+	const prefix = "data:image/"
+	if !strings.HasPrefix(src, prefix) {
+		return nil, "", ErrorProcess{Msg: "images must have a base64 data URI as source"}
+	}
+
+	header, payload, ok := strings.Cut(strings.TrimPrefix(src, prefix), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return nil, "", ErrorProcess{Msg: "images must have a base64 data URI as source"}
+	}
+
+	ext := extension.Type(strings.TrimSuffix(header, ";base64"))
+	if !ext.IsValid() {
+		return nil, "", ErrorProcess{Msg: fmt.Sprintf("image type %q not supported", ext)}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, "", ErrorProcess{Msg: fmt.Sprintf("can't decode the image: %v", err)}
+	}
+
+	return data, ext, nil
+}
+
+// htmlCell is a td or th element of a table.
+type htmlCell struct {
+	text   string
+	span   int
+	header bool
+	style  textStyle
+}
+
+func (c *htmlConverter) table(n *nethtml.Node, style textStyle) error {
+	var cellStyle *props.Cell
+	if b := attr(n, "border"); b != "" && b != "0" || strings.Contains(attr(n, "style"), "border") {
+		cellStyle = &props.Cell{BorderType: border.Full}
+	}
+	headerBackground := &props.Color{Red: 230, Green: 230, Blue: 230}
+
+	for _, tr := range findAll(n, "tr") {
+		var cells []htmlCell
+		span := 0
+		for td := tr.FirstChild; td != nil; td = td.NextSibling {
+			if td.Type != nethtml.ElementNode || td.Data != "td" && td.Data != "th" {
+				continue
+			}
+
+			cell := htmlCell{
+				text:   strings.Join(strings.Fields(textContent(td)), " "),
+				span:   1,
+				header: td.Data == "th",
+				style:  applyCSS(applyCSS(style, tr), td),
+			}
+			if colspan, err := strconv.Atoi(attr(td, "colspan")); err == nil && colspan > 1 {
+				cell.span = colspan
+			}
+			span += cell.span
+			cells = append(cells, cell)
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		if span > marotoGridSize {
+			return ErrorProcess{Msg: fmt.Sprintf("tables with more than %d columns are not supported", marotoGridSize)}
+		}
+
+		spans := make([]int, len(cells))
+		for i, cell := range cells {
+			spans[i] = cell.span
+		}
+		sizes := columnSizes(spans)
+
+		r := row.New()
+		for i, cell := range cells {
+			size := sizes[i]
+			p := cell.style.props(cell.style.bold || cell.header, cell.style.italic)
+			p.Top, p.Bottom, p.Left, p.Right = 1, 1, 1, 1
+			if cell.header && cell.style.align == align.Left {
+				p.Align = align.Center
+			}
+
+			column := col.New(size).Add(text.New(cell.text, p))
+			if cellStyle != nil {
+				s := *cellStyle
+				if cell.header {
+					s.BackgroundColor = headerBackground
+				}
+				column = column.WithStyle(&s)
+			}
+			r.Add(column)
+		}
+		c.rows = append(c.rows, r)
+	}
+
+	return nil
+}
+
+// columnSizes splits the units of the grid between the cells by their span,
+// giving the units left by the rounding to the largest remainders. Every
+// cell gets at least a unit, as the spans add up to the grid size at most.
+func columnSizes(spans []int) []int {
+	total := 0
+	for _, span := range spans {
+		total += span
+	}
+
+	sizes := make([]int, len(spans))
+	remainders := make([]int, len(spans))
+	order := make([]int, len(spans))
+	used := 0
+	for i, span := range spans {
+		sizes[i] = span * marotoGridSize / total
+		remainders[i] = span * marotoGridSize % total
+		order[i] = i
+		used += sizes[i]
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, i := range order[:marotoGridSize-used] {
+		sizes[i]++
+	}
+
+	return sizes
+}
+
+// applyCSS returns the style with the supported properties of the style
+// attribute of the node.
+func applyCSS(style textStyle, n *nethtml.Node) textStyle {
+	if size, ok := headingSizes[n.Data]; ok {
+		style.size = size
+		style.bold = true
+	}
+
+	css := parseStyleAttr(attr(n, "style"))
+	if v, ok := css["font-size"]; ok {
+		if size, ok := parseFontSize(v, style.size); ok {
+			style.size = size
+		}
+	}
+	if v, ok := css["font-weight"]; ok {
+		weight, err := strconv.Atoi(v)
+		style.bold = v == "bold" || v == "bolder" || err == nil && weight >= 600
+	}
+	if v, ok := css["font-style"]; ok {
+		style.italic = v == "italic" || v == "oblique"
+	}
+	if v, ok := css["text-align"]; ok {
+		switch v {
+		case "left":
+			style.align = align.Left
+		case "right":
+			style.align = align.Right
+		case "center":
+			style.align = align.Center
+		case "justify":
+			style.align = align.Justify
+		}
+	}
+	if v, ok := css["color"]; ok {
+		if color, ok := parseColor(v); ok {
+			style.color = color
+		}
+	}
+
+	return style
+}
+
+func parseStyleAttr(value string) map[string]string {
+	css := map[string]string{}
+	for _, declaration := range strings.Split(value, ";") {
+		name, v, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+		css[strings.ToLower(strings.TrimSpace(name))] = strings.ToLower(strings.TrimSpace(v))
+	}
+
+	return css
+}
+
+func isPageBreak(css map[string]string, position string) bool {
+	return css["page-break-"+position] == "always" || css["break-"+position] == "page"
+}
+
+// parseFontSize returns the size in points of a CSS font size, relative sizes
+// use the size of the parent.
+func parseFontSize(value string, parent float64) (float64, bool) {
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"rem", defaultFontSize},
+		{"em", parent},
+		{"pt", 1},
+		{"px", 0.75},
+		{"%", parent / 100},
+	}
+	for _, unit := range units {
+		if !strings.HasSuffix(value, unit.suffix) {
+			continue
+		}
+
+		number, err := strconv.ParseFloat(strings.TrimSuffix(value, unit.suffix), 64)
+		if err != nil || number <= 0 {
+			return 0, false
+		}
+
+		return number * unit.factor, true
+	}
+
+	return 0, false
+}
+
+var namedColors = map[string]props.Color{
+	"black": {Red: 0, Green: 0, Blue: 0},
+	"white": {Red: 255, Green: 255, Blue: 255},
+	"red":   {Red: 255, Green: 0, Blue: 0},
+	"green": {Red: 0, Green: 128, Blue: 0},
+	"blue":  {Red: 0, Green: 0, Blue: 255},
+	"gray":  {Red: 128, Green: 128, Blue: 128},
+	"grey":  {Red: 128, Green: 128, Blue: 128},
+}
+
+// parseColor supports the #rgb, #rrggbb, rgb(r, g, b) and a few named colors.
+func parseColor(value string) (*props.Color, bool) {
+	if color, ok := namedColors[value]; ok {
+		return &color, true
+	}
+
+	if strings.HasPrefix(value, "#") {
+		hex := strings.TrimPrefix(value, "#")
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return nil, false
+		}
+
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return nil, false
+		}
+
+		return &props.Color{Red: int(rgb >> 16 & 0xff), Green: int(rgb >> 8 & 0xff), Blue: int(rgb & 0xff)}, true
+	}
+
+	if strings.HasPrefix(value, "rgb(") && strings.HasSuffix(value, ")") {
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(value, "rgb("), ")"), ",")
+		if len(parts) != 3 {
+			return nil, false
+		}
+
+		var channels [3]int
+		for i, part := range parts {
+			channel, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || channel < 0 || channel > 255 {
+				return nil, false
+			}
+			channels[i] = channel
+		}
+
+		return &props.Color{Red: channels[0], Green: channels[1], Blue: channels[2]}, true
+	}
+
+	return nil, false
+}
+
+func attr(n *nethtml.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func textContent(n *nethtml.Node) string {
+	if n.Type == nethtml.TextNode {
+		return n.Data
+	}
+
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(textContent(child))
+		sb.WriteString(" ")
+	}
+
+	return sb.String()
+}
+
+func findTitle(head *nethtml.Node) string {
+	for _, n := range findAll(head, "title") {
+		return strings.TrimSpace(textContent(n))
+	}
+
+	return ""
+}
+
+// findAll returns the descendants of n with the tag name in document order.
+// It doesn't look into nested tables.
+func findAll(n *nethtml.Node, tag string) []*nethtml.Node {
+	var nodes []*nethtml.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != nethtml.ElementNode {
+			continue
+		}
+		if child.Data == tag {
+			nodes = append(nodes, child)
+			continue
+		}
+		if child.Data == "table" {
+			continue
+		}
+		nodes = append(nodes, findAll(child, tag)...)
+	}
+
+	return nodes
+}
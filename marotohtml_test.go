@@ -0,0 +1,165 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// onePixelPNG is a transparent 1x1 PNG image.
+const onePixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+const htmlSubset = `
+<html>
+<head><title>Reporte</title><style>h1 { color: red; }</style></head>
+<body>
+    <h1 style="text-align: center">Hola mundo</h1>
+    <p>Lorem ipsum <b>dolor</b> sit amet.</p>
+    <p style="font-size: 8pt; color: #415f7e"><strong>Texto en negrilla</strong></p>
+    <ul><li>Uno</li><li>Dos</li></ul>
+    <table border="1">
+        <tr><th>Concepto</th><th colspan="2">Valor</th></tr>
+        <tr><td>Salario</td><td>1.000.000</td><td>COP</td></tr>
+    </table>
+    <img src="data:image/png;base64,` + onePixelPNG + `" height="20">
+    <div style="page-break-before: always"></div>
+    <p>Segunda página</p>
+</body>
+</html>
+`
+
+func TestMarotoHTML_Render(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	data, err := gen.Render(context.Background(), strings.NewReader(htmlSubset), RenderOptions{PageSize: "Letter"})
+	if err != nil {
+		t.Fatalf("Got an unexpected error generating pdf: %v", err)
+	}
+
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Errorf("The result is not a PDF document")
+	}
+}
+
+func TestHTMLConverter_pages(t *testing.T) {
+	doc, err := nethtml.Parse(strings.NewReader(htmlSubset))
+	if err != nil {
+		t.Fatalf("Got an unexpected error parsing the HTML: %v", err)
+	}
+
+	conv := newHTMLConverter()
+	err = conv.convert(doc)
+	if err != nil {
+		t.Fatalf("Got an unexpected error converting the HTML: %v", err)
+	}
+
+	if conv.title != "Reporte" {
+		t.Errorf("title = %q, want %q", conv.title, "Reporte")
+	}
+	if len(conv.pages) != 2 {
+		t.Fatalf("pages = %d, want 2", len(conv.pages))
+	}
+	// h1, p, p, two li, two table rows and the image
+	if len(conv.pages[0]) != 8 {
+		t.Errorf("rows of the first page = %d, want 8", len(conv.pages[0]))
+	}
+}
+
+func TestMarotoHTML_Render_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		options RenderOptions
+	}{
+		{"remote image", `<img src="https://example.com/logo.png">`, RenderOptions{}},
+		{"image type", `<img src="data:image/gif;base64,R0lGODlhAQABAAAAACw=">`, RenderOptions{}},
+		{"grayscale", `<p>Hola</p>`, RenderOptions{Grayscale: true}},
+		{"wide table", `<table><tr><td colspan="13">Hola</td></tr></table>`, RenderOptions{}},
+		{"13 columns", "<table><tr>" + strings.Repeat("<td>a</td>", 13) + "</tr></table>", RenderOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMarotoHTML().Render(context.Background(), strings.NewReader(tt.html), tt.options)
+			if !errors.As(err, &ErrorProcess{}) {
+				t.Errorf("Render() error = %v, want an ErrorProcess", err)
+			}
+		})
+	}
+}
+
+func Test_columnSizes(t *testing.T) {
+	tests := []struct {
+		spans []int
+		want  []int
+	}{
+		{[]int{1, 1, 1, 1, 1, 1, 1}, []int{2, 2, 2, 2, 2, 1, 1}},
+		{[]int{1, 1, 1, 1, 1, 1, 1, 1}, []int{2, 2, 2, 2, 1, 1, 1, 1}},
+		{[]int{2, 1, 1, 1, 1, 1, 1}, []int{3, 2, 2, 2, 1, 1, 1}},
+		{[]int{1, 2}, []int{4, 8}},
+	}
+	for _, tt := range tests {
+		if got := columnSizes(tt.spans); !slices.Equal(got, tt.want) {
+			t.Errorf("columnSizes(%v) = %v, want %v", tt.spans, got, tt.want)
+		}
+	}
+
+	// The last column of the wide tables must have room for its text.
+	for _, columns := range []int{7, 8} {
+		html := "<table><tr>" + strings.Repeat("<td>Salario</td>", columns) + "</tr></table>"
+		doc, err := nethtml.Parse(strings.NewReader(html))
+		if err != nil {
+			t.Fatalf("Got an unexpected error parsing the HTML: %v", err)
+		}
+		conv := newHTMLConverter()
+		if err := conv.convert(doc); err != nil {
+			t.Fatalf("%d columns: got an unexpected error: %v", columns, err)
+		}
+		cols := conv.pages[0][0].GetColumns()
+		if len(cols) != columns || cols[columns-1].GetSize() < 1 {
+			t.Errorf("%d columns: the last column has no room", columns)
+		}
+	}
+}
+
+func TestGenerator_Backend(t *testing.T) {
+	gen := NewGenerator()
+	backend, err := gen.Backend("")
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if backend.Name() != BackendWKHTMLToPDF {
+		t.Errorf("default backend = %q, want %q", backend.Name(), BackendWKHTMLToPDF)
+	}
+
+	_, err = gen.Render(context.Background(), strings.NewReader(""), RenderOptions{Backend: "chrome"})
+	if !errors.As(err, &ErrorProcess{}) {
+		t.Errorf("Render() error = %v, want an ErrorProcess", err)
+	}
+}
+
+func Test_parseColor(t *testing.T) {
+	tests := map[string][3]int{
+		"#415f7e":      {65, 95, 126},
+		"#fff":         {255, 255, 255},
+		"rgb(1, 2, 3)": {1, 2, 3},
+		"gray":         {128, 128, 128},
+	}
+	for value, want := range tests {
+		color, ok := parseColor(value)
+		if !ok {
+			t.Errorf("parseColor(%q) not ok", value)
+			continue
+		}
+		if got := [3]int{color.Red, color.Green, color.Blue}; got != want {
+			t.Errorf("parseColor(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	if _, ok := parseColor("#zzzzzz"); ok {
+		t.Errorf("parseColor of an invalid color must not be ok")
+	}
+}
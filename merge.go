@@ -0,0 +1,65 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// MergePart is a document of a merge. The Bookmark is optional, it's an
+// outline entry that goes to the first page of the part.
+type MergePart struct {
+	PDF      []byte
+	Bookmark string
+}
+
+// MergePDFs joins the parts in order into one PDF. The bookmarks of the
+// parts replace the outline of the documents.
+func MergePDFs(ctx context.Context, parts []MergePart) (pdf []byte, err error) {
+	ctx, span := tracer().Start(ctx, "merge")
+	defer func() {
+		setOutputAttributes(span, pdf)
+		endSpan(span, err)
+	}()
+
+	if len(parts) == 0 {
+		return nil, ErrorProcess{Msg: "there aren't documents to merge"}
+	}
+
+	readers := make([]io.ReadSeeker, len(parts))
+	var bookmarks []pdfcpu.Bookmark
+	page := 1
+	for i, part := range parts {
+		pages, err := pageCount(part.PDF, "")
+		if err != nil {
+			return nil, ErrorProcess{Msg: fmt.Sprintf("the part %d isn't a valid PDF or it's encrypted: %v", i+1, err)}
+		}
+		if part.Bookmark != "" {
+			bookmarks = append(bookmarks, pdfcpu.Bookmark{Title: part.Bookmark, PageFrom: page})
+		}
+		readers[i] = bytes.NewReader(part.PDF)
+		page += pages
+	}
+
+	buf := bytes.Buffer{}
+	err = api.MergeRaw(readers, &buf, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't merge the PDFs: %w", err)
+	}
+	if len(bookmarks) == 0 {
+		return buf.Bytes(), nil
+	}
+
+	out := bytes.Buffer{}
+	err = api.AddBookmarks(bytes.NewReader(buf.Bytes()), &out, bookmarks, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't add the bookmarks: %w", err)
+	}
+	LoggerFromContext(ctx).Debug("pdfs merged", "parts", len(parts), "bookmarks", len(bookmarks))
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,150 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func renderTestPDF(t *testing.T, html string) []byte {
+	t.Helper()
+
+	pdf, err := NewMarotoHTML().Render(context.Background(), strings.NewReader(html), RenderOptions{})
+	if err != nil {
+		t.Fatalf("Got an unexpected error rendering the PDF: %v", err)
+	}
+
+	return pdf
+}
+
+func TestMergePDFs(t *testing.T) {
+	parts := []MergePart{
+		{PDF: renderTestPDF(t, "<h1>Carta</h1>"), Bookmark: "Carta"},
+		{PDF: renderTestPDF(t, "<p>Anexo</p>")},
+		{PDF: renderTestPDF(t, "<p>Contrato</p>"), Bookmark: "Contrato"},
+	}
+
+	pdf, err := MergePDFs(context.Background(), parts)
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if pages, _ := pageCount(pdf, ""); pages != 3 {
+		t.Errorf("pages = %d, want 3", pages)
+	}
+
+	bookmarks, err := api.Bookmarks(bytes.NewReader(pdf), nil)
+	if err != nil || len(bookmarks) != 2 {
+		t.Fatalf("Bookmarks() = %+v, %v, want 2", bookmarks, err)
+	}
+	if bookmarks[0].Title != "Carta" || bookmarks[0].PageFrom != 1 || bookmarks[1].Title != "Contrato" || bookmarks[1].PageFrom != 3 {
+		t.Errorf("bookmarks = %+v", bookmarks)
+	}
+
+	_, err = MergePDFs(context.Background(), []MergePart{{PDF: []byte("not a pdf")}})
+	if err == nil {
+		t.Error("an invalid PDF must be rejected")
+	}
+}
+
+type testPart struct {
+	name     string
+	data     string
+	bookmark string
+}
+
+// doPDF posts the parts to a /pdf endpoint with the key of testAPIKeys.
+func doPDF(e *echo.Echo, path string, parts []testPart) *httptest.ResponseRecorder {
+	return doMultipart(e, path, "secret", parts)
+}
+
+func doMultipart(e *echo.Echo, path, key string, parts []testPart) *httptest.ResponseRecorder {
+	body := bytes.Buffer{}
+	writer := multipart.NewWriter(&body)
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		if p.name == "pdf" {
+			header.Set("Content-Disposition", `form-data; name="pdf"; filename="document.pdf"`)
+			header.Set(echo.HeaderContentType, ContentTypePDF)
+		} else {
+			header.Set("Content-Disposition", `form-data; name="`+p.name+`"`)
+		}
+		if p.bookmark != "" {
+			header.Set("X-Bookmark", p.bookmark)
+		}
+		w, _ := writer.CreatePart(header)
+		_, _ = w.Write([]byte(p.data))
+	}
+	_ = writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	req.Header.Set(HeaderAPIKey, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHandler_MergePDF(t *testing.T) {
+	auth, _ := NewAPIKeys([]APIKey{
+		{Name: "all", Hash: HashAPIKey("all-key"), Scopes: []string{ScopeAll}},
+		{Name: "pdf", Hash: HashAPIKey("pdf-key"), Scopes: []string{ScopePDFProcess}},
+	})
+	e := echo.New()
+	Router(e, RouterConfig{Auth: auth, Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+
+	signed := string(renderTestPDF(t, "<p>Contrato firmado</p>"))
+	rec := doMultipart(e, "/pdf/merge", "all-key", []testPart{
+		{name: "html", data: `{"data": "<h1>Carta</h1>", "bookmark": "Carta"}`},
+		{name: "pdf", data: signed, bookmark: "Contrato"},
+		{name: "dian", data: `{"data": [{"year": 2022, "rows": {}}], "bookmark": "Certificado"}`},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	res := map[string][]byte{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+
+	bookmarks, err := api.Bookmarks(bytes.NewReader(res["data"]), nil)
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the bookmarks: %v", err)
+	}
+	var titles []string
+	for _, b := range bookmarks {
+		titles = append(titles, b.Title)
+	}
+	if strings.Join(titles, ",") != "Carta,Contrato,Certificado" {
+		t.Errorf("bookmarks = %v, want the parts in order", titles)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		parts []testPart
+		want  int
+	}{
+		{"only uploads", "pdf-key", []testPart{{name: "pdf", data: signed}, {name: "pdf", data: signed}}, http.StatusOK},
+		{"render without scope", "pdf-key", []testPart{{name: "html", data: `{"data": "<p>a</p>"}`}}, http.StatusForbidden},
+		{"invalid pdf", "all-key", []testPart{{name: "pdf", data: "not a pdf"}}, http.StatusBadRequest},
+		{"unknown part", "all-key", []testPart{{name: "docx", data: "a"}}, http.StatusBadRequest},
+		{"encrypted part", "all-key", []testPart{{name: "html", data: `{"data": "<p>a</p>", "options": {"encryption": {}}}`}}, http.StatusBadRequest},
+		{"without parts", "all-key", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doMultipart(e, "/pdf/merge", tt.key, tt.parts)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
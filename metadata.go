@@ -0,0 +1,261 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// DefaultCreator is the Creator of the documents with Metadata that don't
+// set one.
+const DefaultCreator = "gohtmltopdf"
+
+// maxProperties is the limit of custom properties of a document.
+const maxProperties = 50
+
+var (
+	propertyName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+	// standardProperties are the entries of the Info dictionary that can't
+	// be custom properties.
+	standardProperties = []string{"Title", "Author", "Subject", "Keywords", "Creator", "Producer", "CreationDate", "ModDate", "Trapped"}
+)
+
+// Metadata are the properties of a PDF, saved in its Info dictionary and
+// its XMP metadata. They replace the ones of the backends, so the documents
+// have the same properties with wkhtmltopdf and maroto. The Producer and
+// the dates are always set by pdfcpu.
+type Metadata struct {
+	Title    string   `json:"title,omitempty"`
+	Author   string   `json:"author,omitempty"`
+	Subject  string   `json:"subject,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	// Creator is the application that created the document, DefaultCreator
+	// when it's empty.
+	Creator string `json:"creator,omitempty"`
+	// Properties are custom entries, their names are letters, numbers, _
+	// and -, and can't be a standard entry like Title.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// Validate checks the names of the custom properties.
+func (m Metadata) Validate() error {
+	if len(m.Properties) > maxProperties {
+		return ErrorProcess{Msg: fmt.Sprintf("the metadata can't have more than %d properties", maxProperties)}
+	}
+	for name := range m.Properties {
+		if !propertyName.MatchString(name) {
+			return ErrorProcess{Msg: fmt.Sprintf("invalid property name %q, use letters, numbers, _ and -", name)}
+		}
+		if containsFold(standardProperties, name) {
+			return ErrorProcess{Msg: fmt.Sprintf("the property %q is a standard entry, use its field", name)}
+		}
+	}
+
+	return nil
+}
+
+// SetMetadata replaces the Info dictionary and the XMP metadata of the PDF.
+func SetMetadata(ctx context.Context, pdf []byte, metadata Metadata) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "metadata")
+	defer func() { endSpan(span, err) }()
+
+	err = metadata.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pdfCtx, _, _, _, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), model.NewDefaultConfiguration(), time.Now())
+	if err != nil {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or it's encrypted: %v", err)}
+	}
+
+	err = writeMetadata(pdfCtx, metadata, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	err = api.WriteContext(pdfCtx, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("can't write the metadata: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeMetadata replaces the Info dictionary and the XMP of the document.
+// The XMP of a PDF/A has its date, the one pdfcpu writes in the Info.
+func writeMetadata(pdfCtx *model.Context, metadata Metadata, pdfaDate time.Time) error {
+	if metadata.Creator == "" {
+		metadata.Creator = DefaultCreator
+	}
+
+	info := types.NewDict()
+	for name, value := range metadata.entries() {
+		if value == "" {
+			continue
+		}
+		text, err := encodeText(value)
+		if err != nil {
+			return err
+		}
+		info.Insert(name, text)
+	}
+
+	var err error
+	pdfCtx.Info, err = pdfCtx.IndRefForNewObject(info)
+	if err != nil {
+		return err
+	}
+
+	return setXMP(pdfCtx, metadata.xmp(pdfaDate))
+}
+
+// entries returns the standard and custom entries of the Info dictionary.
+func (m Metadata) entries() map[string]string {
+	entries := map[string]string{
+		"Title":    m.Title,
+		"Author":   m.Author,
+		"Subject":  m.Subject,
+		"Keywords": strings.Join(m.Keywords, ", "),
+		"Creator":  m.Creator,
+	}
+	for name, value := range m.Properties {
+		entries[name] = value
+	}
+
+	return entries
+}
+
+// xmp returns the XMP packet with the same values of the Info dictionary.
+// The custom properties use the namespace of Acrobat for them. With a
+// pdfaDate, it identifies a PDF/A-2b with the dates, and leaves out the
+// custom properties because PDF/A needs a schema for their namespace.
+func (m Metadata) xmp(pdfaDate time.Time) []byte {
+	b := strings.Builder{}
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	b.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:pdf="http://ns.adobe.com/pdf/1.3/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:pdfx="http://ns.adobe.com/pdfx/1.3/" xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/">` + "\n")
+
+	element := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "<%s>%s</%s>\n", name, escapeXML(value), name)
+		}
+	}
+	element("dc:format", "application/pdf")
+	if m.Title != "" {
+		fmt.Fprintf(&b, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", escapeXML(m.Title))
+	}
+	if m.Author != "" {
+		fmt.Fprintf(&b, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", escapeXML(m.Author))
+	}
+	if m.Subject != "" {
+		fmt.Fprintf(&b, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", escapeXML(m.Subject))
+	}
+	element("pdf:Keywords", strings.Join(m.Keywords, ", "))
+	element("pdf:Producer", "pdfcpu "+model.VersionStr)
+	element("xmp:CreatorTool", m.Creator)
+	if !pdfaDate.IsZero() {
+		date := pdfaDate.Format(time.RFC3339)
+		element("xmp:CreateDate", date)
+		element("xmp:ModifyDate", date)
+		element("xmp:MetadataDate", date)
+		element("pdfaid:part", "2")
+		element("pdfaid:conformance", "B")
+		m.Properties = nil
+	}
+
+	names := make([]string, 0, len(m.Properties))
+	for name := range m.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		element("pdfx:"+name, m.Properties[name])
+	}
+
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n")
+	b.WriteString(`<?xpacket end="w"?>`)
+
+	return []byte(b.String())
+}
+
+// setXMP replaces the metadata stream of the catalog. The stream isn't
+// compressed, so the XMP can be read without decoding the PDF.
+func setXMP(pdfCtx *model.Context, packet []byte) error {
+	catalog, err := pdfCtx.Catalog()
+	if err != nil {
+		return err
+	}
+
+	sd := types.StreamDict{Dict: types.NewDict(), Content: packet}
+	sd.InsertName("Type", "Metadata")
+	sd.InsertName("Subtype", "XML")
+	err = sd.Encode()
+	if err != nil {
+		return err
+	}
+
+	ref, err := pdfCtx.IndRefForNewObject(sd)
+	if err != nil {
+		return err
+	}
+	catalog.Update("Metadata", *ref)
+
+	return nil
+}
+
+// pdfInfo returns the text entries of the Info dictionary of the PDF.
+func pdfInfo(pdfCtx *model.Context) (map[string]string, error) {
+	info := map[string]string{}
+	if pdfCtx.Info == nil {
+		return info, nil
+	}
+	d, err := pdfCtx.DereferenceDict(*pdfCtx.Info)
+	if err != nil || d == nil {
+		return info, err
+	}
+	for name, value := range d {
+		text, err := pdfCtx.DereferenceText(value)
+		if err == nil {
+			info[name] = text
+		}
+	}
+
+	return info, nil
+}
+
+// encodeText returns the PDF string of the text. When it isn't ASCII, it's
+// a hex string in UTF-16, so its bytes don't need escaping.
+func encodeText(s string) (types.Object, error) {
+	for _, r := range s {
+		if r > 0x7e {
+			return types.HexLiteral(hex.EncodeToString([]byte(types.EncodeUTF16String(s)))), nil
+		}
+	}
+
+	escaped, err := types.Escape(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return types.StringLiteral(*escaped), nil
+}
+
+func escapeXML(s string) string {
+	b := strings.Builder{}
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func info(t *testing.T, pdf []byte, password string) map[string]string {
+	t.Helper()
+
+	inspection, err := InspectPDF(context.Background(), pdf, InspectOptions{Password: password})
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the Info: %v", err)
+	}
+
+	return inspection.Metadata
+}
+
+func TestGenerator_Render_metadata(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	pdf, err := gen.Render(context.Background(), strings.NewReader("<p>Salario</p>"), RenderOptions{
+		Metadata: &Metadata{
+			Title:      "Nómina (enero)",
+			Author:     "Recursos Humanos",
+			Subject:    "Pago",
+			Keywords:   []string{"nómina", "2024"},
+			Properties: map[string]string{"EmployeeID": "1098765432"},
+		},
+		Watermarks: []Watermark{{Text: "COPIA"}},
+		Encryption: &Encryption{UserPassword: "user"},
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	got := info(t, pdf, "user")
+	want := map[string]string{
+		"Title":      "Nómina (enero)",
+		"Author":     "Recursos Humanos",
+		"Subject":    "Pago",
+		"Keywords":   "nómina, 2024",
+		"Creator":    DefaultCreator,
+		"EmployeeID": "1098765432",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %q, want %q", name, got[name], value)
+		}
+	}
+}
+
+func TestSetMetadata_xmp(t *testing.T) {
+	pdf, err := SetMetadata(context.Background(), renderTestPDF(t, "<p>a</p>"), Metadata{
+		Title:      "Contrato <2024> & anexos",
+		Creator:    "Nómina",
+		Properties: map[string]string{"Area": "RRHH"},
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	for _, want := range []string{
+		`<rdf:li xml:lang="x-default">Contrato &lt;2024&gt; &amp; anexos</rdf:li>`,
+		"<xmp:CreatorTool>Nómina</xmp:CreatorTool>",
+		"<pdfx:Area>RRHH</pdfx:Area>",
+	} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("the XMP must have %s", want)
+		}
+	}
+}
+
+func TestMetadata_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata Metadata
+	}{
+		{"standard entry", Metadata{Properties: map[string]string{"title": "a"}}},
+		{"invalid name", Metadata{Properties: map[string]string{"Employee ID": "a"}}},
+		{"empty name", Metadata{Properties: map[string]string{"": "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.metadata.Validate(); err == nil {
+				t.Error("the metadata must be rejected")
+			}
+		})
+	}
+
+	options := RenderOptions{Metadata: &Metadata{Properties: map[string]string{"Producer": "a"}}}
+	if err := options.Validate(); err == nil {
+		t.Error("the options must validate the metadata")
+	}
+}
+
+func TestDIAN_metadata(t *testing.T) {
+	item := DIANForm220Relation{DIANForm220: DIANForm220{Year: 2022, Records: json.RawMessage(`{}`)}, IdentificationNumber: "1098765432"}
+
+	tests := []struct {
+		name     string
+		data     DIANForms220Relation
+		metadata *Metadata
+		want     string
+	}{
+		{"one employee", DIANForms220Relation{item}, nil, "Certificado 220 2022 - 1098765432"},
+		{"several employees", DIANForms220Relation{item, item}, &Metadata{Author: "Empresa"}, "Certificado 220 2022"},
+		{"custom title", DIANForms220Relation{item}, &Metadata{Title: "Certificado"}, "Certificado"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dian := NewDIAN(false)
+			dian.Metadata = tt.metadata
+			pdf, err := dian.CreateDIANForm220(context.Background(), tt.data)
+			if err != nil {
+				t.Fatalf("Got an unexpected error: %v", err)
+			}
+			if got := info(t, pdf, "")["Title"]; got != tt.want {
+				t.Errorf("Title = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_metadata(t *testing.T) {
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+
+	rec := doJSON(e, http.MethodPost, "/dian-form-220", `{"metadata": {"keywords": ["220"]}, "data": [{"year": 2022, "rows": {}, "IdentificationNumber": "7"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	res := map[string][]byte{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	if got := info(t, res["data"], ""); got["Title"] != "Certificado 220 2022 - 7" || got["Keywords"] != "220" {
+		t.Errorf("unexpected Info %v", got)
+	}
+
+	rec = doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": "<p>a</p>", "options": {"metadata": {"properties": {"Title": "a"}}}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an invalid property: %s", rec.Code, rec.Body)
+	}
+}
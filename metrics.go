@@ -0,0 +1,269 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	marotometrics "github.com/johnfercher/maroto/v2/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of a render in the metrics.
+const (
+	OutcomeSuccess     = "success"
+	OutcomeClientError = "client_error"
+	OutcomeError       = "error"
+)
+
+// Endpoints in the metrics.
+const (
+	EndpointHTMLToPDF     = "html-to-pdf"
+	EndpointDIANForm220   = "dian-form-220"
+	EndpointPDFMerge      = "pdf-merge"
+	EndpointPDFSplit      = "pdf-split"
+	EndpointPDFExtract    = "pdf-extract"
+	EndpointPDFRotate     = "pdf-rotate"
+	EndpointPDFReorder    = "pdf-reorder"
+	EndpointPDFVerify     = "pdf-verify"
+	EndpointPDFOptimize   = "pdf-optimize"
+	EndpointPDFFormFields = "pdf-form-fields"
+	EndpointPDFFormFill   = "pdf-form-fill"
+	EndpointPDFInspect    = "pdf-inspect"
+)
+
+// BackendPDFCPU is the backend label of the endpoints that process PDFs.
+const BackendPDFCPU = "pdfcpu"
+
+const metricsNamespace = "gohtmltopdf"
+
+// Metrics are the Prometheus metrics of the service. A nil *Metrics is valid
+// and doesn't record anything, so the library can be used without them.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	renders        *prometheus.CounterVec
+	renderDuration *prometheus.HistogramVec
+	outputSize     *prometheus.HistogramVec
+	inputSize      *prometheus.HistogramVec
+	inFlight       *prometheus.GaugeVec
+	processCPU     *prometheus.CounterVec
+	processMaxRSS  prometheus.Histogram
+	marotoStage    *prometheus.HistogramVec
+	cache          *prometheus.CounterVec
+	rateLimited    *prometheus.CounterVec
+}
+
+// sizeBuckets go from 1 KB to 64 MB.
+var sizeBuckets = prometheus.ExponentialBuckets(1024, 4, 9)
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		renders: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "renders_total",
+			Help:      "Number of renders by endpoint, backend, client and outcome.",
+		}, []string{"endpoint", "backend", "client", "outcome"}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_duration_seconds",
+			Help:      "Duration of the renders.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"endpoint", "backend"}),
+		outputSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_output_bytes",
+			Help:      "Size of the generated PDF files.",
+			Buckets:   sizeBuckets,
+		}, []string{"endpoint", "backend"}),
+		inputSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_input_bytes",
+			Help:      "Size of the request bodies of the renders.",
+			Buckets:   sizeBuckets,
+		}, []string{"endpoint"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "renders_in_flight",
+			Help:      "Number of renders in progress.",
+		}, []string{"endpoint"}),
+		processCPU: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "wkhtmltopdf_cpu_seconds_total",
+			Help:      "CPU time used by the wkhtmltopdf processes.",
+		}, []string{"mode"}),
+		processMaxRSS: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "wkhtmltopdf_max_rss_bytes",
+			Help:      "Maximum resident set size of each wkhtmltopdf process.",
+			Buckets:   prometheus.ExponentialBuckets(16*1024*1024, 2, 8),
+		}),
+		marotoStage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "maroto_stage_duration_seconds",
+			Help:      "Duration of the maroto stages reported by its metrics decorator.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"stage"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_requests_total",
+			Help:      "Number of cache lookups by endpoint and result.",
+		}, []string{"endpoint", "result"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limited_total",
+			Help:      "Number of requests rejected by the rate limits, by client and reason.",
+		}, []string{"client", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.renders,
+		m.renderDuration,
+		m.outputSize,
+		m.inputSize,
+		m.inFlight,
+		m.processCPU,
+		m.processMaxRSS,
+		m.marotoStage,
+		m.cache,
+		m.rateLimited,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// TrackInFlight increments the in-flight renders of the endpoint, the
+// returned function must be called when the render ends.
+func (m *Metrics) TrackInFlight(endpoint string) func() {
+	if m == nil {
+		return func() {}
+	}
+
+	gauge := m.inFlight.WithLabelValues(endpoint)
+	gauge.Inc()
+
+	return gauge.Dec
+}
+
+// ObserveInput records the size of a request body.
+func (m *Metrics) ObserveInput(endpoint string, size int64) {
+	if m == nil || size < 0 {
+		return
+	}
+
+	m.inputSize.WithLabelValues(endpoint).Observe(float64(size))
+}
+
+// ObserveRender records a finished render of the client of the context, the
+// duration and size are only recorded for the successful ones.
+func (m *Metrics) ObserveRender(ctx context.Context, endpoint, backend, outcome string, duration time.Duration, outputSize int) {
+	if m == nil {
+		return
+	}
+
+	m.renders.WithLabelValues(endpoint, backend, clientLabel(ctx), outcome).Inc()
+	if outcome != OutcomeSuccess {
+		return
+	}
+
+	m.renderDuration.WithLabelValues(endpoint, backend).Observe(duration.Seconds())
+	m.outputSize.WithLabelValues(endpoint, backend).Observe(float64(outputSize))
+}
+
+// ObserveProcess records the resources used by a finished wkhtmltopdf process.
+func (m *Metrics) ObserveProcess(state *os.ProcessState) {
+	if m == nil || state == nil {
+		return
+	}
+
+	m.processCPU.WithLabelValues("user").Add(state.UserTime().Seconds())
+	m.processCPU.WithLabelValues("system").Add(state.SystemTime().Seconds())
+	if rss, ok := maxRSS(state); ok {
+		m.processMaxRSS.Observe(float64(rss))
+	}
+}
+
+// ObserveCache records a lookup of the render cache.
+func (m *Metrics) ObserveCache(endpoint string, hit bool) {
+	if m == nil {
+		return
+	}
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cache.WithLabelValues(endpoint, result).Inc()
+}
+
+// ObserveRateLimited records a request of the client of the context
+// rejected by the rate limit or the quota.
+func (m *Metrics) ObserveRateLimited(ctx context.Context, reason string) {
+	if m == nil {
+		return
+	}
+
+	m.rateLimited.WithLabelValues(clientLabel(ctx), reason).Inc()
+}
+
+// clientLabel is the client of the metrics. The JWT clients are all "jwt",
+// every subject would be a new series; the API keys are a known set.
+func clientLabel(ctx context.Context) string {
+	name := clientName(ctx)
+	if strings.HasPrefix(name, JWTClientPrefix) {
+		return "jwt"
+	}
+
+	return name
+}
+
+// ObserveMarotoReport records the timings of the maroto metrics decorator.
+func (m *Metrics) ObserveMarotoReport(report *marotometrics.Report) {
+	if m == nil || report == nil {
+		return
+	}
+
+	for _, metric := range report.TimeMetrics {
+		for _, t := range metric.Times {
+			m.marotoStage.WithLabelValues(metric.Key).Observe(marotoSeconds(t))
+		}
+	}
+}
+
+// outcome classifies the error of a render for the metrics.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.As(err, &ErrorProcess{}):
+		return OutcomeClientError
+	default:
+		return OutcomeError
+	}
+}
+
+func marotoSeconds(t *marotometrics.Time) float64 {
+	switch t.Scale {
+	case marotometrics.Nano:
+		return t.Value / 1e9
+	case marotometrics.Micro:
+		return t.Value / 1e6
+	case marotometrics.Milli:
+		return t.Value / 1e3
+	default:
+		return t.Value
+	}
+}
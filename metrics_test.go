@@ -0,0 +1,66 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMetrics_renders(t *testing.T) {
+	metrics := NewMetrics()
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto), WithMetrics(metrics))
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen, Metrics: metrics})
+
+	requests := []struct {
+		path string
+		body string
+	}{
+		{"/html-to-pdf", `{"data": "<h1>Hola mundo</h1>"}`},
+		{"/html-to-pdf", `{"data": "<h1>Hola mundo</h1>", "options": {"backend": "chrome"}}`},
+		{"/dian-form-220", `{"data": [{"year": 2022, "rows": {}}]}`},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(http.MethodPost, r.path, strings.NewReader(r.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(ParamInternalCode, "secret")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	want := []string{
+		`gohtmltopdf_renders_total{backend="maroto",client="test",endpoint="html-to-pdf",outcome="success"} 1`,
+		`gohtmltopdf_renders_total{backend="unknown",client="test",endpoint="html-to-pdf",outcome="client_error"} 1`,
+		`gohtmltopdf_renders_total{backend="maroto",client="test",endpoint="dian-form-220",outcome="success"} 1`,
+		`gohtmltopdf_render_output_bytes_count{backend="maroto",endpoint="dian-form-220"} 1`,
+		`gohtmltopdf_render_input_bytes_count{endpoint="html-to-pdf"} 2`,
+		`gohtmltopdf_renders_in_flight{endpoint="dian-form-220"} 0`,
+		`gohtmltopdf_maroto_stage_duration_seconds_count{stage="generate"} 1`,
+	}
+	for _, w := range want {
+		if !strings.Contains(string(body), w) {
+			t.Errorf("the metrics don't have %q", w)
+		}
+	}
+}
+
+func Test_clientLabel(t *testing.T) {
+	tests := map[string]context.Context{
+		"anonymous": context.Background(),
+		"test":      ContextWithClient(context.Background(), Client{Name: "test"}),
+		"jwt":       ContextWithClient(context.Background(), Client{Name: JWTClientPrefix + "payroll"}),
+	}
+	for want, ctx := range tests {
+		if got := clientLabel(ctx); got != want {
+			t.Errorf("clientLabel() = %q, want %q", got, want)
+		}
+	}
+}
@@ -14,14 +14,34 @@ func (e ErrorProcess) Error() string {
 }
 
 type requestHTML struct {
-	// TODO agregar propiedades como: tamaño de página, número de páginas, etc
-
 	// Data must be a string with HTML format.
 	Data string `json:"data"`
+	// Options of the document like page size, orientation and margins.
+	Options RenderOptions `json:"options"`
+	// Store responds a download URL of the PDF instead of the bytes.
+	Store bool `json:"store,omitempty"`
+	// NoCache renders the PDF again even if it's in the cache.
+	NoCache bool `json:"no_cache,omitempty"`
 }
 
 type requestDIANForm220 struct {
-	Data DIANForms220Relation `json:"data"`
+	Data    DIANForms220Relation `json:"data"`
+	Store   bool                 `json:"store,omitempty"`
+	NoCache bool                 `json:"no_cache,omitempty"`
+	// Metadata of the PDF, with split jobs the title of every PDF has the
+	// IdentificationNumber of its employee.
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// Watermarks are added to the pages of the forms.
+	Watermarks []Watermark `json:"watermarks,omitempty"`
+	// Optimize reduces the size of the PDF, with split jobs of every PDF of
+	// the ZIP.
+	Optimize *Optimization `json:"optimize,omitempty"`
+	// Profile of the output, pdfa-2b for archival documents.
+	Profile string `json:"profile,omitempty"`
+	// Encryption protects the PDF, with split jobs every PDF of the ZIP.
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// Signature signs the PDF, with split jobs every PDF of the ZIP.
+	Signature *Signature `json:"signature,omitempty"`
 }
 
 type DIANForm220 struct {
@@ -0,0 +1,651 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/filter"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/image/draw"
+)
+
+// Defaults of the Optimization.
+const (
+	DefaultImageDPI    = 150
+	DefaultJPEGQuality = 75
+
+	minImageDPI = 36
+	// minCompressBytes is the size of the smallest stream worth compressing.
+	minCompressBytes = 256
+	// maxFormDepth limits the forms drawn inside forms.
+	maxFormDepth = 8
+)
+
+// Headers of the responses with optimized documents, their sizes in bytes
+// before and after the optimization, and whether they are linearized.
+const (
+	HeaderOriginalSize  = "X-Original-Size"
+	HeaderOptimizedSize = "X-Optimized-Size"
+	HeaderLinearized    = "X-Linearized"
+)
+
+// Optimization reduces the size of a PDF: the duplicated fonts and images
+// and the unused objects are removed, the streams without compression are
+// compressed, and the images shown with more resolution than the ImageDPI
+// are downsampled. When qpdf is installed the document is also linearized
+// for the fast web view.
+type Optimization struct {
+	// ImageDPI is the resolution of the downsampled images in the page,
+	// DefaultImageDPI when it's 0.
+	ImageDPI int `json:"image_dpi,omitempty"`
+	// JPEGQuality of the downsampled JPEG images, from 1 to 100,
+	// DefaultJPEGQuality when it's 0.
+	JPEGQuality int `json:"jpeg_quality,omitempty"`
+}
+
+// Validate checks the resolution and the quality.
+func (o Optimization) Validate() error {
+	if o.ImageDPI != 0 && o.ImageDPI < minImageDPI {
+		return ErrorProcess{Msg: fmt.Sprintf("the image DPI must be at least %d", minImageDPI)}
+	}
+	if o.JPEGQuality < 0 || o.JPEGQuality > 100 {
+		return ErrorProcess{Msg: "the JPEG quality must be from 1 to 100"}
+	}
+
+	return nil
+}
+
+// OptimizeReport has the sizes in bytes of the optimized documents.
+type OptimizeReport struct {
+	// Documents is how many PDFs were optimized, e.g. every PDF of a split
+	// job.
+	Documents         int `json:"documents"`
+	SizeBefore        int `json:"size_before"`
+	SizeAfter         int `json:"size_after"`
+	ImagesDownsampled int `json:"images_downsampled"`
+	// Linearized is how many documents were linearized, none when qpdf
+	// isn't installed.
+	Linearized int `json:"linearized"`
+}
+
+type optimizeReportKey struct{}
+
+// contextWithOptimizeReport returns a context where the documents optimized
+// with it are added to the report.
+func contextWithOptimizeReport(ctx context.Context) (context.Context, *OptimizeReport) {
+	report := &OptimizeReport{}
+
+	return context.WithValue(ctx, optimizeReportKey{}, report), report
+}
+
+// OptimizePDF reduces the size of the PDF and linearizes it. The optimized
+// PDF is discarded when it isn't smaller, the linearization is kept.
+func OptimizePDF(ctx context.Context, pdf []byte, optimization Optimization) ([]byte, OptimizeReport, error) {
+	out, report, err := optimizePDF(ctx, pdf, optimization)
+	if err != nil {
+		return nil, OptimizeReport{}, err
+	}
+
+	out, report.Linearized = linearizePDF(ctx, out)
+	report.SizeAfter = len(out)
+	addOptimizeReport(ctx, report)
+
+	return out, report, nil
+}
+
+// optimizePDF is OptimizePDF without the linearization, for the documents
+// that are changed again, and without adding the report to the one of the
+// context.
+func optimizePDF(ctx context.Context, pdf []byte, optimization Optimization) (out []byte, report OptimizeReport, err error) {
+	_, span := tracer().Start(ctx, "optimize")
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("optimize.size_before", report.SizeBefore),
+			attribute.Int("optimize.size_after", report.SizeAfter),
+		)
+		endSpan(span, err)
+	}()
+
+	err = optimization.Validate()
+	if err != nil {
+		return nil, OptimizeReport{}, err
+	}
+
+	pdfCtx, _, _, _, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), model.NewDefaultConfiguration(), time.Now())
+	if err != nil {
+		return nil, OptimizeReport{}, ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or it's encrypted: %v", err)}
+	}
+
+	report = OptimizeReport{Documents: 1, SizeBefore: len(pdf)}
+	report.ImagesDownsampled, err = downsampleImages(pdfCtx, optimization)
+	if err != nil {
+		return nil, OptimizeReport{}, fmt.Errorf("can't downsample the images: %w", err)
+	}
+	compressStreams(pdfCtx)
+
+	buf := bytes.Buffer{}
+	err = api.WriteContext(pdfCtx, &buf)
+	if err != nil {
+		return nil, OptimizeReport{}, fmt.Errorf("can't write the optimized PDF: %w", err)
+	}
+
+	out = buf.Bytes()
+	if len(out) >= len(pdf) {
+		out = pdf
+		report.ImagesDownsampled = 0
+	}
+	report.SizeAfter = len(out)
+
+	return out, report, nil
+}
+
+// addOptimizeReport adds the report of a document to the one of the
+// context.
+func addOptimizeReport(ctx context.Context, report OptimizeReport) {
+	if total, ok := ctx.Value(optimizeReportKey{}).(*OptimizeReport); ok {
+		total.Documents += report.Documents
+		total.SizeBefore += report.SizeBefore
+		total.SizeAfter += report.SizeAfter
+		total.ImagesDownsampled += report.ImagesDownsampled
+		total.Linearized += report.Linearized
+	}
+}
+
+// linearizePDF linearizes the PDF with qpdf, so the viewers show the first
+// page before the whole document is downloaded. It returns 1 when it's
+// linearized; without qpdf, or when it fails, the PDF is returned as it is.
+func linearizePDF(ctx context.Context, pdf []byte) ([]byte, int) {
+	path, err := exec.LookPath("qpdf")
+	if err != nil {
+		return pdf, 0
+	}
+
+	ctx, span := tracer().Start(ctx, "linearize")
+	out, err := runQPDF(ctx, path, pdf)
+	endSpan(span, err)
+	if err != nil {
+		LoggerFromContext(ctx).Warn("can't linearize the PDF", "error", err)
+		return pdf, 0
+	}
+
+	return out, 1
+}
+
+// runQPDF runs qpdf --linearize with temporary files. The exit code 3 of
+// qpdf is a success with warnings.
+func runQPDF(ctx context.Context, path string, pdf []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "gohtmltopdf-qpdf-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in.pdf"), filepath.Join(dir, "out.pdf")
+	err = os.WriteFile(in, pdf, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, path, "--linearize", in, out)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("qpdf failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.ReadFile(out)
+}
+
+// compressStreams compresses the streams without filter, except the XMP
+// metadata that is kept readable.
+func compressStreams(pdfCtx *model.Context) {
+	for _, entry := range pdfCtx.Table {
+		if entry == nil || entry.Free {
+			continue
+		}
+		sd, ok := entry.Object.(types.StreamDict)
+		if !ok || sd.FilterPipeline != nil || len(sd.Raw) < minCompressBytes {
+			continue
+		}
+		if t := sd.Type(); t != nil && *t == "Metadata" {
+			continue
+		}
+
+		compressed := types.StreamDict{Dict: sd.Dict.Clone().(types.Dict), Content: sd.Raw}
+		compressed.FilterPipeline = []types.PDFFilter{{Name: filter.Flate}}
+		compressed.InsertName("Filter", filter.Flate)
+		if compressed.Encode() == nil && len(compressed.Raw) < len(sd.Raw) {
+			entry.Object = compressed
+		}
+	}
+}
+
+// imageSize is the largest size in points an image is shown with.
+type imageSize struct {
+	width, height float64
+}
+
+// downsampleImages resamples the images of the pages shown with more
+// resolution than the ImageDPI, and returns how many were downsampled.
+func downsampleImages(pdfCtx *model.Context, optimization Optimization) (int, error) {
+	dpi := optimization.ImageDPI
+	if dpi == 0 {
+		dpi = DefaultImageDPI
+	}
+	quality := optimization.JPEGQuality
+	if quality == 0 {
+		quality = DefaultJPEGQuality
+	}
+
+	err := pdfCtx.EnsurePageCount()
+	if err != nil {
+		return 0, err
+	}
+
+	sizes := map[int]imageSize{}
+	for i := 1; i <= pdfCtx.PageCount; i++ {
+		pageDict, _, inherited, err := pdfCtx.PageDict(i, false)
+		if err != nil {
+			return 0, err
+		}
+		// A page that can't be decoded keeps its images.
+		content, err := pdfCtx.PageContent(pageDict)
+		if err != nil {
+			continue
+		}
+		scanImages(pdfCtx, content, inherited.Resources, identityMatrix, sizes, 0)
+	}
+
+	downsampled := 0
+	for objNr, size := range sizes {
+		if downsampleImage(pdfCtx, objNr, size, dpi, quality) {
+			downsampled++
+		}
+	}
+
+	return downsampled, nil
+}
+
+// downsampleImage resamples the image to the dpi at its size. It's kept when
+// it isn't supported or the result isn't smaller: only the JPEG and the
+// uncompressed or Flate images of 8 bits in gray or RGB are resampled.
+func downsampleImage(pdfCtx *model.Context, objNr int, size imageSize, dpi, quality int) bool {
+	entry, ok := pdfCtx.FindTableEntryLight(objNr)
+	if !ok || entry.Free {
+		return false
+	}
+	sd, ok := entry.Object.(types.StreamDict)
+	if !ok {
+		return false
+	}
+	width, height := sd.IntEntry("Width"), sd.IntEntry("Height")
+	if width == nil || height == nil || *width < 1 || *height < 1 || size.width <= 0 || size.height <= 0 {
+		return false
+	}
+
+	// The scale keeps the resolution of both axes.
+	scale := math.Max(size.width/72*float64(dpi)/float64(*width), size.height/72*float64(dpi)/float64(*height))
+	if scale >= 1 {
+		return false
+	}
+
+	img, isJPEG := decodePDFImage(pdfCtx, sd, *width, *height)
+	if img == nil {
+		return false
+	}
+
+	bounds := image.Rect(0, 0, max(1, int(math.Round(float64(*width)*scale))), max(1, int(math.Round(float64(*height)*scale))))
+	var resized draw.Image = image.NewRGBA(bounds)
+	if _, gray := img.(*image.Gray); gray {
+		resized = image.NewGray(bounds)
+	}
+	draw.CatmullRom.Scale(resized, bounds, img, img.Bounds(), draw.Src, nil)
+
+	encoded, err := encodePDFImage(sd, resized, isJPEG, quality)
+	if err != nil || len(encoded.Raw) >= len(sd.Raw) {
+		return false
+	}
+	entry.Object = encoded
+
+	return true
+}
+
+// decodePDFImage returns the pixels of the image and whether it's a JPEG,
+// nil when it isn't supported.
+func decodePDFImage(pdfCtx *model.Context, sd types.StreamDict, width, height int) (image.Image, bool) {
+	if mask := sd.BooleanEntry("ImageMask"); mask != nil && *mask {
+		return nil, false
+	}
+	if bpc := sd.IntEntry("BitsPerComponent"); bpc == nil || *bpc != 8 {
+		return nil, false
+	}
+	if _, ok := sd.Find("Decode"); ok {
+		return nil, false
+	}
+	components := colorComponents(pdfCtx, sd.Dict["ColorSpace"])
+	if components != 1 && components != 3 {
+		return nil, false
+	}
+
+	switch {
+	case sd.HasSoleFilterNamed(filter.DCT):
+		img, err := jpeg.Decode(bytes.NewReader(sd.Raw))
+		if err != nil || img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+			return nil, false
+		}
+		switch img.(type) {
+		case *image.Gray:
+			return img, components == 1
+		case *image.CMYK:
+			return nil, false
+		default:
+			return img, components == 3
+		}
+	case sd.FilterPipeline == nil || sd.HasSoleFilterNamed(filter.Flate):
+		err := sd.Decode()
+		if err != nil || len(sd.Content) < width*height*components {
+			return nil, false
+		}
+		if components == 1 {
+			return &image.Gray{Pix: sd.Content[:width*height], Stride: width, Rect: image.Rect(0, 0, width, height)}, false
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for i := 0; i < width*height; i++ {
+			copy(img.Pix[4*i:4*i+3], sd.Content[3*i:3*i+3])
+			img.Pix[4*i+3] = 0xff
+		}
+		return img, false
+	default:
+		return nil, false
+	}
+}
+
+// encodePDFImage returns the image stream with the resampled pixels, in
+// JPEG or Flate like the original image.
+func encodePDFImage(sd types.StreamDict, img image.Image, isJPEG bool, quality int) (types.StreamDict, error) {
+	bounds := img.Bounds()
+	encoded := types.StreamDict{Dict: sd.Dict.Clone().(types.Dict)}
+	encoded.Update("Width", types.Integer(bounds.Dx()))
+	encoded.Update("Height", types.Integer(bounds.Dy()))
+	encoded.Delete("DecodeParms")
+
+	if isJPEG {
+		buf := bytes.Buffer{}
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+		if err != nil {
+			return types.StreamDict{}, err
+		}
+
+		// pdfcpu doesn't encode JPEG, the stream is the file.
+		encoded.FilterPipeline = []types.PDFFilter{{Name: filter.DCT}}
+		encoded.InsertName("Filter", filter.DCT)
+		encoded.Raw = buf.Bytes()
+		length := int64(len(encoded.Raw))
+		encoded.StreamLength = &length
+		encoded.Update("Length", types.Integer(length))
+		return encoded, nil
+	}
+
+	switch img := img.(type) {
+	case *image.Gray:
+		encoded.Content = img.Pix
+	case *image.RGBA:
+		encoded.Content = make([]byte, 0, 3*bounds.Dx()*bounds.Dy())
+		for i := 0; i < len(img.Pix); i += 4 {
+			encoded.Content = append(encoded.Content, img.Pix[i:i+3]...)
+		}
+	}
+	encoded.FilterPipeline = []types.PDFFilter{{Name: filter.Flate}}
+	encoded.InsertName("Filter", filter.Flate)
+	err := encoded.Encode()
+
+	return encoded, err
+}
+
+// colorComponents returns the components of the gray and RGB color spaces,
+// including the ICC profiles, and 0 for the other ones.
+func colorComponents(pdfCtx *model.Context, o types.Object) int {
+	o, err := pdfCtx.Dereference(o)
+	if err != nil {
+		return 0
+	}
+
+	switch cs := o.(type) {
+	case types.Name:
+		switch cs.Value() {
+		case "DeviceGray":
+			return 1
+		case "DeviceRGB":
+			return 3
+		}
+	case types.Array:
+		if len(cs) != 2 {
+			return 0
+		}
+		if name, ok := cs[0].(types.Name); !ok || name.Value() != "ICCBased" {
+			return 0
+		}
+		profile, _, err := pdfCtx.DereferenceStreamDict(cs[1])
+		if err != nil || profile == nil {
+			return 0
+		}
+		if n := profile.IntEntry("N"); n != nil && (*n == 1 || *n == 3) {
+			return *n
+		}
+	}
+
+	return 0
+}
+
+// matrix is a transformation matrix [a b c d e f] of PDF.
+type matrix [6]float64
+
+var identityMatrix = matrix{1, 0, 0, 1, 0, 0}
+
+// multiply returns the transformation m followed by n.
+func (m matrix) multiply(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2], m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2], m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4], m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// scanImages records the size of the images drawn by the content with the
+// matrix, and the ones of its forms.
+func scanImages(pdfCtx *model.Context, content []byte, resources types.Dict, ctm matrix, sizes map[int]imageSize, depth int) {
+	var stack []matrix
+	scanContent(content, func(op string, operands []string) {
+		switch op {
+		case "q":
+			stack = append(stack, ctm)
+		case "Q":
+			if len(stack) > 0 {
+				ctm = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if len(operands) < 6 {
+				return
+			}
+			var m matrix
+			for i, operand := range operands[len(operands)-6:] {
+				f, err := strconv.ParseFloat(operand, 64)
+				if err != nil {
+					return
+				}
+				m[i] = f
+			}
+			ctm = m.multiply(ctm)
+		case "Do":
+			if len(operands) > 0 {
+				drawXObject(pdfCtx, resources, operands[len(operands)-1], ctm, sizes, depth)
+			}
+		}
+	})
+}
+
+// drawXObject records the size of an image, or scans the content of a form.
+func drawXObject(pdfCtx *model.Context, resources types.Dict, name string, ctm matrix, sizes map[int]imageSize, depth int) {
+	xobjects, err := pdfCtx.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return
+	}
+	ref, ok := xobjects[strings.TrimPrefix(name, "/")].(types.IndirectRef)
+	if !ok {
+		return
+	}
+	sd, _, err := pdfCtx.DereferenceStreamDict(ref)
+	if err != nil || sd == nil || sd.Subtype() == nil {
+		return
+	}
+
+	switch *sd.Subtype() {
+	case "Image":
+		recordImage(sizes, ref.ObjectNumber.Value(), ctm)
+		// The soft mask is an image with its own resolution.
+		if mask, ok := sd.Dict["SMask"].(types.IndirectRef); ok {
+			recordImage(sizes, mask.ObjectNumber.Value(), ctm)
+		}
+	case "Form":
+		if depth >= maxFormDepth || sd.Decode() != nil {
+			return
+		}
+		if a, err := pdfCtx.DereferenceArray(sd.Dict["Matrix"]); err == nil && len(a) == 6 {
+			var m matrix
+			for i, o := range a {
+				f, err := pdfCtx.DereferenceNumber(o)
+				if err != nil {
+					return
+				}
+				m[i] = f
+			}
+			ctm = m.multiply(ctm)
+		}
+		if r, err := pdfCtx.DereferenceDict(sd.Dict["Resources"]); err == nil && r != nil {
+			resources = r
+		}
+		scanImages(pdfCtx, sd.Content, resources, ctm, sizes, depth+1)
+	}
+}
+
+// recordImage keeps the largest size of the image, the unit square scaled
+// by the matrix.
+func recordImage(sizes map[int]imageSize, objNr int, ctm matrix) {
+	size := sizes[objNr]
+	size.width = math.Max(size.width, math.Hypot(ctm[0], ctm[1]))
+	size.height = math.Max(size.height, math.Hypot(ctm[2], ctm[3]))
+	sizes[objNr] = size
+}
+
+// scanContent calls op with every operator of the content stream and the
+// numbers, names, strings and array delimiters before it. The strings keep
+// their delimiters and escapes. The delimiters of the dictionaries and the
+// data of the inline images are skipped.
+func scanContent(content []byte, op func(name string, operands []string)) {
+	var operands []string
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isPDFWhitespace(c):
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			start := i
+			i = skipLiteralString(content, i)
+			operands = append(operands, string(content[start:i]))
+		case c == '<' && i+1 < len(content) && content[i+1] == '<', c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			start := i
+			for i < len(content) && content[i] != '>' {
+				i++
+			}
+			i++
+			operands = append(operands, string(content[start:min(i, len(content))]))
+		case c == '[' || c == ']':
+			operands = append(operands, string(c))
+			i++
+		case c == '{' || c == '}' || c == ')' || c == '>':
+			i++
+		default:
+			start := i
+			for i++; i < len(content) && !isPDFWhitespace(content[i]) && !isPDFDelimiter(content[i]); i++ {
+			}
+			token := string(content[start:i])
+			if c == '/' || c == '+' || c == '-' || c == '.' || c >= '0' && c <= '9' || token == "true" || token == "false" || token == "null" {
+				operands = append(operands, token)
+				continue
+			}
+
+			op(token, operands)
+			operands = operands[:0]
+			if token == "ID" {
+				i = skipInlineImage(content, i)
+			}
+		}
+	}
+}
+
+// skipLiteralString returns the position after the string that starts at
+// i, its parentheses can be nested.
+func skipLiteralString(content []byte, i int) int {
+	depth := 0
+	for ; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return i
+}
+
+// skipInlineImage returns the position after the EI operator of the inline
+// image whose data starts after the ID operator at i.
+func skipInlineImage(content []byte, i int) int {
+	for j := i + 2; j+1 < len(content); j++ {
+		if isPDFWhitespace(content[j-1]) && content[j] == 'E' && content[j+1] == 'I' && (j+2 == len(content) || isPDFWhitespace(content[j+2])) {
+			return j + 2
+		}
+	}
+
+	return len(content)
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
@@ -0,0 +1,228 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// testPhoto returns the HTML of a large JPEG shown 100 pixels high.
+func testPhoto(t *testing.T) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 1600, 1200))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i*7 + i/6400)
+	}
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("Got an unexpected error encoding the image: %v", err)
+	}
+
+	return `<p>Foto</p><img height="100" src="data:image/jpeg;base64,` + base64.StdEncoding.EncodeToString(buf.Bytes()) + `">`
+}
+
+// imageHeights returns the heights of the images of the PDF.
+func imageHeights(t *testing.T, pdf []byte) []int {
+	t.Helper()
+
+	ctx, err := api.ReadContext(bytes.NewReader(pdf), nil)
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the PDF: %v", err)
+	}
+	var heights []int
+	for _, entry := range ctx.Table {
+		if sd, ok := entry.Object.(types.StreamDict); ok && sd.Image() {
+			heights = append(heights, *sd.IntEntry("Height"))
+		}
+	}
+
+	return heights
+}
+
+func TestOptimizePDF(t *testing.T) {
+	pdf := renderTestPDF(t, testPhoto(t))
+
+	out, report, err := OptimizePDF(context.Background(), pdf, Optimization{})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if report.SizeBefore != len(pdf) || report.SizeAfter != len(out) || report.SizeAfter >= report.SizeBefore/2 {
+		t.Errorf("report = %+v, the photo must be downsampled", report)
+	}
+	if report.ImagesDownsampled != 1 {
+		t.Errorf("images downsampled = %d, want 1", report.ImagesDownsampled)
+	}
+	// 100 pixels are 75 points, 156 pixels at 150 DPI.
+	if heights := imageHeights(t, out); len(heights) != 1 || heights[0] < 150 || heights[0] > 160 {
+		t.Errorf("heights = %v, want 156", heights)
+	}
+	if err := api.Validate(bytes.NewReader(out), nil); err != nil {
+		t.Errorf("the optimized PDF isn't valid: %v", err)
+	}
+
+	again, report, err := OptimizePDF(context.Background(), out, Optimization{ImageDPI: 300})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if report.ImagesDownsampled != 0 || len(again) > len(out) {
+		t.Errorf("report = %+v, the optimized PDF must be kept", report)
+	}
+
+	// The optimization goes before the PDF/A profile.
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	archived, err := gen.Render(context.Background(), strings.NewReader(testPhoto(t)), RenderOptions{Optimize: &Optimization{}, Profile: ProfilePDFA2B})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	checkPDFA(t, archived)
+
+	for _, optimization := range []Optimization{{ImageDPI: 10}, {JPEGQuality: 101}} {
+		_, _, err = OptimizePDF(context.Background(), pdf, optimization)
+		if !errors.As(err, &ErrorProcess{}) {
+			t.Errorf("%+v: err = %v, want an ErrorProcess", optimization, err)
+		}
+	}
+	_, _, err = OptimizePDF(context.Background(), []byte("not a pdf"), Optimization{})
+	if !errors.As(err, &ErrorProcess{}) {
+		t.Errorf("err = %v, want an ErrorProcess", err)
+	}
+}
+
+func TestLinearizePDF(t *testing.T) {
+	pdf := renderTestPDF(t, "<p>a</p>")
+
+	// Without qpdf the documents aren't linearized.
+	path := os.Getenv("PATH")
+	t.Setenv("PATH", t.TempDir())
+	_, report, err := OptimizePDF(context.Background(), pdf, Optimization{})
+	if err != nil || report.Linearized != 0 {
+		t.Errorf("report = %+v, err = %v, the PDF can't be linearized without qpdf", report, err)
+	}
+
+	// The fake qpdf copies the document with a comment at the end.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+[ "$1" = --linearize ] || exit 2
+cat "$2" > "$3" && echo "% linearized" >> "$3"
+`
+	if err := os.WriteFile(filepath.Join(dir, "qpdf"), []byte(script), 0o700); err != nil {
+		t.Fatalf("Got an unexpected error writing qpdf: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	out, report, err := OptimizePDF(context.Background(), pdf, Optimization{})
+	if err != nil || report.Linearized != 1 || report.SizeAfter != len(out) || !bytes.Contains(out, []byte("% linearized")) {
+		t.Errorf("report = %+v, err = %v, the PDF must be linearized", report, err)
+	}
+
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+	for body, want := range map[string]string{
+		`{"data": "<p>a</p>", "options": {"optimize": {}, "metadata": {"title": "Nómina"}}}`:                             "true",
+		`{"data": "<p>a</p>", "options": {"optimize": {}, "encryption": {"user_password": "x", "owner_password": "y"}}}`: "false",
+	} {
+		rec := doJSON(e, http.MethodPost, "/html-to-pdf", body)
+		if rec.Code != http.StatusOK || rec.Header().Get(HeaderLinearized) != want {
+			t.Errorf("%s: status = %d, %s = %q, want %q", body, rec.Code, HeaderLinearized, rec.Header().Get(HeaderLinearized), want)
+		}
+	}
+}
+
+func TestScanContent(t *testing.T) {
+	content := "q 100 0 0 50 10 10 cm /Im1 Do Q\n(a \\) /Im3 Do) Tj [(b) -20 (c)] TJ\n" +
+		"BI /W 1 /H 1 /CS /G /BPC 8 ID \x00 Do\nEI /Im2 Do % /Im4 Do"
+
+	var draws []string
+	var cm []string
+	var shown []string
+	scanContent([]byte(content), func(op string, operands []string) {
+		switch op {
+		case "Do":
+			draws = append(draws, operands[len(operands)-1])
+		case "cm":
+			cm = append(cm, operands...)
+		case "Tj", "TJ":
+			shown = append(shown, operands...)
+		}
+	})
+	if strings.Join(draws, ",") != "/Im1,/Im2" {
+		t.Errorf("draws = %v, want /Im1,/Im2", draws)
+	}
+	if strings.Join(cm, " ") != "100 0 0 50 10 10" {
+		t.Errorf("cm = %v", cm)
+	}
+	if got := strings.Join(shown, " "); got != `(a \) /Im3 Do) [ (b) -20 (c) ]` {
+		t.Errorf("shown = %s", got)
+	}
+}
+
+func TestHandler_optimize(t *testing.T) {
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+
+	photo, _ := json.Marshal(testPhoto(t))
+	rec := doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": `+string(photo)+`, "options": {"optimize": {"image_dpi": 96}}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	before, _ := strconv.Atoi(rec.Header().Get(HeaderOriginalSize))
+	after, _ := strconv.Atoi(rec.Header().Get(HeaderOptimizedSize))
+	res := map[string][]byte{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	if after == 0 || after >= before || after != len(res["data"]) {
+		t.Errorf("sizes = %d -> %d, the response has %d bytes", before, after, len(res["data"]))
+	}
+
+	rec = doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": "<p>a</p>"}`)
+	if rec.Header().Get(HeaderOriginalSize) != "" {
+		t.Error("the documents without optimize must not have the sizes")
+	}
+
+	pdf := string(renderTestPDF(t, testPhoto(t)))
+	rec = doPDF(e, "/pdf/optimize", []testPart{{name: "pdf", data: pdf}, {name: "jpeg_quality", data: "60"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get(HeaderOriginalSize); got != fmt.Sprint(len(pdf)) {
+		t.Errorf("%s = %q, want %d", HeaderOriginalSize, got, len(pdf))
+	}
+
+	for _, field := range []testPart{{name: "image_dpi", data: "high"}, {name: "image_dpi", data: "10"}} {
+		rec = doPDF(e, "/pdf/optimize", []testPart{{name: "pdf", data: pdf}, field})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s=%s: status = %d, want %d", field.name, field.data, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestJobs_optimize(t *testing.T) {
+	e := newJobsServer(t)
+
+	rec := doJSON(e, http.MethodPost, "/jobs", `{"type": "dian-form-220", "split": true, "request": {"data": [{"year": 2022, "rows": {}}, {"year": 2022, "rows": {}}], "optimize": {}}}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	job := Job{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &job)
+
+	job = waitJob(t, e, job.ID)
+	if job.Status != JobDone || job.Optimization == nil || job.Optimization.Documents != 2 {
+		t.Errorf("job = %+v, optimization = %+v", job, job.Optimization)
+	}
+}
@@ -0,0 +1,153 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// The pages are selected with the syntax of pdfcpu: page numbers and ranges
+// separated by commas, like "1-3,5,8-". A range without end goes to the
+// last page, and "l" is the last page.
+
+// SplitPDF splits the PDF in documents of every pages, the last one can be
+// shorter.
+func SplitPDF(ctx context.Context, pdf []byte, every int) (parts [][]byte, err error) {
+	_, span := tracer().Start(ctx, "split")
+	defer func() { endSpan(span, err) }()
+
+	if every < 1 {
+		return nil, ErrorProcess{Msg: "the pages of every part must be at least 1"}
+	}
+
+	spans, err := api.SplitRaw(bytes.NewReader(pdf), every, nil)
+	if err != nil {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("can't split the PDF: %v", err)}
+	}
+
+	parts = make([][]byte, len(spans))
+	for i, s := range spans {
+		parts[i], err = io.ReadAll(s.Reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parts, nil
+}
+
+// SplitPDFByRanges returns a document for each page selection, e.g.
+// "1-2", "3", "4-".
+func SplitPDFByRanges(ctx context.Context, pdf []byte, ranges []string) ([][]byte, error) {
+	if len(ranges) == 0 {
+		return nil, ErrorProcess{Msg: "there aren't page ranges to split"}
+	}
+
+	parts := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		part, err := ExtractPages(ctx, pdf, r)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = part
+	}
+
+	return parts, nil
+}
+
+// ExtractPages returns a document with the selected pages, in the order of
+// the PDF.
+func ExtractPages(ctx context.Context, pdf []byte, pages string) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "extract")
+	defer func() { endSpan(span, err) }()
+
+	selection, err := selectPages(pdf, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	err = api.Trim(bytes.NewReader(pdf), &buf, selection, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't extract the pages: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// RotatePages rotates the selected pages clockwise by the degrees, a
+// multiple of 90. An empty selection rotates every page.
+func RotatePages(ctx context.Context, pdf []byte, degrees int, pages string) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "rotate")
+	defer func() { endSpan(span, err) }()
+
+	if degrees%90 != 0 {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the rotation must be a multiple of 90, got %d", degrees)}
+	}
+
+	var selection []string
+	if pages != "" {
+		selection, err = selectPages(pdf, pages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf := bytes.Buffer{}
+	err = api.Rotate(bytes.NewReader(pdf), &buf, degrees, selection, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't rotate the pages: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ReorderPages returns a document with the pages in the order of the
+// selection, e.g. "3,1-2". The pages that aren't selected are removed, and
+// a page can be repeated.
+func ReorderPages(ctx context.Context, pdf []byte, order string) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "reorder")
+	defer func() { endSpan(span, err) }()
+
+	selection, err := selectPages(pdf, order)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	err = api.Collect(bytes.NewReader(pdf), &buf, selection, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't reorder the pages: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// selectPages parses the selection and checks that it has pages of the PDF.
+func selectPages(pdf []byte, pages string) ([]string, error) {
+	pages = strings.ReplaceAll(pages, " ", "")
+	if pages == "" {
+		return nil, ErrorProcess{Msg: "the pages are required"}
+	}
+
+	selection, err := api.ParsePageSelection(pages)
+	if err != nil {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("invalid pages %q: %v", pages, err)}
+	}
+
+	count, err := pageCount(pdf, "")
+	if err != nil {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or it's encrypted: %v", err)}
+	}
+
+	selected, err := api.PagesForPageCollection(count, selection)
+	if err != nil || len(selected) == 0 {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the pages %q aren't in the document of %d pages", pages, count)}
+	}
+
+	return selection, nil
+}
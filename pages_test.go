@@ -0,0 +1,151 @@
+package gohtmltopdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// testPages returns a PDF with a page for every text.
+func testPages(t *testing.T, texts ...string) []byte {
+	t.Helper()
+
+	parts := make([]MergePart, len(texts))
+	for i, text := range texts {
+		parts[i] = MergePart{PDF: renderTestPDF(t, "<p>"+text+"</p>")}
+	}
+	pdf, err := MergePDFs(context.Background(), parts)
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the PDF: %v", err)
+	}
+
+	return pdf
+}
+
+// pageTexts returns the texts of the pages of the PDF.
+func pageTexts(t *testing.T, pdf []byte) []string {
+	t.Helper()
+
+	inspection, err := InspectPDF(context.Background(), pdf, InspectOptions{Text: true})
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the texts: %v", err)
+	}
+
+	return inspection.Texts
+}
+
+func TestPages(t *testing.T) {
+	ctx := context.Background()
+	pdf := testPages(t, "one", "two", "three", "four")
+
+	tests := []struct {
+		name string
+		fn   func() ([]byte, error)
+		want string
+	}{
+		{"extract", func() ([]byte, error) { return ExtractPages(ctx, pdf, "4,1-2") }, "one,two,four"},
+		{"extract to the end", func() ([]byte, error) { return ExtractPages(ctx, pdf, "3-") }, "three,four"},
+		{"reorder", func() ([]byte, error) { return ReorderPages(ctx, pdf, "4,1-2,2") }, "four,one,two,two"},
+		{"rotate", func() ([]byte, error) { return RotatePages(ctx, pdf, 90, "1") }, "one,two,three,four"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.fn()
+			if err != nil {
+				t.Fatalf("Got an unexpected error: %v", err)
+			}
+			if got := strings.Join(pageTexts(t, out), ","); got != tt.want {
+				t.Errorf("pages = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	parts, err := SplitPDF(ctx, pdf, 3)
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("SplitPDF() = %d parts, %v, want 2", len(parts), err)
+	}
+	if got := strings.Join(pageTexts(t, parts[1]), ","); got != "four" {
+		t.Errorf("last part = %s, want four", got)
+	}
+
+	parts, err = SplitPDFByRanges(ctx, pdf, []string{"1", "2-3"})
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("SplitPDFByRanges() = %d parts, %v, want 2", len(parts), err)
+	}
+	if got := strings.Join(pageTexts(t, parts[1]), ","); got != "two,three" {
+		t.Errorf("second part = %s, want two,three", got)
+	}
+}
+
+func TestPages_errors(t *testing.T) {
+	ctx := context.Background()
+	pdf := testPages(t, "one", "two")
+
+	if _, err := ExtractPages(ctx, pdf, "a-b"); err == nil {
+		t.Error("an invalid selection must be rejected")
+	}
+	if _, err := ExtractPages(ctx, pdf, "5-6"); err == nil {
+		t.Error("the pages out of the document must be rejected")
+	}
+	if _, err := RotatePages(ctx, pdf, 45, ""); err == nil {
+		t.Error("a rotation that isn't a multiple of 90 must be rejected")
+	}
+	if _, err := SplitPDF(ctx, pdf, 0); err == nil {
+		t.Error("a split of 0 pages must be rejected")
+	}
+}
+
+func TestHandler_pages(t *testing.T) {
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+	pdf := string(testPages(t, "one", "two", "three"))
+
+	rec := doPDF(e, "/pdf/split", []testPart{{name: "pdf", data: pdf}, {name: "every", data: "2"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	res := map[string][]byte{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	archive, err := zip.NewReader(bytes.NewReader(res["data"]), int64(len(res["data"])))
+	if err != nil || len(archive.File) != 2 || archive.File[1].Name != "part-2.pdf" {
+		t.Fatalf("the split must respond a ZIP with 2 parts: %v", err)
+	}
+
+	rec = doPDF(e, "/pdf/reorder", []testPart{{name: "html", data: `{"data": "<p>one</p><div style=\"page-break-after: always\"></div><p>two</p>"}`}, {name: "order", data: "2,1"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	if got := strings.Join(pageTexts(t, res["data"]), ","); got != "two,one" {
+		t.Errorf("the render must be reordered, pages = %s", got)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		parts []testPart
+		want  int
+	}{
+		{"extract", "/pdf/extract", []testPart{{name: "pdf", data: pdf}, {name: "pages", data: "2"}}, http.StatusOK},
+		{"rotate", "/pdf/rotate", []testPart{{name: "pdf", data: pdf}, {name: "degrees", data: "180"}}, http.StatusOK},
+		{"split by ranges", "/pdf/split", []testPart{{name: "pdf", data: pdf}, {name: "range", data: "1"}, {name: "range", data: "2-"}}, http.StatusOK},
+		{"two documents", "/pdf/extract", []testPart{{name: "pdf", data: pdf}, {name: "pdf", data: pdf}, {name: "pages", data: "1"}}, http.StatusBadRequest},
+		{"without pages", "/pdf/extract", []testPart{{name: "pdf", data: pdf}}, http.StatusBadRequest},
+		{"split without fields", "/pdf/split", []testPart{{name: "pdf", data: pdf}}, http.StatusBadRequest},
+		{"unknown field", "/pdf/rotate", []testPart{{name: "pdf", data: pdf}, {name: "order", data: "1"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doPDF(e, tt.path, tt.parts)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/johnfercher/maroto/v2/pkg/config"
+	"github.com/johnfercher/maroto/v2/pkg/consts/fontstyle"
+	"github.com/johnfercher/maroto/v2/pkg/core/entity"
+	"github.com/johnfercher/maroto/v2/pkg/props"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// ProfilePDFA2B is the output profile of the archival documents, PDF/A-2b.
+const ProfilePDFA2B = "pdfa-2b"
+
+var validProfiles = []string{ProfilePDFA2B}
+
+// pdfaFontFamily is the family of the fonts embedded by maroto in the PDF/A
+// documents, its standard fonts like Arial aren't embedded.
+const pdfaFontFamily = "go"
+
+// srgbCondition identifies the sRGB output intent.
+const srgbCondition = "sRGB IEC61966-2.1"
+
+// validateProfile checks the output profile of a request. The PDF/A
+// documents can't be encrypted, and can't have text watermarks because
+// they use standard fonts that aren't embedded.
+func validateProfile(profile string, watermarks []Watermark, encryption *Encryption) error {
+	if profile == "" {
+		return nil
+	}
+	if !containsFold(validProfiles, profile) {
+		return ErrorProcess{Msg: fmt.Sprintf("profile %q not supported", profile)}
+	}
+	if encryption != nil {
+		return ErrorProcess{Msg: "the PDF/A documents can't be encrypted"}
+	}
+	for _, w := range watermarks {
+		if w.Text != "" {
+			return ErrorProcess{Msg: "the PDF/A documents only support image watermarks, the fonts of the texts aren't embedded"}
+		}
+	}
+
+	return nil
+}
+
+// isPDFA reports whether the profile is PDF/A.
+func isPDFA(profile string) bool {
+	return containsFold(validProfiles, profile)
+}
+
+// withPDFAFonts makes maroto embed the Go fonts instead of its standard
+// fonts.
+func withPDFAFonts(builder config.Builder) config.Builder {
+	return builder.
+		WithCustomFonts([]*entity.CustomFont{
+			{Family: pdfaFontFamily, Style: fontstyle.Normal, Bytes: goregular.TTF},
+			{Family: pdfaFontFamily, Style: fontstyle.Bold, Bytes: gobold.TTF},
+			{Family: pdfaFontFamily, Style: fontstyle.Italic, Bytes: goitalic.TTF},
+			{Family: pdfaFontFamily, Style: fontstyle.BoldItalic, Bytes: gobolditalic.TTF},
+		}).
+		WithDefaultFont(&props.Font{Family: pdfaFontFamily})
+}
+
+// ConvertToPDFA returns the PDF as a PDF/A-2b: it adds the sRGB output
+// intent and the XMP metadata of PDF/A, and validates the result with
+// pdfcpu. The fonts must be embedded, and the PDF can't be encrypted.
+// pdfcpu only validates the PDF syntax, so the other rules of PDF/A, like
+// the colors of the images, depend on the backend.
+func ConvertToPDFA(ctx context.Context, pdf []byte, metadata Metadata) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "pdfa")
+	defer func() { endSpan(span, err) }()
+
+	err = metadata.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// pdfcpu sets the dates of the Info dictionary when it writes, they must
+	// be the ones of the XMP, so it's written again if the second changed.
+	for attempt := 0; attempt < 3; attempt++ {
+		date := time.Now()
+		var written string
+		out, written, err = writePDFA(pdf, metadata, date)
+		if err != nil {
+			return nil, err
+		}
+		if written != types.DateString(date) {
+			continue
+		}
+
+		err = api.Validate(bytes.NewReader(out), strictConfiguration())
+		if err != nil {
+			return nil, ErrorProcess{Msg: fmt.Sprintf("can't produce a valid PDF/A-2b: %v", err)}
+		}
+
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("can't produce a PDF/A-2b: the dates of the metadata don't match")
+}
+
+// writePDFA writes the PDF/A and returns the creation date written by
+// pdfcpu.
+func writePDFA(pdf []byte, metadata Metadata, date time.Time) ([]byte, string, error) {
+	pdfCtx, _, _, _, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), model.NewDefaultConfiguration(), time.Now())
+	if err != nil {
+		return nil, "", ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or it's encrypted: %v", err)}
+	}
+	if pdfCtx.Encrypt != nil {
+		return nil, "", ErrorProcess{Msg: "the PDF/A documents can't be encrypted"}
+	}
+
+	err = checkEmbeddedFonts(pdfCtx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = setOutputIntent(pdfCtx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = fixOptionalContent(pdfCtx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = writeMetadata(pdfCtx, metadata, date)
+	if err != nil {
+		return nil, "", err
+	}
+
+	buf := bytes.Buffer{}
+	err = api.WriteContext(pdfCtx, &buf)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't write the PDF/A: %w", err)
+	}
+
+	var written string
+	info, err := pdfCtx.DereferenceDict(*pdfCtx.Info)
+	if err == nil && info != nil {
+		if s, ok := info["CreationDate"].(types.StringLiteral); ok {
+			written = s.Value()
+		}
+	}
+
+	return buf.Bytes(), written, nil
+}
+
+// checkEmbeddedFonts returns an error with the first font that isn't
+// embedded. The Type3 fonts are drawn by the PDF, so they're embedded.
+func checkEmbeddedFonts(pdfCtx *model.Context) error {
+	for _, font := range pdfCtx.Optimize.FontObjects {
+		if font.SubType() == "Type3" || fontEmbedded(pdfCtx, font.FontDict) {
+			continue
+		}
+
+		return ErrorProcess{Msg: fmt.Sprintf("can't produce a PDF/A-2b, the font %s isn't embedded. "+
+			"The maroto backend embeds its fonts with this profile, with wkhtmltopdf use fonts installed in the system", font.FontName)}
+	}
+
+	return nil
+}
+
+// fontEmbedded reports whether the font, or its descendant font, has a
+// font file.
+func fontEmbedded(pdfCtx *model.Context, font types.Dict) bool {
+	if descendants, err := pdfCtx.DereferenceArray(font["DescendantFonts"]); err == nil && len(descendants) > 0 {
+		descendant, err := pdfCtx.DereferenceDict(descendants[0])
+		return err == nil && descendant != nil && fontEmbedded(pdfCtx, descendant)
+	}
+
+	descriptor, err := pdfCtx.DereferenceDict(font["FontDescriptor"])
+	if err != nil || descriptor == nil {
+		return false
+	}
+	for _, key := range []string{"FontFile", "FontFile2", "FontFile3"} {
+		if _, ok := descriptor.Find(key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setOutputIntent replaces the output intents of the catalog with the
+// sRGB profile.
+func setOutputIntent(pdfCtx *model.Context) error {
+	catalog, err := pdfCtx.Catalog()
+	if err != nil {
+		return err
+	}
+
+	sd, err := pdfCtx.NewStreamDictForBuf(srgbProfile())
+	if err != nil {
+		return err
+	}
+	sd.InsertInt("N", 3)
+	err = sd.Encode()
+	if err != nil {
+		return err
+	}
+	profile, err := pdfCtx.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	catalog.Update("OutputIntents", types.Array{types.Dict{
+		"Type":                      types.Name("OutputIntent"),
+		"S":                         types.Name("GTS_PDFA1"),
+		"OutputConditionIdentifier": types.StringLiteral(srgbCondition),
+		"Info":                      types.StringLiteral(srgbCondition),
+		"DestOutputProfile":         *profile,
+	}})
+
+	return nil
+}
+
+// fixOptionalContent makes the layers of the watermarks valid in PDF/A:
+// every configuration has a Name and no AS.
+func fixOptionalContent(pdfCtx *model.Context) error {
+	catalog, err := pdfCtx.Catalog()
+	if err != nil {
+		return err
+	}
+	properties, err := pdfCtx.DereferenceDict(catalog["OCProperties"])
+	if err != nil || properties == nil {
+		return err
+	}
+
+	configs := []types.Object{properties["D"]}
+	if others, err := pdfCtx.DereferenceArray(properties["Configs"]); err == nil {
+		configs = append(configs, others...)
+	}
+	for i, c := range configs {
+		config, err := pdfCtx.DereferenceDict(c)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			continue
+		}
+		if _, ok := config.Find("Name"); !ok {
+			config.InsertString("Name", fmt.Sprintf("Layers %d", i+1))
+		}
+		config.Delete("AS")
+	}
+
+	return nil
+}
+
+func strictConfiguration() *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationStrict
+
+	return conf
+}
+
+// srgbProfile is an ICC v2 profile of sRGB, built once with the primaries
+// adapted to D50 and the tone curve of sRGB in a table.
+var srgbProfile = sync.OnceValue(func() []byte {
+	xyz := func(x, y, z float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+		}
+		return b
+	}
+
+	curve := []byte("curv\x00\x00\x00\x00")
+	curve = binary.BigEndian.AppendUint32(curve, 1024)
+	for i := 0; i < 1024; i++ {
+		v := float64(i) / 1023
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		curve = binary.BigEndian.AppendUint16(curve, uint16(math.Round(v*65535)))
+	}
+
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(srgbCondition)+1))
+	desc = append(desc, srgbCondition+"\x00"...)
+	// Empty Unicode and ScriptCode descriptions.
+	desc = append(desc, make([]byte, 4+4+2+1+67)...)
+
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", desc},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := 128 + 4 + 12*len(tags)
+	for _, tag := range tags {
+		table = append(table, tag.signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+		data = append(data, tag.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(128+len(table)+len(data)))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntrRGB XYZ ")
+	for i, v := range []uint16{2024, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	// The illuminant of the profile connection space is D50.
+	copy(header[68:], xyz(0.9642, 1, 0.8249)[8:])
+
+	return append(append(header, table...), data...)
+})
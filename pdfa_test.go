@@ -0,0 +1,167 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// checkPDFA checks the parts of a PDF/A-2b added by ConvertToPDFA.
+func checkPDFA(t *testing.T, pdf []byte) {
+	t.Helper()
+
+	if err := api.Validate(bytes.NewReader(pdf), strictConfiguration()); err != nil {
+		t.Errorf("the PDF/A must pass the strict validation: %v", err)
+	}
+
+	pdfCtx, _, _, _, err := api.ReadValidateAndOptimize(bytes.NewReader(pdf), model.NewDefaultConfiguration(), time.Now())
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the PDF: %v", err)
+	}
+	if err := checkEmbeddedFonts(pdfCtx); err != nil {
+		t.Error(err)
+	}
+	catalog, _ := pdfCtx.Catalog()
+	if _, ok := catalog.Find("OutputIntents"); !ok {
+		t.Error("the PDF/A must have an output intent")
+	}
+
+	for _, want := range []string{"<pdfaid:part>2</pdfaid:part>", "<pdfaid:conformance>B</pdfaid:conformance>"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("the XMP must have %s", want)
+		}
+	}
+
+	info := info(t, pdf, "")
+	date := info["CreationDate"]
+	if len(date) < 16 {
+		t.Fatalf("invalid CreationDate %q", date)
+	}
+	created, err := time.Parse("D:20060102150405", date[:16])
+	if err != nil {
+		t.Fatalf("invalid CreationDate %q", info["CreationDate"])
+	}
+	if !bytes.Contains(pdf, []byte("<xmp:CreateDate>"+created.Format("2006-01-02T15:04:05"))) {
+		t.Errorf("the XMP must have the CreationDate %s of the Info", info["CreationDate"])
+	}
+}
+
+func TestGenerator_Render_pdfa(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	pdf, err := gen.Render(context.Background(), strings.NewReader("<h1>Certificado</h1><p><b>Año</b> <i>2024</i></p>"), RenderOptions{
+		Profile:    ProfilePDFA2B,
+		Title:      "Certificado",
+		Metadata:   &Metadata{Author: "Nómina", Properties: map[string]string{"Area": "RRHH"}},
+		Watermarks: []Watermark{{Image: testPNG(t), Position: "bottom-right"}},
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	checkPDFA(t, pdf)
+	if got := info(t, pdf, ""); got["Title"] != "Certificado" || got["Author"] != "Nómina" || got["Area"] != "RRHH" {
+		t.Errorf("unexpected Info %v", got)
+	}
+	if bytes.Contains(pdf, []byte("<pdfx:Area>")) {
+		t.Error("the XMP of a PDF/A can't have the custom properties without their schema")
+	}
+}
+
+func TestConvertToPDFA_fontsNotEmbedded(t *testing.T) {
+	_, err := ConvertToPDFA(context.Background(), renderTestPDF(t, "<p>a</p>"), Metadata{})
+	if !errors.As(err, &ErrorProcess{}) || !strings.Contains(err.Error(), "Helvetica isn't embedded") {
+		t.Errorf("error = %v, want the font that isn't embedded", err)
+	}
+}
+
+func TestDIAN_pdfa(t *testing.T) {
+	// The template reads its images from the working directory, without
+	// them maroto writes an error with a standard font.
+	dir := t.TempDir()
+	for _, name := range []string{"logo_dian.png", "form_220.png"} {
+		if err := os.WriteFile(dir+"/"+name, testPNG(t), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	item := DIANForm220Relation{DIANForm220: DIANForm220{Year: 2022, Records: json.RawMessage(`{}`)}, IdentificationNumber: "1098765432"}
+	dian := NewDIAN(false)
+	dian.Profile = ProfilePDFA2B
+	pdf, err := dian.CreateDIANForm220(context.Background(), DIANForms220Relation{item})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	checkPDFA(t, pdf)
+	if got := info(t, pdf, "")["Title"]; got != "Certificado 220 2022 - 1098765432" {
+		t.Errorf("Title = %q", got)
+	}
+}
+
+func TestValidateProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		profile    string
+		watermarks []Watermark
+		encryption *Encryption
+		wantErr    bool
+	}{
+		{"without profile", "", []Watermark{{Text: "COPIA"}}, &Encryption{}, false},
+		{"pdfa", "PDFA-2B", []Watermark{{Image: []byte{1}}}, nil, false},
+		{"unknown", "pdfx-1a", nil, nil, true},
+		{"encrypted", ProfilePDFA2B, nil, &Encryption{}, true},
+		{"text watermark", ProfilePDFA2B, []Watermark{{Text: "COPIA"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateProfile(tt.profile, tt.watermarks, tt.encryption)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateProfile() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSRGBProfile(t *testing.T) {
+	profile := srgbProfile()
+	if size := binary.BigEndian.Uint32(profile); int(size) != len(profile) {
+		t.Errorf("size = %d, want %d", size, len(profile))
+	}
+	if string(profile[36:40]) != "acsp" || string(profile[16:20]) != "RGB " {
+		t.Error("the profile must be an RGB ICC profile")
+	}
+	if tags := binary.BigEndian.Uint32(profile[128:]); tags != 9 {
+		t.Errorf("tags = %d, want 9", tags)
+	}
+}
+
+func TestHandler_pdfa(t *testing.T) {
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+
+	rec := doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": "<p>a</p>", "options": {"profile": "pdfa-2b"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": "<p>a</p>", "options": {"profile": "pdfa-2b", "encryption": {"user_password": "x"}}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an encrypted PDF/A: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,44 @@
+package gohtmltopdf
+
+import "context"
+
+// Pool bounds the number of concurrent renders, every wkhtmltopdf process
+// uses a CPU and a few hundred MB of memory. A nil *Pool doesn't limit the
+// renders.
+type Pool struct {
+	slots chan struct{}
+}
+
+func NewPool(size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Pool{slots: make(chan struct{}, size)}
+}
+
+// Do runs fn when there is a free slot, or returns the error of the context
+// if it's done while waiting.
+func (p *Pool) Do(ctx context.Context, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	return fn()
+}
+
+// Size returns the number of concurrent renders.
+func (p *Pool) Size() int {
+	if p == nil {
+		return 0
+	}
+
+	return cap(p.slots)
+}
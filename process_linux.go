@@ -0,0 +1,19 @@
+//go:build linux
+
+package gohtmltopdf
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxRSS returns the maximum resident set size in bytes of a finished process.
+func maxRSS(state *os.ProcessState) (int64, bool) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+
+	// On Linux the value is in kilobytes.
+	return rusage.Maxrss * 1024, true
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package gohtmltopdf
+
+import "os"
+
+// maxRSS isn't supported on this platform, the units of the rusage differ
+// between systems.
+func maxRSS(*os.ProcessState) (int64, bool) {
+	return 0, false
+}
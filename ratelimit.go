@@ -0,0 +1,516 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Headers of the rate limits, from the IETF draft of the RateLimit fields.
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+)
+
+// bucketIdle is how long an unused bucket is kept, an idle bucket is full so
+// removing it doesn't change the limits.
+const bucketIdle = 10 * time.Minute
+
+var (
+	ErrRateLimited   = errors.New("too many requests, slow down")
+	ErrQuotaExceeded = errors.New("the daily quota is exceeded")
+)
+
+// Limits are the limits of a client, a zero value doesn't limit.
+type Limits struct {
+	// Rate is the number of requests per second and Burst how many can be
+	// made at once.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// DailyRenders and DailyPages are the quotas of a UTC day.
+	DailyRenders int `json:"daily_renders"`
+	DailyPages   int `json:"daily_pages"`
+}
+
+// RateLimitConfig has the limits of each client by its name, the others and
+// the anonymous requests use the Default.
+type RateLimitConfig struct {
+	Default Limits            `json:"default"`
+	Clients map[string]Limits `json:"clients"`
+}
+
+// LoadRateLimits reads a JSON file with a RateLimitConfig.
+func LoadRateLimits(path string) (RateLimitConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RateLimitConfig{}, fmt.Errorf("can't read the rate limits: %w", err)
+	}
+
+	cfg := RateLimitConfig{}
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return RateLimitConfig{}, fmt.Errorf("can't read the rate limits: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Usage is what a client used in a day.
+type Usage struct {
+	Renders int `json:"renders"`
+	Pages   int `json:"pages"`
+}
+
+// QuotaStore keeps the daily usage of the clients.
+type QuotaStore interface {
+	Get(key, day string) (Usage, error)
+	// Add adds the renders and pages to the usage of the day. The usage of
+	// the previous days can be removed.
+	Add(key, day string, renders, pages int) error
+}
+
+// RateLimiter limits the requests of each client with a token bucket and
+// its daily quotas. The anonymous requests are limited by their IP. A nil
+// *RateLimiter doesn't limit.
+type RateLimiter struct {
+	cfg     RateLimitConfig
+	quotas  QuotaStore
+	metrics *Metrics
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	// quotaMu keeps the check and the addition of a render together.
+	quotaMu sync.Mutex
+}
+
+// NewRateLimiter keeps the quotas in the store, without it they are kept in
+// memory.
+func NewRateLimiter(cfg RateLimitConfig, quotas QuotaStore, metrics *Metrics) *RateLimiter {
+	if quotas == nil {
+		quotas = NewMemoryQuotaStore()
+	}
+
+	return &RateLimiter{
+		cfg:       cfg,
+		quotas:    quotas,
+		metrics:   metrics,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Limits returns the limits of the client.
+func (r *RateLimiter) Limits(client string) Limits {
+	if limits, ok := r.cfg.Clients[client]; ok {
+		return limits
+	}
+
+	return r.cfg.Default
+}
+
+// RateLimit rejects the requests over the limits of the client with 429, it
+// must be after the authentication. Every response has the RateLimit headers
+// of the token bucket, a rejection by the quota has the ones of the quota.
+// The render of a request rejected with 4xx, or that doesn't render a
+// document, isn't counted in the quota.
+func RateLimit(limiter *RateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if limiter == nil {
+				return next(c)
+			}
+
+			req := c.Request()
+			key, name := "ip:"+c.RealIP(), ""
+			if client, ok := ClientFromContext(req.Context()); ok {
+				key, name = "client:"+client.Name, client.Name
+			}
+			limits := limiter.Limits(name)
+			header := c.Response().Header()
+
+			allowed, remaining, reset := limiter.take(key, limits, time.Now())
+			if limits.Rate > 0 {
+				setRateLimitHeaders(header, max(limits.Burst, 1), remaining, reset)
+			}
+			if !allowed {
+				limiter.metrics.ObserveRateLimited(req.Context(), "rate")
+				header.Set(echo.HeaderRetryAfter, seconds(reset))
+				return errorResponse(c, http.StatusTooManyRequests, "can't accept the request", ErrRateLimited)
+			}
+
+			limit, reset, err := limiter.reserve(key, limits, time.Now())
+			if errors.Is(err, ErrQuotaExceeded) {
+				limiter.metrics.ObserveRateLimited(req.Context(), "quota")
+				setRateLimitHeaders(header, limit, 0, reset)
+				header.Set(echo.HeaderRetryAfter, seconds(reset))
+				return errorResponse(c, http.StatusTooManyRequests, "can't accept the request", err)
+			}
+			if err != nil {
+				return errorResponse(c, http.StatusInternalServerError, "can't check the quota", err)
+			}
+
+			quota := &quotaRequest{key: key, reserved: limits.DailyRenders > 0 || limits.DailyPages > 0, day: quotaDay(time.Now())}
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), quotaKey{}, quota)))
+
+			err = next(c)
+			// The requests rejected by their input don't use the quota.
+			if status := c.Response().Status; status >= 400 && status < 500 {
+				quota.noRender = true
+			}
+			limiter.refund(c.Request().Context(), quota)
+
+			return err
+		}
+	}
+}
+
+// AddPages adds the pages of a PDF to the quota of the client of the
+// context, the password opens an encrypted PDF. The pages are only known
+// after the render, so the render that exceeds the quota still finishes.
+func (r *RateLimiter) AddPages(ctx context.Context, pdf []byte, password string) {
+	if r == nil || len(pdf) == 0 {
+		return
+	}
+
+	key := quotaKeyFromContext(ctx)
+	if key == "" {
+		return
+	}
+
+	pages, err := pageCount(pdf, password)
+	if err != nil {
+		LoggerFromContext(ctx).Warn("can't count the pages of the quota", "error", err)
+		return
+	}
+
+	err = r.quotas.Add(key, quotaDay(time.Now()), 0, pages)
+	if err != nil {
+		LoggerFromContext(ctx).Error("can't save the pages of the quota", "error", err)
+	}
+}
+
+// refund removes the render reserved by the request when it didn't render a
+// document.
+func (r *RateLimiter) refund(ctx context.Context, quota *quotaRequest) {
+	if !quota.reserved || !quota.noRender || quota.day != quotaDay(time.Now()) {
+		return
+	}
+
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+
+	err := r.quotas.Add(quota.key, quota.day, -1, 0)
+	if err != nil {
+		LoggerFromContext(ctx).Error("can't refund the render of the quota", "error", err)
+	}
+}
+
+// take removes a token of the bucket of the key. It returns the remaining
+// tokens and how long until the bucket is full, or until there is a token
+// when it's empty.
+func (r *RateLimiter) take(key string, limits Limits, now time.Time) (bool, int, time.Duration) {
+	if limits.Rate <= 0 {
+		return true, 0, 0
+	}
+	burst := max(limits.Burst, 1)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastSweep) > bucketIdle {
+		for k, b := range r.buckets {
+			if now.Sub(b.updatedAt) > bucketIdle {
+				delete(r.buckets, k)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), updatedAt: now}
+		r.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updatedAt).Seconds()*limits.Rate)
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		return false, 0, secondsDuration((1 - b.tokens) / limits.Rate)
+	}
+	b.tokens--
+
+	return true, int(b.tokens), secondsDuration((float64(burst) - b.tokens) / limits.Rate)
+}
+
+// reserve counts a render in the quota of the key. It returns the exceeded
+// limit and the time until the next day when the quota is exceeded.
+func (r *RateLimiter) reserve(key string, limits Limits, now time.Time) (int, time.Duration, error) {
+	if limits.DailyRenders <= 0 && limits.DailyPages <= 0 {
+		return 0, 0, nil
+	}
+
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+
+	day := quotaDay(now)
+	usage, err := r.quotas.Get(key, day)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't get the usage: %w", err)
+	}
+
+	reset := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+	if limits.DailyRenders > 0 && usage.Renders >= limits.DailyRenders {
+		return limits.DailyRenders, reset, ErrQuotaExceeded
+	}
+	if limits.DailyPages > 0 && usage.Pages >= limits.DailyPages {
+		return limits.DailyPages, reset, ErrQuotaExceeded
+	}
+
+	err = r.quotas.Add(key, day, 1, 0)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't save the usage: %w", err)
+	}
+
+	return 0, 0, nil
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func setRateLimitHeaders(header http.Header, limit, remaining int, reset time.Duration) {
+	header.Set(HeaderRateLimitLimit, strconv.Itoa(limit))
+	header.Set(HeaderRateLimitRemaining, strconv.Itoa(remaining))
+	header.Set(HeaderRateLimitReset, seconds(reset))
+}
+
+// seconds rounds up the duration to whole seconds for the headers.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func quotaDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+type quotaKey struct{}
+
+// quotaRequest is the quota of a request. The render reserved by the
+// RateLimit middleware is refunded when the request doesn't render a
+// document.
+type quotaRequest struct {
+	key      string
+	day      string
+	reserved bool
+	noRender bool
+}
+
+// contextWithQuotaKey returns a context with the key of the quota of the
+// request, for the pages of its render.
+func contextWithQuotaKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, quotaKey{}, &quotaRequest{key: key})
+}
+
+func quotaKeyFromContext(ctx context.Context) string {
+	quota, _ := ctx.Value(quotaKey{}).(*quotaRequest)
+	if quota == nil {
+		return ""
+	}
+
+	return quota.key
+}
+
+// withoutRender marks the request of the context as one that didn't render
+// a document, so its render is refunded.
+func withoutRender(ctx context.Context) {
+	if quota, _ := ctx.Value(quotaKey{}).(*quotaRequest); quota != nil {
+		quota.noRender = true
+	}
+}
+
+// IPExtractor returns the IP of the requests for the limits of the anonymous
+// clients. It's the address of the connection, or the one in the
+// X-Forwarded-For header when the connection is from a trusted proxy. The
+// headers of the other clients are ignored, they could change them to get
+// new limits.
+func IPExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range trustedProxies {
+		options = append(options, echo.TrustIPRange(proxy))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// MemoryQuotaStore keeps the usage of the current day in memory, it's lost
+// on a restart.
+type MemoryQuotaStore struct {
+	mu    sync.Mutex
+	day   string
+	usage map[string]Usage
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{usage: map[string]Usage{}}
+}
+
+func (s *MemoryQuotaStore) Get(key, day string) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if day != s.day {
+		return Usage{}, nil
+	}
+
+	return s.usage[key], nil
+}
+
+func (s *MemoryQuotaStore) Add(key, day string, renders, pages int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if day != s.day {
+		s.day = day
+		s.usage = map[string]Usage{}
+	}
+	usage := s.usage[key]
+	usage.Renders += renders
+	usage.Pages += pages
+	s.usage[key] = usage
+
+	return nil
+}
+
+var bucketQuotas = []byte("quotas")
+
+// BoltQuotaStore keeps the usage in a bbolt database, so the quotas survive
+// a restart. The keys are the day and the client, the previous days are
+// removed when a new day starts.
+type BoltQuotaStore struct {
+	db *bolt.DB
+
+	mu  sync.Mutex
+	day string
+}
+
+// NewBoltQuotaStore opens or creates the store in the directory.
+func NewBoltQuotaStore(dir string) (*BoltQuotaStore, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the quota store directory: %w", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "quotas.db"), 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open the quota store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketQuotas)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("can't create the quotas bucket: %w", err)
+	}
+
+	return &BoltQuotaStore{db: db}, nil
+}
+
+func (s *BoltQuotaStore) Get(key, day string) (Usage, error) {
+	usage := Usage{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		usage = decodeUsage(tx.Bucket(bucketQuotas).Get([]byte(day + "/" + key)))
+		return nil
+	})
+
+	return usage, err
+}
+
+func (s *BoltQuotaStore) Add(key, day string, renders, pages int) error {
+	s.mu.Lock()
+	newDay := day != s.day
+	s.day = day
+	s.mu.Unlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketQuotas)
+		if newDay {
+			err := deleteOtherDays(bucket, day)
+			if err != nil {
+				return err
+			}
+		}
+
+		k := []byte(day + "/" + key)
+		usage := decodeUsage(bucket.Get(k))
+		usage.Renders += renders
+		usage.Pages += pages
+
+		data, err := json.Marshal(usage)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(k, data)
+	})
+}
+
+func (s *BoltQuotaStore) Close() error {
+	return s.db.Close()
+}
+
+func deleteOtherDays(bucket *bolt.Bucket, day string) error {
+	var keys [][]byte
+	prefix := []byte(day + "/")
+	err := bucket.ForEach(func(k, _ []byte) error {
+		if !bytes.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		err = bucket.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeUsage reads a saved usage, a missing or damaged one is empty.
+func decodeUsage(data []byte) Usage {
+	usage := Usage{}
+	_ = json.Unmarshal(data, &usage)
+
+	return usage
+}
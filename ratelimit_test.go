@@ -0,0 +1,239 @@
+package gohtmltopdf
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRateLimiter_take(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{}, nil, nil)
+	limits := Limits{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	for i, want := range []int{1, 0} {
+		ok, remaining, _ := limiter.take("a", limits, now)
+		if !ok || remaining != want {
+			t.Errorf("request %d: take() = %v, %d, want true, %d", i, ok, remaining, want)
+		}
+	}
+
+	ok, _, reset := limiter.take("a", limits, now)
+	if ok || reset != time.Second {
+		t.Errorf("take() = %v, %v, want false, 1s", ok, reset)
+	}
+	if ok, _, _ := limiter.take("b", limits, now); !ok {
+		t.Error("the buckets must be separated by key")
+	}
+
+	if ok, _, _ := limiter.take("a", limits, now.Add(time.Second)); !ok {
+		t.Error("the bucket must be refilled after a second")
+	}
+}
+
+func TestRateLimiter_reserve(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{}, nil, nil)
+	limits := Limits{DailyRenders: 2}
+	now := time.Date(2024, 5, 10, 23, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := limiter.reserve("a", limits, now); err != nil {
+			t.Fatalf("render %d: Got an unexpected error: %v", i, err)
+		}
+	}
+
+	limit, reset, err := limiter.reserve("a", limits, now)
+	if !errors.Is(err, ErrQuotaExceeded) || limit != 2 || reset != time.Hour {
+		t.Errorf("reserve() = %d, %v, %v, want 2, 1h, %v", limit, reset, err, ErrQuotaExceeded)
+	}
+
+	if _, _, err := limiter.reserve("a", limits, now.Add(time.Hour)); err != nil {
+		t.Errorf("the quota must be reset the next day: %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	auth, _ := NewAPIKeys([]APIKey{
+		{Name: "slow", Hash: HashAPIKey("slow-key"), Scopes: []string{ScopeAll}},
+		{Name: "fast", Hash: HashAPIKey("fast-key"), Scopes: []string{ScopeAll}},
+	})
+	limiter := NewRateLimiter(RateLimitConfig{
+		Default: Limits{Rate: 0.001, Burst: 1},
+		Clients: map[string]Limits{"fast": {Rate: 100, Burst: 100}},
+	}, nil, nil)
+
+	e := echo.New()
+	Router(e, RouterConfig{Auth: auth, Generator: NewGenerator(WithDefaultBackend(BackendMaroto)), Limiter: limiter})
+
+	render := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/html-to-pdf", strings.NewReader(`{"data": "<p>a</p>"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderAPIKey, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := render("slow-key")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if rec.Header().Get(HeaderRateLimitLimit) != "1" || rec.Header().Get(HeaderRateLimitRemaining) != "0" {
+		t.Errorf("RateLimit headers = %v", rec.Header())
+	}
+
+	rec = render("slow-key")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get(echo.HeaderRetryAfter) != "1000" {
+		t.Errorf("Retry-After = %q, want 1000", rec.Header().Get(echo.HeaderRetryAfter))
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := render("fast-key"); rec.Code != http.StatusOK {
+			t.Errorf("the other client must have its own limits, status = %d", rec.Code)
+		}
+	}
+}
+
+func TestRateLimit_quota(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Default: Limits{DailyPages: 1}}, nil, nil)
+
+	// Without authentication the limits are by IP.
+	e := echo.New()
+	Router(e, RouterConfig{Generator: NewGenerator(WithDefaultBackend(BackendMaroto)), Limiter: limiter})
+
+	render := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/html-to-pdf", strings.NewReader(`{"data": "<p>a</p>"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	if rec := render("10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	rec := render("10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(HeaderRateLimitLimit) != "1" || rec.Header().Get(echo.HeaderRetryAfter) == "" {
+		t.Errorf("status = %d, headers = %v, want the quota exceeded", rec.Code, rec.Header())
+	}
+	if rec := render("10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("another IP must have its own quota, status = %d", rec.Code)
+	}
+}
+
+func TestRateLimit_spoofedIP(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Default: Limits{Rate: 0.001, Burst: 1}}, nil, nil)
+	e := echo.New()
+	Router(e, RouterConfig{Generator: NewGenerator(WithDefaultBackend(BackendMaroto)), Limiter: limiter})
+
+	render := func(forwarded string) int {
+		req := httptest.NewRequest(http.MethodPost, "/html-to-pdf", strings.NewReader(`{"data": "<p>a</p>"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderXForwardedFor, forwarded)
+		req.Header.Set(echo.HeaderXRealIP, forwarded)
+		req.RemoteAddr = "203.0.113.7:1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	if code := render("10.0.0.1"); code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	// The client can't get a new bucket changing the headers.
+	if code := render("10.0.0.2"); code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestIPExtractor(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	extract := IPExtractor([]*net.IPNet{proxies})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.1, 203.0.113.7")
+	req.RemoteAddr = "10.0.0.5:1234"
+	if ip := extract(req); ip != "203.0.113.7" {
+		t.Errorf("ip = %q, the trusted proxy gives the IP of the client", ip)
+	}
+
+	req.RemoteAddr = "192.168.1.5:1234"
+	if ip := extract(req); ip != "192.168.1.5" {
+		t.Errorf("ip = %q, the headers of an untrusted address are ignored", ip)
+	}
+}
+
+func TestRateLimit_refund(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Default: Limits{DailyRenders: 1}}, nil, nil)
+	e := echo.New()
+	Router(e, RouterConfig{Generator: NewGenerator(WithDefaultBackend(BackendMaroto)), Limiter: limiter})
+
+	render := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/html-to-pdf", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	// The invalid requests and the inspections of uploads don't use the
+	// quota.
+	if code := render(`{"data": "<p>a</p>", "options": {"backend": "chrome"}}`); code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", code, http.StatusBadRequest)
+	}
+	rec := doPDF(e, "/pdf/inspect", []testPart{{name: "pdf", data: string(testPages(t, "one", "two"))}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if usage, _ := limiter.quotas.Get("ip:192.0.2.1", quotaDay(time.Now())); usage != (Usage{}) {
+		t.Errorf("usage = %+v, want nothing used", usage)
+	}
+
+	if code := render(`{"data": "<p>a</p>"}`); code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	if code := render(`{"data": "<p>a</p>"}`); code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestBoltQuotaStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBoltQuotaStore(dir)
+	if err != nil {
+		t.Fatalf("Got an unexpected error opening the store: %v", err)
+	}
+	_ = store.Add("client:a", "2024-05-10", 1, 3)
+	_ = store.Add("client:a", "2024-05-10", 1, 2)
+	_ = store.Close()
+
+	store, err = NewBoltQuotaStore(dir)
+	if err != nil {
+		t.Fatalf("Got an unexpected error opening the store again: %v", err)
+	}
+	defer store.Close()
+
+	usage, err := store.Get("client:a", "2024-05-10")
+	if err != nil || usage != (Usage{Renders: 2, Pages: 5}) {
+		t.Errorf("Get() = %+v, %v, want the usage saved before the restart", usage, err)
+	}
+
+	_ = store.Add("client:a", "2024-05-11", 1, 0)
+	if usage, _ := store.Get("client:a", "2024-05-10"); usage != (Usage{}) {
+		t.Errorf("the previous days must be removed, got %+v", usage)
+	}
+}
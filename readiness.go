@@ -0,0 +1,225 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+
+	// ReadinessDIAN is the name of the DIAN form check in the readiness report.
+	ReadinessDIAN = "dian-form-220"
+
+	DefaultReadinessTimeout = 5 * time.Second
+	DefaultReadinessTTL     = 5 * time.Second
+)
+
+// readinessHTML is the document rendered by every backend on the readiness check.
+const readinessHTML = `<html><head><title>ready</title></head><body><h1>Ready</h1><p>Smoke test</p></body></html>`
+
+// readinessDIAN is the one page form rendered on the readiness check.
+var readinessDIAN = DIANForms220Relation{
+	{
+		DIANForm220: DIANForm220{
+			Year:     2022,
+			Sequence: 1,
+			Records:  json.RawMessage(`{}`),
+		},
+		Nit:                  "900000000",
+		Dv:                   "1",
+		BusinessName:         "Readiness",
+		IdentificationNumber: "0",
+		FirstName:            "Readiness",
+	},
+}
+
+// BackendStatus is the result of the smoke render of a backend.
+type BackendStatus struct {
+	Status     string    `json:"status"`
+	Version    string    `json:"version,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+	// LastError is kept after the backend recovers, to know why it failed.
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// ReadinessReport is the response of the readiness endpoint. The service is
+// ready when the default backend and the DIAN form can render.
+type ReadinessReport struct {
+	Ready     bool                     `json:"ready"`
+	CheckedAt time.Time                `json:"checked_at"`
+	Backends  map[string]BackendStatus `json:"backends"`
+}
+
+// Readiness performs a real render with every backend and the DIAN form, the
+// result is cached for the ttl so the probes don't overload the service. The
+// renders take a slot of the pool like the requests.
+type Readiness struct {
+	generator *Generator
+	pool      *Pool
+	timeout   time.Duration
+	ttl       time.Duration
+
+	mu     sync.Mutex
+	report ReadinessReport
+	// running is closed when the check in progress finishes.
+	running chan struct{}
+}
+
+func NewReadiness(generator *Generator, pool *Pool, timeout, ttl time.Duration) *Readiness {
+	return &Readiness{
+		generator: generator,
+		pool:      pool,
+		timeout:   timeout,
+		ttl:       ttl,
+		report:    ReadinessReport{Backends: map[string]BackendStatus{}},
+	}
+}
+
+// Check returns the cached report or runs the smoke renders if it expired.
+// Only one check runs at a time, the probes that arrive meanwhile get the
+// previous report instead of waiting for it.
+func (r *Readiness) Check(ctx context.Context) ReadinessReport {
+	r.mu.Lock()
+	report := r.report
+	if time.Since(report.CheckedAt) < r.ttl {
+		r.mu.Unlock()
+		return report
+	}
+	running := r.running
+	wait := running == nil || report.CheckedAt.IsZero()
+	if running == nil {
+		running = make(chan struct{})
+		r.running = running
+		// The check isn't canceled when the probe that started it goes away,
+		// the other probes use its result.
+		go r.run(context.WithoutCancel(ctx), running)
+	}
+	r.mu.Unlock()
+
+	if !wait {
+		return report
+	}
+	select {
+	case <-running:
+	case <-ctx.Done():
+		return report
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.report
+}
+
+// run performs the smoke renders and caches the report.
+func (r *Readiness) run(ctx context.Context, running chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	r.mu.Lock()
+	previous := r.report.Backends
+	r.mu.Unlock()
+
+	checks := map[string]func(context.Context) error{
+		ReadinessDIAN: func(ctx context.Context) error {
+			_, err := NewDIAN(false).CreateDIANForm220(ctx, readinessDIAN)
+			return err
+		},
+	}
+	for _, name := range r.generator.Backends() {
+		backend, _ := r.generator.Backend(name)
+		checks[name] = func(ctx context.Context) error {
+			_, err := backend.Render(ctx, strings.NewReader(readinessHTML), RenderOptions{})
+			return err
+		}
+	}
+
+	capabilities := r.generator.Capabilities()
+
+	var wg sync.WaitGroup
+	var resultsMu sync.Mutex
+	backends := make(map[string]BackendStatus, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+
+			status := r.check(ctx, previous[name], check)
+			status.Version = capabilities[name].Version
+			if name == ReadinessDIAN {
+				status.Version = capabilities[BackendMaroto].Version
+			}
+
+			resultsMu.Lock()
+			backends[name] = status
+			resultsMu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	defaultBackend, _ := r.generator.Backend("")
+	r.mu.Lock()
+	r.report = ReadinessReport{
+		Ready:     backends[defaultBackend.Name()].Status == StatusOK && backends[ReadinessDIAN].Status == StatusOK,
+		CheckedAt: time.Now(),
+		Backends:  backends,
+	}
+	r.running = nil
+	r.mu.Unlock()
+	close(running)
+}
+
+// check runs one smoke render in the pool, keeping the last error of the
+// previous status. The backends receive the context, wkhtmltopdf is killed
+// when it's done.
+func (r *Readiness) check(ctx context.Context, previous BackendStatus, check func(context.Context) error) BackendStatus {
+	status := BackendStatus{
+		Status:      StatusOK,
+		LastError:   previous.LastError,
+		LastErrorAt: previous.LastErrorAt,
+	}
+
+	start := time.Now()
+	err := r.pool.Do(ctx, func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return check(ctx)
+	})
+	status.CheckedAt = time.Now()
+	status.DurationMS = status.CheckedAt.Sub(start).Milliseconds()
+	if err != nil {
+		status.Status = StatusError
+		status.LastError = err.Error()
+		status.LastErrorAt = &status.CheckedAt
+	}
+
+	return status
+}
+
+// moduleVersion returns the version of a dependency of the binary.
+func moduleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			return dep.Version
+		}
+	}
+
+	return ""
+}
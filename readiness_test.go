@@ -0,0 +1,115 @@
+package gohtmltopdf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadiness_Check(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name           string
+		defaultBackend string
+		wantReady      bool
+	}{
+		{"default backend available", BackendMaroto, true},
+		{"default backend unavailable", BackendWKHTMLToPDF, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := NewGenerator(WithBinaryPath(missing), WithDefaultBackend(tt.defaultBackend))
+			gen.DetectCapabilities(context.Background())
+
+			report := NewReadiness(gen, nil, DefaultReadinessTimeout, time.Minute).Check(context.Background())
+			if report.Ready != tt.wantReady {
+				t.Errorf("Ready = %t, want %t: %+v", report.Ready, tt.wantReady, report.Backends)
+			}
+
+			for name, wantStatus := range map[string]string{BackendMaroto: StatusOK, ReadinessDIAN: StatusOK, BackendWKHTMLToPDF: StatusError} {
+				status := report.Backends[name]
+				if status.Status != wantStatus {
+					t.Errorf("status of %s = %q, want %q (error: %s)", name, status.Status, wantStatus, status.LastError)
+				}
+			}
+			if report.Backends[BackendWKHTMLToPDF].LastError == "" {
+				t.Errorf("the failed backend must have the last error")
+			}
+		})
+	}
+}
+
+func TestReadiness_Check_cache(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	readiness := NewReadiness(gen, nil, DefaultReadinessTimeout, time.Minute)
+
+	first := readiness.Check(context.Background())
+	second := readiness.Check(context.Background())
+	if !first.CheckedAt.Equal(second.CheckedAt) {
+		t.Errorf("the second check must use the cached report")
+	}
+
+	readiness.ttl = 0
+	third := readiness.Check(context.Background())
+	if third.CheckedAt.Equal(first.CheckedAt) {
+		t.Errorf("an expired report must be checked again")
+	}
+}
+
+func TestReadiness_Check_slow(t *testing.T) {
+	// The fake wkhtmltopdf never finishes, it's killed when the check times out.
+	path := filepath.Join(t.TempDir(), "wkhtmltopdf")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexec sleep 30\n"), 0o700); err != nil {
+		t.Fatalf("Got an unexpected error writing the fake executable: %v", err)
+	}
+	gen := NewGenerator(WithBinaryPath(path), WithDefaultBackend(BackendMaroto))
+	readiness := NewReadiness(gen, nil, 200*time.Millisecond, 0)
+
+	start := time.Now()
+	first := readiness.Check(context.Background())
+	if time.Since(start) > 5*time.Second || first.Backends[BackendWKHTMLToPDF].Status != StatusError {
+		t.Fatalf("the check must time out: %+v", first.Backends[BackendWKHTMLToPDF])
+	}
+
+	// The probes that arrive while a check runs get the previous report.
+	done := make(chan ReadinessReport)
+	go func() { done <- readiness.Check(context.Background()) }()
+	for running := false; !running; {
+		readiness.mu.Lock()
+		running = readiness.running != nil
+		readiness.mu.Unlock()
+	}
+	start = time.Now()
+	second := readiness.Check(context.Background())
+	if !second.CheckedAt.Equal(first.CheckedAt) || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("the probe must not wait for the running check")
+	}
+	if third := <-done; !third.CheckedAt.After(first.CheckedAt) {
+		t.Errorf("the probe that started the check must get its report")
+	}
+}
+
+func TestReadiness_Check_pool(t *testing.T) {
+	pool := NewPool(1)
+	release := make(chan struct{})
+	busy := make(chan struct{})
+	go func() {
+		_ = pool.Do(context.Background(), func() error {
+			close(busy)
+			<-release
+			return nil
+		})
+	}()
+	defer close(release)
+	<-busy
+
+	// The renders of the check wait for a free slot like the requests.
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	report := NewReadiness(gen, pool, 100*time.Millisecond, time.Minute).Check(context.Background())
+	if report.Ready || report.Backends[BackendMaroto].LastError != context.DeadlineExceeded.Error() {
+		t.Errorf("the check must wait for the pool: %+v", report)
+	}
+}
@@ -1,10 +1,88 @@
 package gohtmltopdf
 
-import "github.com/labstack/echo/v4"
+import (
+	"log/slog"
+	"net"
 
-func Router(e *echo.Echo, internalCode string) {
-	handler := NewHandler()
+	"github.com/labstack/echo/v4"
+)
+
+// RouterConfig are the dependencies of the routes. Only the Generator is
+// required.
+type RouterConfig struct {
+	// Auth authenticates the renders and jobs, without it they are public.
+	Auth      Authenticator
+	Generator *Generator
+	Metrics   *Metrics
+	Pool      *Pool
+	// Jobs must be started by the caller, without it the routes of the jobs
+	// aren't registered.
+	Jobs *Jobs
+	// Results enables the `store` option of the renders.
+	Results *Results
+	Cache   *Cache
+	// Limiter limits the renders and job creations of each client, it must
+	// also be in the JobsConfig to count the pages of the jobs.
+	Limiter *RateLimiter
+	// Signers sign the DIAN forms, they must also be in the Generator and the
+	// JobsConfig to sign the HTML renders and the jobs.
+	Signers *Signers
+	// TrustedProxies are the networks of the proxies whose X-Forwarded-For
+	// header has the IP of the client, used when the Echo doesn't have an
+	// IPExtractor.
+	TrustedProxies []*net.IPNet
+}
+
+func Router(e *echo.Echo, cfg RouterConfig) {
+	if e.IPExtractor == nil {
+		e.IPExtractor = IPExtractor(cfg.TrustedProxies)
+	}
+	e.Use(RequestLogger(slog.Default()), Tracing())
+
+	gen := cfg.Generator
+	handler := NewHandler(gen, HandlerConfig{
+		Readiness: NewReadiness(gen, cfg.Pool, DefaultReadinessTimeout, DefaultReadinessTTL),
+		Metrics:   cfg.Metrics,
+		Pool:      cfg.Pool,
+		Jobs:      cfg.Jobs,
+		Results:   cfg.Results,
+		Cache:     cfg.Cache,
+		Limiter:   cfg.Limiter,
+		Signers:   cfg.Signers,
+	})
 	e.GET("/health", handler.Health)
-	e.POST("/html-to-pdf", handler.ValidateInternalCode(handler.CreateHTMLToPDF, internalCode))
-	e.POST("/dian-form-220", handler.ValidateInternalCode(handler.CreateDianForm220, internalCode))
+	e.GET("/ready", handler.Ready)
+	if cfg.Metrics != nil {
+		e.GET("/metrics", echo.WrapHandler(cfg.Metrics.Handler()))
+	}
+	e.POST("/html-to-pdf", handler.CreateHTMLToPDF, RequireScope(cfg.Auth, ScopeHTMLRender), RateLimit(cfg.Limiter))
+	e.POST("/dian-form-220", handler.CreateDianForm220, RequireScope(cfg.Auth, ScopeDIANRender), RateLimit(cfg.Limiter))
+	// The scopes of the renders of the documents are checked by the handler.
+	pdf := e.Group("/pdf", RequireScope(cfg.Auth, ScopePDFProcess), RateLimit(cfg.Limiter))
+	pdf.POST("/merge", handler.MergePDF)
+	pdf.POST("/split", handler.SplitPDF)
+	pdf.POST("/extract", handler.ExtractPDFPages)
+	pdf.POST("/rotate", handler.RotatePDFPages)
+	pdf.POST("/reorder", handler.ReorderPDFPages)
+	pdf.POST("/verify", handler.VerifyPDFSignatures)
+	pdf.POST("/optimize", handler.OptimizePDF)
+	pdf.POST("/form/fields", handler.ListPDFFormFields)
+	pdf.GET("/form/:key/fields", handler.GetPDFFormFields)
+	pdf.POST("/form/fill", handler.FillPDFForm)
+	pdf.POST("/inspect", handler.InspectPDF)
+
+	if cfg.Jobs != nil {
+		// The scope of a job depends on its type, it's checked by the handler.
+		e.POST("/jobs", handler.CreateJob, RequireScope(cfg.Auth, ""), RateLimit(cfg.Limiter))
+		e.GET("/jobs/:id", handler.GetJob, RequireScope(cfg.Auth, ""))
+		e.GET("/jobs/:id/result", handler.GetJobResult, RequireScope(cfg.Auth, ""))
+	}
+
+	// The download URLs of the local storage are signed, they don't need the
+	// internal code.
+	if cfg.Results != nil {
+		if _, ok := cfg.Results.storage.(*LocalStorage); ok {
+			e.GET("/results/:key", handler.DownloadResult)
+		}
+	}
 }
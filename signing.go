@@ -0,0 +1,719 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/digitorus/timestamp"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// DefaultTSATimeout is the timeout of the requests to the TSA.
+const DefaultTSATimeout = 30 * time.Second
+
+const (
+	// signatureSize is the space reserved in the PDF for the CMS, with the
+	// certificates and the timestamp.
+	signatureSize = 16384
+	// byteRangePlaceholder is replaced by the ByteRange, padded with spaces.
+	byteRangePlaceholder = "[0 0000000000 0000000000 0000000000]"
+	// appearanceScale are the pixels per point of the image of the visible
+	// signatures.
+	appearanceScale = 4
+)
+
+var (
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidTimestampToken       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+)
+
+// dianSignatureRect is the area under "Firma del Trabajador o Pensionado"
+// of the DIAN form 220.
+var dianSignatureRect = []float64{447, 73, 583, 131}
+
+// Signature signs the PDF with the certificate of the client configured in
+// the server. It's a PAdES signature, a detached CMS added as an
+// incremental update, so the signed bytes, like the ones of a PDF/A, don't
+// change.
+type Signature struct {
+	Reason      string `json:"reason,omitempty"`
+	Location    string `json:"location,omitempty"`
+	ContactInfo string `json:"contact_info,omitempty"`
+	// Invisible signs without an appearance in the page.
+	Invisible bool `json:"invisible,omitempty"`
+	// Page of the appearance, the last one by default.
+	Page int `json:"page,omitempty"`
+	// Rect is the box of the appearance in points from the bottom-left
+	// corner of the page: x1, y1, x2, y2. By default, it's the Firma area of
+	// the DIAN forms and the bottom-right corner of the other documents.
+	Rect []float64 `json:"rect,omitempty"`
+}
+
+// Validate checks the page and the box of the appearance.
+func (s Signature) Validate() error {
+	if s.Page < 0 {
+		return ErrorProcess{Msg: "the signature page can't be negative"}
+	}
+	if len(s.Rect) > 0 && (len(s.Rect) != 4 || s.Rect[2] <= s.Rect[0] || s.Rect[3] <= s.Rect[1]) {
+		return ErrorProcess{Msg: "the signature rect must be x1, y1, x2, y2 with x2 > x1 and y2 > y1"}
+	}
+
+	return nil
+}
+
+// validateSignature checks the signature of a request. The signed documents
+// can't be encrypted, the encryption would change the signed bytes.
+func validateSignature(signature *Signature, encryption *Encryption) error {
+	if signature == nil {
+		return nil
+	}
+	if encryption != nil {
+		return ErrorProcess{Msg: "the signed documents can't be encrypted"}
+	}
+
+	return signature.Validate()
+}
+
+// Signer has the certificate and the private key of a PKCS#12 keystore.
+// With a TSA URL, the signatures have an RFC 3161 timestamp.
+type Signer struct {
+	key    crypto.Signer
+	cert   *x509.Certificate
+	chain  []*x509.Certificate
+	tsaURL string
+	client *http.Client
+}
+
+// NewSigner reads a PKCS#12 keystore with its password.
+func NewSigner(keystore []byte, password, tsaURL string) (*Signer, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(keystore, password)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the keystore: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("the key of the keystore can't sign")
+	}
+
+	return &Signer{
+		key:    signer,
+		cert:   cert,
+		chain:  chain,
+		tsaURL: tsaURL,
+		client: &http.Client{Timeout: DefaultTSATimeout},
+	}, nil
+}
+
+// Certificate returns the certificate of the signatures.
+func (s *Signer) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// SigningKeystore is the keystore of a client in the keystores file, the
+// one without a Client is the default.
+type SigningKeystore struct {
+	Client   string `json:"client,omitempty"`
+	Path     string `json:"path"`
+	Password string `json:"password"`
+	// TSAURL replaces the TSA of the server for this keystore.
+	TSAURL string `json:"tsa_url,omitempty"`
+}
+
+// LoadSigningKeystores reads a JSON file with a list of SigningKeystore.
+func LoadSigningKeystores(path string) ([]SigningKeystore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the signing keystores: %w", err)
+	}
+
+	var keystores []SigningKeystore
+	err = json.Unmarshal(data, &keystores)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the signing keystores: %w", err)
+	}
+
+	return keystores, nil
+}
+
+// Signers are the signers of the clients, the clients without a keystore
+// use the default one.
+type Signers struct {
+	def     *Signer
+	clients map[string]*Signer
+}
+
+// NewSigners returns the signers, the default can be nil so only the
+// clients with a keystore can sign.
+func NewSigners(def *Signer, clients map[string]*Signer) *Signers {
+	return &Signers{def: def, clients: clients}
+}
+
+// LoadSigners reads the keystores, the ones without a TSA URL use the
+// tsaURL.
+func LoadSigners(keystores []SigningKeystore, tsaURL string) (*Signers, error) {
+	signers := NewSigners(nil, map[string]*Signer{})
+	for _, keystore := range keystores {
+		data, err := os.ReadFile(keystore.Path)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the keystore of %q: %w", keystore.Client, err)
+		}
+		if keystore.TSAURL == "" {
+			keystore.TSAURL = tsaURL
+		}
+		signer, err := NewSigner(data, keystore.Password, keystore.TSAURL)
+		if err != nil {
+			return nil, fmt.Errorf("client %q: %w", keystore.Client, err)
+		}
+
+		if keystore.Client == "" {
+			if signers.def != nil {
+				return nil, fmt.Errorf("there can only be a default keystore")
+			}
+			signers.def = signer
+			continue
+		}
+		if signers.clients[keystore.Client] != nil {
+			return nil, fmt.Errorf("the client %q has several keystores", keystore.Client)
+		}
+		signers.clients[keystore.Client] = signer
+	}
+
+	return signers, nil
+}
+
+// signer returns the signer of the client of the context.
+func (s *Signers) signer(ctx context.Context) (*Signer, error) {
+	if s != nil {
+		if client, ok := ClientFromContext(ctx); ok && s.clients[client.Name] != nil {
+			return s.clients[client.Name], nil
+		}
+		if s.def != nil {
+			return s.def, nil
+		}
+	}
+
+	return nil, ErrorProcess{Msg: "there isn't a signing certificate for the client"}
+}
+
+// Sign signs the PDF with the signer of the client of the context.
+func (s *Signers) Sign(ctx context.Context, pdf []byte, signature Signature) ([]byte, error) {
+	signer, err := s.signer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignPDF(ctx, pdf, signer, signature)
+}
+
+// SignPDF adds a PAdES signature to the PDF, with the appearance in the
+// page unless it's Invisible. The PDF can't be encrypted.
+func SignPDF(ctx context.Context, pdf []byte, signer *Signer, signature Signature) (out []byte, err error) {
+	ctx, span := tracer().Start(ctx, "sign")
+	defer func() { endSpan(span, err) }()
+
+	err = signature.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pdfCtx, err := api.ReadContext(bytes.NewReader(pdf), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or it's encrypted: %v", err)}
+	}
+	if pdfCtx.Encrypt != nil {
+		return nil, ErrorProcess{Msg: "the signed documents can't be encrypted"}
+	}
+	err = pdfCtx.EnsurePageCount()
+	if err != nil {
+		return nil, err
+	}
+	page := signature.Page
+	if page == 0 {
+		page = pdfCtx.PageCount
+	}
+	if page > pdfCtx.PageCount {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("the document doesn't have the page %d", page)}
+	}
+
+	prev, err := lastStartXRef(pdf)
+	if err != nil {
+		return nil, err
+	}
+	update := newIncrementalUpdate(pdf, pdfCtx)
+	now := time.Now()
+
+	sigNumber := update.newObject()
+	fieldNumber := update.newObject()
+	field := types.Dict{
+		"Type":    types.Name("Annot"),
+		"Subtype": types.Name("Widget"),
+		"FT":      types.Name("Sig"),
+		"T":       types.StringLiteral(fmt.Sprintf("Signature%d", fieldNumber)),
+		"V":       *types.NewIndirectRef(sigNumber, 0),
+		// Print and locked.
+		"F":    types.Integer(132),
+		"Rect": types.NewNumberArray(0, 0, 0, 0),
+	}
+
+	pageDict, pageRef, _, err := pdfCtx.PageDict(page, false)
+	if err != nil {
+		return nil, err
+	}
+	field["P"] = *pageRef
+	if !signature.Invisible {
+		rect := signature.Rect
+		if len(rect) == 0 {
+			dims, err := pdfCtx.PageDims()
+			if err != nil {
+				return nil, err
+			}
+			width := dims[page-1].Width
+			rect = []float64{width - 236, 36, width - 36, 96}
+		}
+		field["Rect"] = types.NewNumberArray(rect...)
+
+		appearance, err := update.writeAppearance(rect[2]-rect[0], rect[3]-rect[1], signer.appearanceLines(signature, now))
+		if err != nil {
+			return nil, err
+		}
+		field["AP"] = types.Dict{"N": *types.NewIndirectRef(appearance, 0)}
+	}
+
+	annots, err := pdfCtx.DereferenceArray(pageDict["Annots"])
+	if err != nil {
+		return nil, err
+	}
+	pageDict.Update("Annots", append(append(types.Array{}, annots...), *types.NewIndirectRef(fieldNumber, 0)))
+
+	catalog, err := pdfCtx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	form := types.NewDict()
+	if existing, err := pdfCtx.DereferenceDict(catalog["AcroForm"]); err == nil && existing != nil {
+		for k, v := range existing {
+			form[k] = v
+		}
+	}
+	fields, err := pdfCtx.DereferenceArray(form["Fields"])
+	if err != nil {
+		return nil, err
+	}
+	form["Fields"] = append(append(types.Array{}, fields...), *types.NewIndirectRef(fieldNumber, 0))
+	// The document has signatures and it's only appended.
+	form["SigFlags"] = types.Integer(3)
+	catalog.Update("AcroForm", form)
+
+	sig, err := signer.signatureDict(signature, now)
+	if err != nil {
+		return nil, err
+	}
+	sigOffset := update.write(sigNumber, sig)
+	update.write(fieldNumber, field.PDFString())
+	update.write(pageRef.ObjectNumber.Value(), pageDict.PDFString())
+	update.write(pdfCtx.Root.ObjectNumber.Value(), catalog.PDFString())
+
+	out, err = update.finish(prev)
+	if err != nil {
+		return nil, err
+	}
+
+	byteRangeStart := sigOffset + strings.Index(sig, byteRangePlaceholder)
+	contentsStart := sigOffset + strings.Index(sig, "/Contents <") + len("/Contents ")
+	contentsEnd := contentsStart + 2*signatureSize + 2
+	byteRange := fmt.Sprintf("[0 %d %d %d]", contentsStart, contentsEnd, len(out)-contentsEnd)
+	copy(out[byteRangeStart:], fmt.Sprintf("%-*s", len(byteRangePlaceholder), byteRange))
+
+	signed := make([]byte, 0, len(out)-(contentsEnd-contentsStart))
+	signed = append(append(signed, out[:contentsStart]...), out[contentsEnd:]...)
+	cms, err := signer.sign(ctx, signed)
+	if err != nil {
+		return nil, err
+	}
+	if len(cms) > signatureSize {
+		return nil, fmt.Errorf("the signature has %d bytes, only %d fit in the PDF", len(cms), signatureSize)
+	}
+	hex.Encode(out[contentsStart+1:], cms)
+
+	return out, nil
+}
+
+// signatureDict returns the signature dictionary with the placeholders of
+// the ByteRange and the Contents.
+func (s *Signer) signatureDict(signature Signature, date time.Time) (string, error) {
+	b := strings.Builder{}
+	b.WriteString("<</Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached")
+	b.WriteString(" /ByteRange " + byteRangePlaceholder)
+	b.WriteString(" /Contents <" + strings.Repeat("0", 2*signatureSize) + ">")
+	b.WriteString(" /M " + types.StringLiteral(types.DateString(date)).PDFString())
+
+	entries := []struct{ name, value string }{
+		{"Name", s.cert.Subject.CommonName},
+		{"Reason", signature.Reason},
+		{"Location", signature.Location},
+		{"ContactInfo", signature.ContactInfo},
+	}
+	for _, entry := range entries {
+		if entry.value == "" {
+			continue
+		}
+		text, err := encodeText(entry.value)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(" /" + entry.name + " " + text.PDFString())
+	}
+	b.WriteString(">>")
+
+	return b.String(), nil
+}
+
+// sign returns the detached CMS of the signed bytes, with the
+// signing-certificate-v2 attribute of CAdES and the timestamp of the TSA.
+func (s *Signer) sign(ctx context.Context, signed []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(signed)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	certHash := sha256.Sum256(s.cert.Raw)
+	signingCertificate := struct {
+		Certs []struct{ CertHash []byte }
+	}{Certs: []struct{ CertHash []byte }{{CertHash: certHash[:]}}}
+	err = sd.AddSignerChain(s.cert, s.key, s.chain, pkcs7.SignerInfoConfig{
+		ExtraSignedAttributes: []pkcs7.Attribute{{Type: oidSigningCertificateV2, Value: signingCertificate}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't sign the PDF: %w", err)
+	}
+
+	if s.tsaURL != "" {
+		signerInfo := &sd.GetSignedData().SignerInfos[0]
+		token, err := s.timestamp(ctx, signerInfo.EncryptedDigest)
+		if err != nil {
+			return nil, err
+		}
+		err = signerInfo.SetUnauthenticatedAttributes([]pkcs7.Attribute{{Type: oidTimestampToken, Value: asn1.RawValue{FullBytes: token}}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sd.Detach()
+
+	return sd.Finish()
+}
+
+// timestamp returns the RFC 3161 timestamp token of the signature value.
+func (s *Signer) timestamp(ctx context.Context, signature []byte) (token []byte, err error) {
+	ctx, span := tracer().Start(ctx, "sign.timestamp")
+	defer func() { endSpan(span, err) }()
+
+	query, err := timestamp.CreateRequest(bytes.NewReader(signature), &timestamp.RequestOptions{Hash: crypto.SHA256, Certificates: true})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tsaURL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't request the timestamp: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't request the timestamp: the TSA responded %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("can't read the timestamp: %w", err)
+	}
+
+	ts, err := timestamp.ParseResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	digest := sha256.Sum256(signature)
+	if !bytes.Equal(ts.HashedMessage, digest[:]) {
+		return nil, fmt.Errorf("invalid timestamp: it isn't of the signature")
+	}
+
+	return ts.RawToken, nil
+}
+
+// appearanceLines are the texts of the visible signature.
+func (s *Signer) appearanceLines(signature Signature, date time.Time) []string {
+	name := s.cert.Subject.CommonName
+	if name == "" && len(s.cert.Subject.Organization) > 0 {
+		name = s.cert.Subject.Organization[0]
+	}
+
+	lines := []string{"Firmado digitalmente por", name, "Fecha: " + date.Format("2006-01-02 15:04:05 -07:00")}
+	if signature.Reason != "" {
+		lines = append(lines, "Razón: "+signature.Reason)
+	}
+	if signature.Location != "" {
+		lines = append(lines, "Lugar: "+signature.Location)
+	}
+
+	return lines
+}
+
+var appearanceFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// appearanceImage draws the lines in the coverage of an image of the box,
+// the texts are an image so the PDF/A documents don't need another font.
+func appearanceImage(width, height float64, lines []string) (*image.Alpha, error) {
+	f, err := appearanceFont()
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := int(width*appearanceScale), int(height*appearanceScale)
+	padding := 2 * appearanceScale
+	// The lines fill the height, up to a size of 9 points, and fit the width.
+	size := min(float64(h-2*padding)/(1.2*float64(len(lines))), 9*appearanceScale)
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	widest := 0
+	for _, line := range lines {
+		widest = max(widest, font.MeasureString(face, line).Ceil())
+	}
+	if available := w - 2*padding; widest > available {
+		size = size * float64(available) / float64(widest)
+		face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	img := image.NewAlpha(image.Rect(0, 0, w, h))
+	drawer := font.Drawer{Dst: img, Src: image.Opaque, Face: face}
+	y := padding + face.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawer.Dot = fixed.P(padding, y)
+		drawer.DrawString(line)
+		y += int(size * 1.2)
+	}
+
+	return img, nil
+}
+
+// incrementalUpdate appends objects to a PDF without changing its bytes, in
+// a new section with its own cross-reference.
+type incrementalUpdate struct {
+	pdfCtx  *model.Context
+	buf     bytes.Buffer
+	offsets map[int]int
+	next    int
+}
+
+func newIncrementalUpdate(pdf []byte, pdfCtx *model.Context) *incrementalUpdate {
+	u := &incrementalUpdate{pdfCtx: pdfCtx, offsets: map[int]int{}, next: *pdfCtx.Size}
+	u.buf.Write(pdf)
+	if !bytes.HasSuffix(pdf, []byte("\n")) {
+		u.buf.WriteByte('\n')
+	}
+
+	return u
+}
+
+// newObject returns the number of a new object.
+func (u *incrementalUpdate) newObject() int {
+	n := u.next
+	u.next++
+
+	return n
+}
+
+// write appends a new or updated object, and returns the offset of its
+// content.
+func (u *incrementalUpdate) write(number int, object string) int {
+	u.offsets[number] = u.buf.Len()
+	fmt.Fprintf(&u.buf, "%d 0 obj\n", number)
+	offset := u.buf.Len()
+	u.buf.WriteString(object)
+	u.buf.WriteString("\nendobj\n")
+
+	return offset
+}
+
+// writeStream appends a new stream, compressed with Flate.
+func (u *incrementalUpdate) writeStream(dict types.Dict, content []byte) (int, error) {
+	sd, err := u.pdfCtx.NewStreamDictForBuf(content)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range dict {
+		sd.Insert(k, v)
+	}
+	err = sd.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	number := u.newObject()
+	u.write(number, sd.Dict.PDFString()+"\nstream\n"+string(sd.Raw)+"\nendstream")
+
+	return number, nil
+}
+
+// writeAppearance appends the form of the visible signature, an image of
+// the lines with their coverage in the soft mask, and returns its number.
+func (u *incrementalUpdate) writeAppearance(width, height float64, lines []string) (int, error) {
+	coverage, err := appearanceImage(width, height, lines)
+	if err != nil {
+		return 0, err
+	}
+
+	size := coverage.Bounds().Size()
+	imageDict := func(colorSpace string) types.Dict {
+		return types.Dict{
+			"Type":             types.Name("XObject"),
+			"Subtype":          types.Name("Image"),
+			"Width":            types.Integer(size.X),
+			"Height":           types.Integer(size.Y),
+			"ColorSpace":       types.Name(colorSpace),
+			"BitsPerComponent": types.Integer(8),
+		}
+	}
+	mask, err := u.writeStream(imageDict("DeviceGray"), coverage.Pix)
+	if err != nil {
+		return 0, err
+	}
+
+	// The text is dark blue.
+	color := bytes.Repeat([]byte{0x1f, 0x3a, 0x93}, size.X*size.Y)
+	img := imageDict("DeviceRGB")
+	img["SMask"] = *types.NewIndirectRef(mask, 0)
+	imgNumber, err := u.writeStream(img, color)
+	if err != nil {
+		return 0, err
+	}
+
+	w, h := formatFloat(width), formatFloat(height)
+	return u.writeStream(types.Dict{
+		"Type":      types.Name("XObject"),
+		"Subtype":   types.Name("Form"),
+		"BBox":      types.NewNumberArray(0, 0, width, height),
+		"Resources": types.Dict{"XObject": types.Dict{"Img": *types.NewIndirectRef(imgNumber, 0)}},
+	}, []byte("q "+w+" 0 0 "+h+" 0 0 cm /Img Do Q"))
+}
+
+// finish appends the cross-reference of the objects, a stream when the PDF
+// uses them, and returns the PDF.
+func (u *incrementalUpdate) finish(prev int) ([]byte, error) {
+	trailer := types.Dict{
+		"Root": *u.pdfCtx.Root,
+		"Prev": types.Integer(prev),
+	}
+	if u.pdfCtx.Info != nil {
+		trailer["Info"] = *u.pdfCtx.Info
+	}
+	if len(u.pdfCtx.ID) == 2 {
+		id := make([]byte, 16)
+		_, err := rand.Read(id)
+		if err != nil {
+			return nil, err
+		}
+		trailer["ID"] = types.Array{u.pdfCtx.ID[0], types.HexLiteral(hex.EncodeToString(id))}
+	}
+
+	if !u.pdfCtx.Read.UsingXRefStreams {
+		trailer["Size"] = types.Integer(u.next)
+		offset := u.buf.Len()
+		u.buf.WriteString("xref\n")
+		for _, number := range u.numbers() {
+			fmt.Fprintf(&u.buf, "%d 1\n%010d 00000 n \n", number, u.offsets[number])
+		}
+		fmt.Fprintf(&u.buf, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", trailer.PDFString(), offset)
+
+		return u.buf.Bytes(), nil
+	}
+
+	xref := u.newObject()
+	offset := u.buf.Len()
+	u.offsets[xref] = offset
+	var index types.Array
+	var entries []byte
+	for _, number := range u.numbers() {
+		index = append(index, types.Integer(number), types.Integer(1))
+		entries = append(entries, 1)
+		entries = binary.BigEndian.AppendUint32(entries, uint32(u.offsets[number]))
+		entries = append(entries, 0, 0)
+	}
+	trailer["Type"] = types.Name("XRef")
+	trailer["Size"] = types.Integer(u.next)
+	trailer["W"] = types.NewIntegerArray(1, 4, 2)
+	trailer["Index"] = index
+	trailer["Length"] = types.Integer(len(entries))
+	fmt.Fprintf(&u.buf, "%d 0 obj\n%s\nstream\n", xref, trailer.PDFString())
+	u.buf.Write(entries)
+	fmt.Fprintf(&u.buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", offset)
+
+	return u.buf.Bytes(), nil
+}
+
+// numbers returns the numbers of the written objects in order.
+func (u *incrementalUpdate) numbers() []int {
+	numbers := make([]int, 0, len(u.offsets))
+	for number := range u.offsets {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	return numbers
+}
+
+// lastStartXRef returns the offset of the last cross-reference of the PDF.
+func lastStartXRef(pdf []byte) (int, error) {
+	i := bytes.LastIndex(pdf, []byte("startxref"))
+	if i < 0 {
+		return 0, ErrorProcess{Msg: "the document isn't a valid PDF, it doesn't have a startxref"}
+	}
+
+	fields := strings.Fields(string(pdf[i+len("startxref") : min(len(pdf), i+len("startxref")+32)]))
+	if len(fields) == 0 {
+		return 0, ErrorProcess{Msg: "the document isn't a valid PDF, it doesn't have a startxref"}
+	}
+	offset, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, ErrorProcess{Msg: "the document isn't a valid PDF, invalid startxref"}
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,396 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digitorus/timestamp"
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testCertificate returns a self-signed certificate and its key.
+func testCertificate(t *testing.T, name string, usage x509.ExtKeyUsage) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Empresa"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// testKeystore returns a PKCS#12 keystore with a self-signed certificate.
+func testKeystore(t *testing.T, name, password string) []byte {
+	t.Helper()
+
+	cert, key := testCertificate(t, name, x509.ExtKeyUsageAny)
+	keystore, err := pkcs12.Modern.Encode(key, cert, nil, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keystore
+}
+
+func testSigner(t *testing.T, name, tsaURL string) *Signer {
+	t.Helper()
+
+	signer, err := NewSigner(testKeystore(t, name, "secret"), "secret", tsaURL)
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the keystore: %v", err)
+	}
+
+	return signer
+}
+
+// testTSA is an RFC 3161 server with a self-signed certificate.
+func testTSA(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	cert, key := testCertificate(t, "TSA", x509.ExtKeyUsageTimeStamping)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := timestamp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ts := timestamp.Timestamp{
+			HashAlgorithm:     req.HashAlgorithm,
+			HashedMessage:     req.HashedMessage,
+			Time:              time.Now().UTC().Truncate(time.Second),
+			Nonce:             req.Nonce,
+			Policy:            asn1.ObjectIdentifier{1, 2, 3, 4},
+			AddTSACertificate: req.Certificates,
+		}
+		resp, err := ts.CreateResponseWithOpts(cert, key, crypto.SHA256)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(echo.HeaderContentType, "application/timestamp-reply")
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func verify(t *testing.T, pdf []byte) Verification {
+	t.Helper()
+
+	verification, err := VerifyPDF(context.Background(), pdf)
+	if err != nil {
+		t.Fatalf("Got an unexpected error verifying the PDF: %v", err)
+	}
+
+	return verification
+}
+
+func TestSignPDF(t *testing.T) {
+	pdf := testPages(t, "one", "two")
+	signer := testSigner(t, "Empresa S.A.S.", "")
+
+	signed, err := SignPDF(context.Background(), pdf, signer, Signature{Reason: "Certificado", Location: "Bogotá"})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(signed, pdf) {
+		t.Error("the signature must be an incremental update of the PDF")
+	}
+	if err := api.Validate(bytes.NewReader(signed), nil); err != nil {
+		t.Errorf("the signed PDF must be valid: %v", err)
+	}
+	if got := pageTexts(t, signed); len(got) != 2 {
+		t.Errorf("pages = %v, want the 2 pages", got)
+	}
+
+	verification := verify(t, signed)
+	if !verification.Signed || !verification.Valid || len(verification.Signatures) != 1 {
+		t.Fatalf("verification = %+v, want a valid signature", verification)
+	}
+	s := verification.Signatures[0]
+	if s.Signer != "Empresa S.A.S." || s.Reason != "Certificado" || s.Location != "Bogotá" || s.SubFilter != "ETSI.CAdES.detached" {
+		t.Errorf("signature = %+v", s)
+	}
+	if !s.CoversDocument || s.SigningTime == nil || s.Timestamp != nil {
+		t.Errorf("signature = %+v, want it to cover the document without a timestamp", s)
+	}
+	if s.Trusted {
+		t.Error("a self-signed certificate must not be trusted")
+	}
+
+	// A second signature is another update, the first one doesn't cover it.
+	twice, err := SignPDF(context.Background(), signed, testSigner(t, "Revisor", ""), Signature{Invisible: true})
+	if err != nil {
+		t.Fatalf("Got an unexpected error signing again: %v", err)
+	}
+	verification = verify(t, twice)
+	if !verification.Valid || len(verification.Signatures) != 2 {
+		t.Fatalf("verification = %+v, want 2 valid signatures", verification)
+	}
+	if verification.Signatures[0].CoversDocument || !verification.Signatures[1].CoversDocument {
+		t.Errorf("only the last signature must cover the document: %+v", verification.Signatures)
+	}
+}
+
+func TestSignPDF_modified(t *testing.T) {
+	signed, err := SignPDF(context.Background(), testPages(t, "one"), testSigner(t, "Empresa", ""), Signature{})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	modified := bytes.Replace(signed, []byte("(one)"), []byte("(two)"), 1)
+	if bytes.Equal(modified, signed) {
+		t.Fatal("the test must change the text of the page")
+	}
+	verification := verify(t, modified)
+	if verification.Valid || len(verification.Signatures) != 1 || verification.Signatures[0].Valid {
+		t.Errorf("verification = %+v, a modified document must be invalid", verification)
+	}
+
+	verification = verify(t, testPages(t, "one"))
+	if verification.Signed || verification.Valid {
+		t.Errorf("verification = %+v, the document isn't signed", verification)
+	}
+}
+
+func TestSignPDF_timestamp(t *testing.T) {
+	tsa := testTSA(t)
+	signed, err := SignPDF(context.Background(), testPages(t, "one"), testSigner(t, "Empresa", tsa.URL), Signature{})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	verification := verify(t, signed)
+	if !verification.Valid || verification.Signatures[0].Timestamp == nil {
+		t.Errorf("verification = %+v, want a valid signature with a timestamp", verification)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	if _, err := SignPDF(context.Background(), testPages(t, "one"), testSigner(t, "Empresa", failing.URL), Signature{}); err == nil {
+		t.Error("the signature must fail without the timestamp")
+	}
+}
+
+func TestSignPDF_errors(t *testing.T) {
+	signer := testSigner(t, "Empresa", "")
+	pdf := testPages(t, "one")
+
+	if _, err := SignPDF(context.Background(), pdf, signer, Signature{Page: 3}); err == nil {
+		t.Error("a missing page must be rejected")
+	}
+	if _, err := SignPDF(context.Background(), pdf, signer, Signature{Rect: []float64{10, 10, 5, 20}}); err == nil {
+		t.Error("an invalid rect must be rejected")
+	}
+
+	encrypted, err := EncryptPDF(context.Background(), pdf, Encryption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignPDF(context.Background(), encrypted, signer, Signature{}); err == nil {
+		t.Error("an encrypted PDF must be rejected")
+	}
+
+	options := RenderOptions{Signature: &Signature{}, Encryption: &Encryption{}}
+	if err := options.Validate(); err == nil {
+		t.Error("a signed render can't be encrypted")
+	}
+}
+
+func TestSigners(t *testing.T) {
+	def := testSigner(t, "Empresa", "")
+	acme := testSigner(t, "Acme", "")
+	signers := NewSigners(def, map[string]*Signer{"acme": acme})
+
+	tests := []struct {
+		client string
+		want   string
+	}{
+		{"", "Empresa"},
+		{"other", "Empresa"},
+		{"acme", "Acme"},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.client != "" {
+			ctx = ContextWithClient(ctx, Client{Name: tt.client})
+		}
+		signed, err := signers.Sign(ctx, testPages(t, "one"), Signature{Invisible: true})
+		if err != nil {
+			t.Fatalf("%q: Got an unexpected error: %v", tt.client, err)
+		}
+		if got := verify(t, signed).Signatures[0].Signer; got != tt.want {
+			t.Errorf("%q: signer = %q, want %q", tt.client, got, tt.want)
+		}
+	}
+
+	onlyAcme := NewSigners(nil, map[string]*Signer{"acme": acme})
+	if _, err := onlyAcme.Sign(context.Background(), testPages(t, "one"), Signature{}); err == nil {
+		t.Error("a client without a keystore must be rejected without a default")
+	}
+	var none *Signers
+	if _, err := none.Sign(context.Background(), testPages(t, "one"), Signature{}); err == nil {
+		t.Error("the signatures must be rejected without signers")
+	}
+}
+
+func TestLoadSigners(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"default", "acme"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".p12"), testKeystore(t, name, name+"-password"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "keystores.json")
+	data, _ := json.Marshal([]SigningKeystore{
+		{Path: filepath.Join(dir, "default.p12"), Password: "default-password"},
+		{Client: "acme", Path: filepath.Join(dir, "acme.p12"), Password: "acme-password", TSAURL: "http://tsa.acme.test"},
+	})
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keystores, err := LoadSigningKeystores(file)
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	signers, err := LoadSigners(keystores, "http://tsa.test")
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if signers.def.Certificate().Subject.CommonName != "default" || signers.def.tsaURL != "http://tsa.test" {
+		t.Errorf("default signer = %v %q", signers.def.Certificate().Subject, signers.def.tsaURL)
+	}
+	if signers.clients["acme"].tsaURL != "http://tsa.acme.test" {
+		t.Errorf("the TSA of acme = %q", signers.clients["acme"].tsaURL)
+	}
+
+	keystores[1].Password = "wrong"
+	if _, err := LoadSigners(keystores, ""); err == nil {
+		t.Error("a wrong password must be rejected")
+	}
+	if _, err := LoadSigners([]SigningKeystore{keystores[0], keystores[0]}, ""); err == nil {
+		t.Error("two default keystores must be rejected")
+	}
+}
+
+func TestDIAN_signature(t *testing.T) {
+	// The template reads its images from the working directory, without
+	// them maroto writes an error with a standard font.
+	dir := t.TempDir()
+	for _, name := range []string{"logo_dian.png", "form_220.png"} {
+		if err := os.WriteFile(dir+"/"+name, testPNG(t), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	item := DIANForm220Relation{DIANForm220: DIANForm220{Year: 2022, Records: json.RawMessage(`{}`)}, IdentificationNumber: "1098765432"}
+	dian := NewDIAN(false)
+	dian.Profile = ProfilePDFA2B
+	dian.Signature = &Signature{Reason: "Certificado de ingresos y retenciones"}
+	if _, err := dian.CreateDIANForm220(context.Background(), DIANForms220Relation{item}); err == nil {
+		t.Error("the signature must be rejected without signers")
+	}
+
+	dian.signers = NewSigners(testSigner(t, "Empresa", ""), nil)
+	pdf, err := dian.CreateDIANForm220(context.Background(), DIANForms220Relation{item})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+
+	// The signature is an update, so the document is still a PDF/A.
+	checkPDFA(t, pdf)
+	verification := verify(t, pdf)
+	if !verification.Valid || verification.Signatures[0].Reason != "Certificado de ingresos y retenciones" {
+		t.Errorf("verification = %+v", verification)
+	}
+	if !bytes.Contains(pdf, []byte("/Rect"+types.NewNumberArray(dianSignatureRect...).PDFString())) {
+		t.Error("the appearance must be in the Firma area")
+	}
+}
+
+func TestHandler_signature(t *testing.T) {
+	signers := NewSigners(testSigner(t, "Empresa", ""), nil)
+	e := echo.New()
+	Router(e, RouterConfig{
+		Auth:      testAPIKeys(),
+		Generator: NewGenerator(WithDefaultBackend(BackendMaroto), WithSigners(signers)),
+		Signers:   signers,
+	})
+
+	for _, tt := range []struct{ path, body string }{
+		{"/html-to-pdf", `{"data": "<p>a</p>", "options": {"signature": {"reason": "Aprobado"}}}`},
+		{"/dian-form-220", `{"signature": {"reason": "Aprobado"}, "data": [{"year": 2022, "rows": {}}]}`},
+	} {
+		rec := doJSON(e, http.MethodPost, tt.path, tt.body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tt.path, rec.Code, rec.Body)
+		}
+		resp := map[string][]byte{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+
+		rec = doPDF(e, "/pdf/verify", []testPart{{name: "pdf", data: string(resp["data"])}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: verify status = %d: %s", tt.path, rec.Code, rec.Body)
+		}
+		verification := Verification{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &verification)
+		if !verification.Valid || verification.Signatures[0].Reason != "Aprobado" {
+			t.Errorf("%s: verification = %s", tt.path, rec.Body)
+		}
+	}
+
+	rec := doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": "<p>a</p>", "options": {"signature": {}, "encryption": {}}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a signed and encrypted PDF: %s", rec.Code, rec.Body)
+	}
+	rec = doPDF(e, "/pdf/merge", []testPart{{name: "html", data: `{"data": "<p>a</p>", "options": {"signature": {}}}`}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a signed part: %s", rec.Code, rec.Body)
+	}
+}
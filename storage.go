@@ -0,0 +1,347 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	DefaultResultURLTTL = 15 * time.Minute
+	// DefaultResultRetention is how long the LocalStorage keeps the
+	// documents.
+	DefaultResultRetention = 24 * time.Hour
+)
+
+var (
+	ErrResultNotFound = errors.New("result not found")
+	ErrURLExpired     = errors.New("the download URL expired")
+	ErrURLSignature   = errors.New("the download URL signature is not valid")
+)
+
+// Storage keeps the rendered documents, so the clients can download them
+// later instead of receiving the bytes in the response.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// URL returns a download URL of the object valid for the ttl.
+	URL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// StoredResult is the response of a render with `store: true`.
+type StoredResult struct {
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Results stores the render outputs and creates their download URLs.
+type Results struct {
+	storage Storage
+	ttl     time.Duration
+}
+
+// NewResults uses the storage with download URLs valid for the ttl.
+func NewResults(storage Storage, ttl time.Duration) *Results {
+	if ttl <= 0 {
+		ttl = DefaultResultURLTTL
+	}
+
+	return &Results{storage: storage, ttl: ttl}
+}
+
+// Store saves the document with a new key and returns its download URL.
+// The key starts with the owner, the client of the context.
+func (r *Results) Store(ctx context.Context, data []byte, contentType string) (StoredResult, error) {
+	ctx, span := tracer().Start(ctx, "store")
+	defer span.End()
+
+	extension := ".pdf"
+	if contentType == ContentTypeZIP {
+		extension = ".zip"
+	}
+	key := resultOwner(ctx) + "-" + newID() + extension
+
+	err := r.storage.Put(ctx, key, data, contentType)
+	if err != nil {
+		endSpan(span, err)
+		return StoredResult{}, fmt.Errorf("can't store the result: %w", err)
+	}
+
+	expires := time.Now().Add(r.ttl)
+	u, err := r.storage.URL(ctx, key, r.ttl)
+	if err != nil {
+		endSpan(span, err)
+		return StoredResult{}, fmt.Errorf("can't create the download URL: %w", err)
+	}
+
+	return StoredResult{Key: key, URL: u, ExpiresAt: expires}, nil
+}
+
+// Load returns a stored document, ErrResultNotFound if it doesn't exist or
+// it's of another client. The documents of the other clients can only be
+// loaded with ScopeAll.
+func (r *Results) Load(ctx context.Context, key string) ([]byte, error) {
+	ctx, span := tracer().Start(ctx, "load")
+	defer span.End()
+
+	if client, ok := ClientFromContext(ctx); ok && !client.Has(ScopeAll) && !strings.HasPrefix(key, resultOwner(ctx)+"-") {
+		endSpan(span, ErrResultNotFound)
+		return nil, ErrResultNotFound
+	}
+
+	data, err := r.storage.Get(ctx, key)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// resultOwner is the prefix of the keys of the client of the context, a
+// hash of its name so the keys stay valid file names. The documents of the
+// anonymous requests are "public".
+func resultOwner(ctx context.Context) string {
+	client, ok := ClientFromContext(ctx)
+	if !ok {
+		return "public"
+	}
+	sum := sha256.Sum256([]byte(client.Name))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// LocalStorage keeps the documents in a directory. The download URLs are
+// served by the service and signed with the secret, so they can't be forged
+// or used after they expire.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+// NewLocalStorage creates the directory if it doesn't exist. The baseURL is
+// the public URL of the service.
+func NewLocalStorage(dir, baseURL, secret string) (*LocalStorage, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the storage directory: %w", err)
+	}
+
+	return &LocalStorage{dir: dir, baseURL: baseURL, secret: []byte(secret)}, nil
+}
+
+func (s *LocalStorage) Put(_ context.Context, key string, data []byte, _ string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path+".tmp", data, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *LocalStorage) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrResultNotFound
+	}
+
+	return data, err
+}
+
+func (s *LocalStorage) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Start removes the documents older than the retention every minute, until
+// the context is done.
+func (s *LocalStorage) Start(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		retention = DefaultResultRetention
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.removeExpired(ctx, now.Add(-retention))
+			}
+		}
+	}()
+}
+
+// removeExpired removes the documents modified before the time.
+func (s *LocalStorage) removeExpired(ctx context.Context, before time.Time) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		LoggerFromContext(ctx).Error("can't read the storage directory", "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || !info.ModTime().Before(before) {
+			continue
+		}
+		err = os.Remove(filepath.Join(s.dir, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			LoggerFromContext(ctx).Error("can't remove an expired result", "key", entry.Name(), "error", err)
+		}
+	}
+}
+
+// URL returns the link to GET /results/{key} with the expiration and the
+// signature as query params.
+func (s *LocalStorage) URL(_ context.Context, key string, ttl time.Duration) (string, error) {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.sign(key, expires)},
+	}
+
+	return s.baseURL + "/results/" + url.PathEscape(key) + "?" + query.Encode(), nil
+}
+
+// Verify checks the expiration and the signature of a download URL.
+func (s *LocalStorage) Verify(key, expires, signature string) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrURLSignature
+	}
+	if !hmac.Equal([]byte(s.sign(key, expires)), []byte(signature)) {
+		return ErrURLSignature
+	}
+	if time.Now().Unix() > unix {
+		return ErrURLExpired
+	}
+
+	return nil
+}
+
+func (s *LocalStorage) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "." + expires))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// path returns the file of the key, the keys can't leave the directory.
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || key[0] == '.' {
+		return "", ErrorProcess{Msg: fmt.Sprintf("invalid key %q", key)}
+	}
+
+	return filepath.Join(s.dir, key), nil
+}
+
+// S3Config is the connection to an S3-compatible storage, like AWS S3 or
+// MinIO.
+type S3Config struct {
+	// Endpoint is the host and port, e.g. s3.amazonaws.com or minio:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Insecure uses HTTP instead of HTTPS.
+	Insecure bool
+}
+
+// S3Storage keeps the documents in a bucket, the download URLs are
+// presigned so they go straight to the storage.
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	// With the region the client doesn't ask the bucket location.
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create the S3 client: %w", err)
+	}
+
+	return &S3Storage{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: contentType})
+
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, ErrResultNotFound
+	}
+
+	return data, err
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, ttl, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,241 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestResults_local(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir(), "", "secret")
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the storage: %v", err)
+	}
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen, Results: NewResults(storage, time.Minute)})
+
+	rec := doJSON(e, http.MethodPost, "/dian-form-220", `{"store": true, "data": [{"year": 2022, "rows": {}}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	result := StoredResult{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &result)
+	if !strings.HasSuffix(result.Key, ".pdf") || time.Until(result.ExpiresAt) <= 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// The download doesn't need the internal code.
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, result.URL, nil))
+	if rec.Code != http.StatusOK || !bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF")) {
+		t.Fatalf("status = %d, the download isn't a PDF", rec.Code)
+	}
+
+	u, _ := url.Parse(result.URL)
+	query := u.Query()
+	query.Set("expires", "4102444800")
+	u.RawQuery = query.Encode()
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("a modified URL must be forbidden, status = %d", rec.Code)
+	}
+
+	expired, _ := storage.URL(context.Background(), result.Key, -time.Minute)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, expired, nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("an expired URL must be forbidden, status = %d", rec.Code)
+	}
+}
+
+func TestResults_disabled(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen})
+
+	rec := doJSON(e, http.MethodPost, "/html-to-pdf", `{"store": true, "data": "<p>Hola</p>"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLocalStorage_keys(t *testing.T) {
+	storage, _ := NewLocalStorage(t.TempDir(), "", "secret")
+	for _, key := range []string{"", "../jobs.db", "a/b.pdf", ".hidden"} {
+		err := storage.Put(context.Background(), key, []byte("x"), ContentTypePDF)
+		if err == nil {
+			t.Errorf("the key %q must be rejected", key)
+		}
+	}
+
+	_, err := storage.Get(context.Background(), "missing.pdf")
+	if err != ErrResultNotFound {
+		t.Errorf("err = %v, want %v", err, ErrResultNotFound)
+	}
+}
+
+func TestResults_owner(t *testing.T) {
+	storage, _ := NewLocalStorage(t.TempDir(), "", "secret")
+	auth, _ := NewAPIKeys([]APIKey{
+		{Name: "payroll", Hash: HashAPIKey("payroll-key"), Scopes: []string{ScopePDFProcess, ScopeDIANRender}},
+		{Name: "web", Hash: HashAPIKey("web-key"), Scopes: []string{ScopePDFProcess}},
+		{Name: "admin", Hash: HashAPIKey("admin-key"), Scopes: []string{ScopeAll}},
+	})
+	e := echo.New()
+	Router(e, RouterConfig{Auth: auth, Generator: NewGenerator(WithDefaultBackend(BackendMaroto)), Results: NewResults(storage, time.Minute)})
+
+	rec := doMultipart(e, "/pdf/optimize", "payroll-key", []testPart{{name: "pdf", data: string(testForm(t))}, {name: "store", data: "true"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	stored := StoredResult{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &stored)
+
+	// The other clients can't load the document, only the ones with all the
+	// scopes.
+	for key, want := range map[string]int{"payroll-key": http.StatusOK, "web-key": http.StatusBadRequest, "admin-key": http.StatusOK} {
+		rec := doMultipart(e, "/pdf/form/fields", key, []testPart{{name: "key", data: stored.Key}})
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d: %s", key, rec.Code, want, rec.Body)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/pdf/form/"+stored.Key+"/fields", nil)
+	req.Header.Set(HeaderAPIKey, "web-key")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestLocalStorage_removeExpired(t *testing.T) {
+	dir := t.TempDir()
+	storage, _ := NewLocalStorage(dir, "", "secret")
+	ctx := context.Background()
+	_ = storage.Put(ctx, "old.pdf", []byte("x"), ContentTypePDF)
+	_ = storage.Put(ctx, "new.pdf", []byte("x"), ContentTypePDF)
+	old := time.Now().Add(-2 * time.Hour)
+	_ = os.Chtimes(filepath.Join(dir, "old.pdf"), old, old)
+
+	storage.removeExpired(ctx, time.Now().Add(-time.Hour))
+	if _, err := storage.Get(ctx, "old.pdf"); err != ErrResultNotFound {
+		t.Errorf("err = %v, the expired document must be removed", err)
+	}
+	if _, err := storage.Get(ctx, "new.pdf"); err != nil {
+		t.Errorf("Got an unexpected error, the new document must be kept: %v", err)
+	}
+}
+
+// fakeS3 is an in-memory S3 with only the object operations.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data = decodeAWSChunked(data)
+		}
+		f.objects[r.URL.Path] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeAWSChunked removes the signatures of a streaming upload, every chunk
+// is "<hex size>;chunk-signature=<sig>\r\n<data>\r\n".
+func decodeAWSChunked(body []byte) []byte {
+	var data []byte
+	for len(body) > 0 {
+		header, rest, _ := bytes.Cut(body, []byte("\r\n"))
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		if err != nil || size == 0 || int(size) > len(rest) {
+			break
+		}
+		data = append(data, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+
+	return data
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	storage, err := NewS3Storage(S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Bucket:    "pdfs",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error creating the storage: %v", err)
+	}
+
+	ctx := context.Background()
+	err = storage.Put(ctx, "a.pdf", []byte("%PDF-1.4"), ContentTypePDF)
+	if err != nil {
+		t.Fatalf("Got an unexpected error putting the object: %v", err)
+	}
+	if string(fake.objects["/pdfs/a.pdf"]) != "%PDF-1.4" {
+		t.Fatalf("the object wasn't stored: %v", fake.objects)
+	}
+
+	data, err := storage.Get(ctx, "a.pdf")
+	if err != nil || string(data) != "%PDF-1.4" {
+		t.Errorf("Get() = %q, %v", data, err)
+	}
+
+	u, err := storage.URL(ctx, "a.pdf", time.Minute)
+	if err != nil || !strings.Contains(u, "X-Amz-Signature=") || !strings.Contains(u, "X-Amz-Expires=60") {
+		t.Errorf("URL() = %q, %v, want a presigned URL", u, err)
+	}
+
+	err = storage.Delete(ctx, "a.pdf")
+	if err != nil {
+		t.Fatalf("Got an unexpected error deleting the object: %v", err)
+	}
+	_, err = storage.Get(ctx, "a.pdf")
+	if err != ErrResultNotFound {
+		t.Errorf("err = %v, want %v", err, ErrResultNotFound)
+	}
+}
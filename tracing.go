@@ -0,0 +1,158 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/alexyslozada/gohtmltopdf"
+
+// propagator reads and writes the W3C trace context and baggage headers.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// tracer uses the global provider, which is a no-op until SetupTracing
+// configures an exporter.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// SetupTracing exports the spans with OTLP over HTTP to the endpoint, e.g.
+// http://otel-collector:4318. With an empty endpoint the spans aren't
+// recorded. The returned function flushes the pending spans on shutdown.
+func SetupTracing(ctx context.Context, endpoint, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("can't create the OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("can't create the tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracing starts a server span for every request, continuing the trace of
+// the traceparent header of the client.
+func Tracing() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			ctx, span := tracer().Start(ctx, req.Method+" "+c.Path(),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(req.Method),
+					semconv.HTTPRoute(c.Path()),
+				),
+			)
+			defer span.End()
+
+			if id := RequestID(c); id != "" {
+				span.SetAttributes(attribute.String("request.id", id))
+			}
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if status >= 500 {
+				span.SetStatus(codes.Error, fmt.Sprintf("status %d", status))
+			}
+
+			return nil
+		}
+	}
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// setOutputAttributes adds the size and the page count of the PDF to the
+// span. The pages are only counted when the span is recorded.
+func setOutputAttributes(span trace.Span, pdf []byte) {
+	if !span.IsRecording() || len(pdf) == 0 {
+		return
+	}
+
+	span.SetAttributes(attribute.Int("pdf.output_bytes", len(pdf)))
+	pages, err := api.PageCount(bytes.NewReader(pdf), nil)
+	if err == nil {
+		span.SetAttributes(attribute.Int("pdf.page_count", pages))
+	}
+}
+
+// wkhtmltopdfStages are the progress lines of wkhtmltopdf that are added as
+// events to its span, so we know if the time went into loading the pages.
+var wkhtmltopdfStages = []string{
+	"Loading pages",
+	"Counting pages",
+	"Resolving links",
+	"Loading headers and footers",
+	"Printing pages",
+	"Done",
+}
+
+// stageRecorder is the stderr of wkhtmltopdf, it keeps the output for the
+// errors and adds an event to the span when a stage starts.
+type stageRecorder struct {
+	span    trace.Span
+	buf     bytes.Buffer
+	current string
+}
+
+func (s *stageRecorder) Write(p []byte) (int, error) {
+	s.buf.Write(p)
+	if !s.span.IsRecording() {
+		return len(p), nil
+	}
+
+	// The progress is updated with carriage returns, every chunk is checked.
+	for _, chunk := range strings.FieldsFunc(string(p), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		for _, stage := range wkhtmltopdfStages {
+			if strings.Contains(chunk, stage) && s.current != stage {
+				s.current = stage
+				s.span.AddEvent(stage)
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *stageRecorder) String() string {
+	return s.buf.String()
+}
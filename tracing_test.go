@@ -0,0 +1,62 @@
+package gohtmltopdf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing_spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: gen})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/dian-form-220", strings.NewReader(`{"data": [{"year": 2022, "rows": {}}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(ParamInternalCode, "secret")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("span %s has the trace %s, want %s", span.Name(), got, traceID)
+		}
+	}
+
+	for _, name := range []string{"POST /dian-form-220", "bind", "dian.template", "dian.generate", "respond"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("span %q not found", name)
+		}
+	}
+
+	attrs := map[string]any{}
+	for _, attr := range spans["dian.generate"].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.AsInterface()
+	}
+	for key, want := range map[string]any{"dian.year": int64(2022), "dian.employees": int64(1), "pdf.page_count": int64(1)} {
+		if attrs[key] != want {
+			t.Errorf("attribute %s = %v, want %v", key, attrs[key], want)
+		}
+	}
+	if _, ok := attrs["pdf.output_bytes"]; !ok {
+		t.Errorf("attribute pdf.output_bytes not found")
+	}
+}
@@ -0,0 +1,271 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/digitorus/timestamp"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Verification is the result of the verification of the signatures of a
+// PDF.
+type Verification struct {
+	Signed bool `json:"signed"`
+	// Valid reports whether every signature is valid and the document
+	// wasn't modified after the last one.
+	Valid      bool                `json:"valid"`
+	Signatures []VerifiedSignature `json:"signatures"`
+}
+
+// VerifiedSignature is a signature of the PDF and its verification.
+type VerifiedSignature struct {
+	Field        string     `json:"field"`
+	Signer       string     `json:"signer,omitempty"`
+	Issuer       string     `json:"issuer,omitempty"`
+	SerialNumber string     `json:"serial_number,omitempty"`
+	SubFilter    string     `json:"sub_filter,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	SigningTime  *time.Time `json:"signing_time,omitempty"`
+	// Timestamp is the time of the RFC 3161 timestamp of the signature.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// Valid reports whether the signed bytes and the CMS signature are
+	// valid.
+	Valid bool `json:"valid"`
+	// CoversDocument reports whether the signature covers the whole PDF,
+	// otherwise the document was updated after the signature.
+	CoversDocument bool `json:"covers_document"`
+	// Trusted reports whether the certificate chains to a root of the
+	// system, the self-signed certificates aren't trusted.
+	Trusted bool   `json:"trusted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// VerifyPDF checks the signatures of the PDF. A signature that can't be
+// verified has its error in the result.
+func VerifyPDF(ctx context.Context, pdf []byte) (verification Verification, err error) {
+	_, span := tracer().Start(ctx, "verify")
+	defer func() { endSpan(span, err) }()
+
+	pdfCtx, err := api.ReadContext(bytes.NewReader(pdf), model.NewDefaultConfiguration())
+	if err != nil {
+		return Verification{}, ErrorProcess{Msg: fmt.Sprintf("the document isn't a valid PDF or it's encrypted: %v", err)}
+	}
+	catalog, err := pdfCtx.Catalog()
+	if err != nil {
+		return Verification{}, err
+	}
+
+	verification.Signatures = []VerifiedSignature{}
+	form, err := pdfCtx.DereferenceDict(catalog["AcroForm"])
+	if err != nil || form == nil {
+		return verification, nil
+	}
+	fields, err := pdfCtx.DereferenceArray(form["Fields"])
+	if err != nil {
+		return verification, nil
+	}
+	for _, field := range signatureFields(pdfCtx, fields, "", "") {
+		verification.Signatures = append(verification.Signatures, verifySignature(pdfCtx, pdf, field))
+	}
+
+	verification.Signed = len(verification.Signatures) > 0
+	verification.Valid = verification.Signed
+	covered := false
+	for _, s := range verification.Signatures {
+		verification.Valid = verification.Valid && s.Valid
+		covered = covered || s.CoversDocument
+	}
+	verification.Valid = verification.Valid && covered
+
+	return verification, nil
+}
+
+// signatureField is a signed field of the form.
+type signatureField struct {
+	name  string
+	value types.Dict
+}
+
+// signatureFields returns the signed fields, the kids inherit the type and
+// the name of their parents.
+func signatureFields(pdfCtx *model.Context, fields types.Array, parentName, parentType string) []signatureField {
+	var signed []signatureField
+	for _, f := range fields {
+		field, err := pdfCtx.DereferenceDict(f)
+		if err != nil || field == nil {
+			continue
+		}
+
+		name := parentName
+		if t, err := pdfCtx.DereferenceText(field["T"]); err == nil && t != "" {
+			if name != "" {
+				name += "."
+			}
+			name += t
+		}
+		fieldType := parentType
+		if ft, ok := field["FT"].(types.Name); ok {
+			fieldType = ft.Value()
+		}
+
+		if kids, err := pdfCtx.DereferenceArray(field["Kids"]); err == nil && len(kids) > 0 {
+			signed = append(signed, signatureFields(pdfCtx, kids, name, fieldType)...)
+			continue
+		}
+		if fieldType != "Sig" {
+			continue
+		}
+		value, err := pdfCtx.DereferenceDict(field["V"])
+		if err != nil || value == nil {
+			continue
+		}
+		signed = append(signed, signatureField{name: name, value: value})
+	}
+
+	return signed
+}
+
+// verifySignature checks the bytes of the ByteRange with the CMS of the
+// Contents.
+func verifySignature(pdfCtx *model.Context, pdf []byte, field signatureField) VerifiedSignature {
+	v := VerifiedSignature{Field: field.name}
+	if subFilter, ok := field.value["SubFilter"].(types.Name); ok {
+		v.SubFilter = subFilter.Value()
+	}
+	v.Reason, _ = pdfCtx.DereferenceText(field.value["Reason"])
+	v.Location, _ = pdfCtx.DereferenceText(field.value["Location"])
+
+	signed, contents, err := signedBytes(pdfCtx, pdf, field.value)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.CoversDocument = len(signed)+2*len(contents)+2 == len(pdf)
+
+	// The Contents are padded with zeros after the DER of the CMS.
+	var der asn1.RawValue
+	if _, err := asn1.Unmarshal(contents, &der); err != nil {
+		v.Error = fmt.Sprintf("invalid CMS: %v", err)
+		return v
+	}
+	p7, err := pkcs7.Parse(der.FullBytes)
+	if err != nil {
+		v.Error = fmt.Sprintf("invalid CMS: %v", err)
+		return v
+	}
+	p7.Content = signed
+
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		v.Error = "the CMS must have one signer with its certificate"
+		return v
+	}
+	v.Signer = cert.Subject.CommonName
+	v.Issuer = cert.Issuer.CommonName
+	v.SerialNumber = cert.SerialNumber.Text(16)
+
+	var signingTime time.Time
+	if err := p7.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime, &signingTime); err == nil {
+		v.SigningTime = &signingTime
+	}
+
+	err = p7.Verify()
+	if err != nil {
+		v.Error = fmt.Sprintf("invalid signature: %v", err)
+		return v
+	}
+
+	verifyTime := time.Now()
+	if v.SigningTime != nil {
+		verifyTime = *v.SigningTime
+	}
+	for _, attr := range p7.Signers[0].UnauthenticatedAttributes {
+		if !attr.Type.Equal(oidTimestampToken) {
+			continue
+		}
+		ts, err := signatureTimestamp(attr.Value.Bytes, p7.Signers[0].EncryptedDigest)
+		if err != nil {
+			v.Error = err.Error()
+			return v
+		}
+		v.Timestamp = &ts.Time
+		verifyTime = ts.Time
+	}
+	v.Valid = true
+
+	intermediates := x509.NewCertPool()
+	for _, c := range p7.Certificates {
+		intermediates.AddCert(c)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		CurrentTime:   verifyTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	v.Trusted = err == nil
+
+	return v
+}
+
+// signedBytes returns the bytes of the ByteRange and the Contents of the
+// signature.
+func signedBytes(pdfCtx *model.Context, pdf []byte, sig types.Dict) ([]byte, []byte, error) {
+	byteRange, err := pdfCtx.DereferenceArray(sig["ByteRange"])
+	if err != nil || len(byteRange) != 4 {
+		return nil, nil, fmt.Errorf("the signature doesn't have a ByteRange")
+	}
+	var r [4]int
+	for i, o := range byteRange {
+		n, err := pdfCtx.DereferenceInteger(o)
+		if err != nil || n == nil {
+			return nil, nil, fmt.Errorf("invalid ByteRange")
+		}
+		r[i] = n.Value()
+	}
+	// The gap of the ByteRange is only the Contents.
+	if r[0] != 0 || r[1] < 0 || r[2] <= r[1] || r[3] < 0 || r[2]+r[3] > len(pdf) || pdf[r[1]] != '<' || pdf[r[2]-1] != '>' {
+		return nil, nil, fmt.Errorf("invalid ByteRange")
+	}
+
+	hexContents, ok := sig["Contents"].(types.HexLiteral)
+	if !ok {
+		return nil, nil, fmt.Errorf("the signature doesn't have hex Contents")
+	}
+	contents, err := hexContents.Bytes()
+	if err != nil || 2*len(contents)+2 != r[2]-r[1] {
+		return nil, nil, fmt.Errorf("invalid Contents, they aren't the gap of the ByteRange")
+	}
+
+	signed := make([]byte, 0, r[1]+r[3])
+	signed = append(append(signed, pdf[:r[1]]...), pdf[r[2]:r[2]+r[3]]...)
+
+	return signed, contents, nil
+}
+
+// signatureTimestamp parses the RFC 3161 timestamp token, it must be of
+// the signature value.
+func signatureTimestamp(token, signature []byte) (*timestamp.Timestamp, error) {
+	ts, err := timestamp.Parse(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	if !ts.HashAlgorithm.Available() {
+		return nil, fmt.Errorf("invalid timestamp: unsupported hash")
+	}
+	h := ts.HashAlgorithm.New()
+	h.Write(signature)
+	if !bytes.Equal(h.Sum(nil), ts.HashedMessage) {
+		return nil, fmt.Errorf("invalid timestamp: it isn't of the signature")
+	}
+
+	return ts, nil
+}
@@ -0,0 +1,187 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Positions of a watermark in the page.
+var validPositions = []string{
+	"top-left", "top", "top-right",
+	"left", "center", "right",
+	"bottom-left", "bottom", "bottom-right",
+}
+
+// positionAnchors are the names of the positions in pdfcpu.
+var positionAnchors = map[string]string{
+	"top-left": "tl", "top": "tc", "top-right": "tr",
+	"left": "l", "center": "c", "right": "r",
+	"bottom-left": "bl", "bottom": "bc", "bottom-right": "br",
+}
+
+// Watermark is a text, like BORRADOR or COPIA, or an image, like the stamp
+// of the company, added to the pages after the render. Only one of Text and
+// Image can be set.
+type Watermark struct {
+	Text string `json:"text,omitempty"`
+	// Image is a PNG or JPEG, base64 in JSON.
+	Image []byte `json:"image,omitempty"`
+	// Font is a standard PDF font, Helvetica by default.
+	Font string `json:"font,omitempty"`
+	// FontSize in points. By default, the text fits half of the page.
+	FontSize int `json:"font_size,omitempty"`
+	// Color of the text in #RRGGBB, gray by default.
+	Color string `json:"color,omitempty"`
+	// Opacity goes from 0 to 1, by default 0.3 for texts and 1 for images.
+	Opacity *float64 `json:"opacity,omitempty"`
+	// Rotation in degrees, from -180 to 180. By default, the texts go in the
+	// diagonal of the page and the images aren't rotated.
+	Rotation *float64 `json:"rotation,omitempty"`
+	// Position in the page, center by default: top-left, top, top-right,
+	// left, center, right, bottom-left, bottom or bottom-right.
+	Position string `json:"position,omitempty"`
+	// OffsetX and OffsetY move the watermark from its position, in points.
+	OffsetX float64 `json:"offset_x,omitempty"`
+	OffsetY float64 `json:"offset_y,omitempty"`
+	// Scale of the image relative to the page width, 0.25 by default.
+	Scale float64 `json:"scale,omitempty"`
+	// Pages is a selection like "1,3-", all the pages by default.
+	Pages string `json:"pages,omitempty"`
+	// Background puts the watermark behind the content of the page, by
+	// default it goes on top.
+	Background bool `json:"background,omitempty"`
+}
+
+// Validate checks that the watermark can be added to a PDF.
+func (w Watermark) Validate() error {
+	_, err := w.pdfcpu()
+	return err
+}
+
+// pdfcpu returns the configuration of the watermark in pdfcpu.
+func (w Watermark) pdfcpu() (*model.Watermark, error) {
+	if (w.Text == "") == (len(w.Image) == 0) {
+		return nil, ErrorProcess{Msg: "the watermark needs a text or an image"}
+	}
+	if w.Position != "" && !containsFold(validPositions, w.Position) {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("watermark position %q not supported", w.Position)}
+	}
+	if w.Pages != "" {
+		_, err := api.ParsePageSelection(strings.ReplaceAll(w.Pages, " ", ""))
+		if err != nil {
+			return nil, ErrorProcess{Msg: fmt.Sprintf("invalid watermark pages %q: %v", w.Pages, err)}
+		}
+	}
+
+	var details []string
+	add := func(name, value string) {
+		details = append(details, name+":"+value)
+	}
+	if w.Position != "" {
+		add("position", positionAnchors[strings.ToLower(w.Position)])
+	}
+	if w.OffsetX != 0 || w.OffsetY != 0 {
+		add("offset", formatFloat(w.OffsetX)+" "+formatFloat(w.OffsetY))
+	}
+	if w.Rotation != nil {
+		add("rotation", formatFloat(*w.Rotation))
+	}
+
+	var wm *model.Watermark
+	var err error
+	if w.Text != "" {
+		opacity := 0.3
+		if w.Opacity != nil {
+			opacity = *w.Opacity
+		}
+		add("opacity", formatFloat(opacity))
+		if w.Font != "" {
+			add("fontname", w.Font)
+		}
+		if w.FontSize > 0 {
+			// The absolute scale keeps the size of the font.
+			add("points", strconv.Itoa(w.FontSize))
+			add("scalefactor", "1 abs")
+		}
+		if w.Color != "" {
+			add("fillcolor", w.Color)
+		}
+
+		wm, err = api.TextWatermark(w.Text, strings.Join(details, ","), !w.Background, false, types.POINTS)
+	} else {
+		contentType := http.DetectContentType(w.Image)
+		if contentType != "image/png" && contentType != "image/jpeg" {
+			return nil, ErrorProcess{Msg: fmt.Sprintf("the watermark image must be a PNG or JPEG, got %s", contentType)}
+		}
+		if w.Opacity != nil {
+			add("opacity", formatFloat(*w.Opacity))
+		}
+		if w.Rotation == nil {
+			add("rotation", "0")
+		}
+		scale := 0.25
+		if w.Scale > 0 {
+			scale = w.Scale
+		}
+		add("scalefactor", formatFloat(scale)+" rel")
+
+		wm, err = api.ImageWatermarkForReader(bytes.NewReader(w.Image), strings.Join(details, ","), !w.Background, false, types.POINTS)
+	}
+	if err != nil {
+		return nil, ErrorProcess{Msg: fmt.Sprintf("invalid watermark: %v", strings.TrimSpace(strings.TrimPrefix(err.Error(), "pdfcpu: ")))}
+	}
+
+	return wm, nil
+}
+
+// WatermarkPDF adds the watermarks to the PDF in order, so the last one goes
+// on top of the others.
+func WatermarkPDF(ctx context.Context, pdf []byte, watermarks []Watermark) (out []byte, err error) {
+	_, span := tracer().Start(ctx, "watermark")
+	defer func() { endSpan(span, err) }()
+
+	for i, w := range watermarks {
+		wm, err := w.pdfcpu()
+		if err != nil {
+			return nil, err
+		}
+
+		var selection []string
+		if w.Pages != "" {
+			selection = []string{strings.ReplaceAll(w.Pages, " ", "")}
+		}
+
+		buf := bytes.Buffer{}
+		err = api.AddWatermarks(bytes.NewReader(pdf), &buf, selection, wm, nil)
+		if err != nil {
+			return nil, fmt.Errorf("can't add the watermark %d: %w", i+1, err)
+		}
+		pdf = buf.Bytes()
+	}
+
+	return pdf, nil
+}
+
+// validateWatermarks checks every watermark of a request.
+func validateWatermarks(watermarks []Watermark) error {
+	for i, w := range watermarks {
+		err := w.Validate()
+		if err != nil {
+			return ErrorProcess{Msg: fmt.Sprintf("watermark %d: %v", i+1, err)}
+		}
+	}
+
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
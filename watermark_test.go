@@ -0,0 +1,157 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// testPNG returns a small red square.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for i := range img.Pix {
+		if i%4 == 0 || i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Got an unexpected error encoding the image: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func hasWatermarks(t *testing.T, pdf []byte) bool {
+	t.Helper()
+
+	ok, err := api.HasWatermarks(bytes.NewReader(pdf), nil)
+	if err != nil {
+		t.Fatalf("Got an unexpected error reading the PDF: %v", err)
+	}
+
+	return ok
+}
+
+func TestWatermarkPDF(t *testing.T) {
+	ctx := context.Background()
+	pdf := testPages(t, "one", "two")
+	opacity, rotation := 0.5, 30.0
+
+	tests := []struct {
+		name      string
+		watermark Watermark
+	}{
+		{"text", Watermark{Text: "BORRADOR"}},
+		{"styled text", Watermark{Text: "COPIA", Font: "Courier-Bold", FontSize: 60, Color: "#FF0000", Opacity: &opacity, Rotation: &rotation, Pages: "2"}},
+		{"image", Watermark{Image: testPNG(t), Position: "bottom-right", OffsetX: -20, OffsetY: 20, Scale: 0.1}},
+		{"background", Watermark{Text: "BORRADOR", Background: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := WatermarkPDF(ctx, pdf, []Watermark{tt.watermark})
+			if err != nil {
+				t.Fatalf("Got an unexpected error: %v", err)
+			}
+			if !hasWatermarks(t, out) {
+				t.Error("the PDF must have the watermark")
+			}
+			if pages, _ := pageCount(out, ""); pages != 2 {
+				t.Errorf("pages = %d, want 2", pages)
+			}
+		})
+	}
+}
+
+func TestWatermark_Validate(t *testing.T) {
+	opacity := 2.0
+	tests := []struct {
+		name      string
+		watermark Watermark
+	}{
+		{"empty", Watermark{}},
+		{"text and image", Watermark{Text: "COPIA", Image: testPNG(t)}},
+		{"unknown position", Watermark{Text: "COPIA", Position: "middle"}},
+		{"unknown font", Watermark{Text: "COPIA", Font: "Comic Sans"}},
+		{"invalid color", Watermark{Text: "COPIA", Color: "red-ish"}},
+		{"invalid opacity", Watermark{Text: "COPIA", Opacity: &opacity}},
+		{"invalid pages", Watermark{Text: "COPIA", Pages: "a-b"}},
+		{"not an image", Watermark{Image: []byte("<svg></svg>")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.watermark.Validate()
+			if err == nil {
+				t.Fatal("the watermark must be rejected")
+			}
+			if _, ok := err.(ErrorProcess); !ok {
+				t.Errorf("error = %T, want ErrorProcess", err)
+			}
+		})
+	}
+}
+
+func TestGenerator_Render_watermarks(t *testing.T) {
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	pdf, err := gen.Render(context.Background(), strings.NewReader("<p>Salario</p>"), RenderOptions{
+		Watermarks: []Watermark{{Text: "BORRADOR"}, {Image: testPNG(t), Position: "top-right"}},
+		Encryption: &Encryption{UserPassword: "user"},
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if _, err := pageCount(pdf, "user"); err != nil {
+		t.Errorf("the watermarked PDF must be encrypted after: %v", err)
+	}
+
+	_, err = gen.Render(context.Background(), strings.NewReader("<p>Salario</p>"), RenderOptions{Watermarks: []Watermark{{}}})
+	if err == nil {
+		t.Error("an invalid watermark must be rejected")
+	}
+}
+
+func TestDIAN_watermarks(t *testing.T) {
+	item := DIANForm220Relation{DIANForm220: DIANForm220{Year: 2022, Records: json.RawMessage(`{}`)}}
+	dian := NewDIAN(false)
+	dian.Watermarks = []Watermark{{Text: "BORRADOR"}}
+
+	pdf, err := dian.CreateDIANForm220(context.Background(), DIANForms220Relation{item})
+	if err != nil {
+		t.Fatalf("Got an unexpected error: %v", err)
+	}
+	if !hasWatermarks(t, pdf) {
+		t.Error("the form must have the watermark")
+	}
+
+	req := requestDIANForm220{Data: DIANForms220Relation{item}}
+	key := dianCacheKey(req)
+	req.Watermarks = dian.Watermarks
+	if dianCacheKey(req) == key {
+		t.Error("the watermarks must change the cache key")
+	}
+}
+
+func TestHandler_watermarks(t *testing.T) {
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithDefaultBackend(BackendMaroto))})
+
+	rec := doJSON(e, http.MethodPost, "/dian-form-220", `{"watermarks": [{"text": "COPIA"}], "data": [{"year": 2022, "rows": {}}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": "<p>a</p>", "options": {"watermarks": [{"text": "COPIA", "position": "middle"}]}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an invalid watermark: %s", rec.Code, rec.Body)
+	}
+}
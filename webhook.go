@@ -0,0 +1,241 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// HeaderWebhookSignature is the HMAC-SHA256 of the timestamp and the body
+	// of the event, as "sha256=<hex>".
+	HeaderWebhookSignature = "X-Webhook-Signature"
+	// HeaderWebhookTimestamp is the unix time of the delivery, it's part of
+	// the signature so an old event can't be replayed.
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+
+	// Status of the webhook of a job.
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+
+	DefaultWebhookInlineLimit = 1 << 20
+	DefaultWebhookAttempts    = 5
+	DefaultWebhookBackoff     = time.Second
+	DefaultWebhookTimeout     = 10 * time.Second
+)
+
+var errCallbackAddress = errors.New("the callback can't be in a local network")
+
+// WebhookEvent is the body posted to the callback URL when a job finishes.
+// The result is in Data when it's smaller than the inline limit, otherwise it
+// must be downloaded from the DownloadURL.
+type WebhookEvent struct {
+	JobID       string    `json:"job_id"`
+	Type        string    `json:"type"`
+	Status      string    `json:"status"`
+	ErrorCode   string    `json:"error_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int       `json:"size,omitempty"`
+	DownloadURL string    `json:"download_url,omitempty"`
+	Data        []byte    `json:"data,omitempty"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// WebhookDelivery is an attempt to post the event to the callback URL.
+type WebhookDelivery struct {
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// JobWebhook is the state of the notification of a job.
+type JobWebhook struct {
+	URL        string            `json:"url"`
+	Status     string            `json:"status"`
+	Deliveries []WebhookDelivery `json:"deliveries,omitempty"`
+}
+
+// Webhooks posts the events of the jobs to their callback URL, signed with a
+// shared secret. A failed delivery is retried with exponential backoff.
+type Webhooks struct {
+	secret      []byte
+	baseURL     string
+	inlineLimit int
+	attempts    int
+	backoff     time.Duration
+	client      *http.Client
+	// allowPrivate lets the callbacks be in the private networks, for the
+	// tests.
+	allowPrivate bool
+}
+
+// NewWebhooks returns the webhooks signed with the secret. The baseURL is the
+// public URL of the service, used for the download links. The results up to
+// inlineLimit bytes are sent in the event, 0 never sends them. The callbacks
+// can't be in the loopback, private or link-local networks, the addresses
+// are checked when they are dialed so a DNS change can't bypass it.
+func NewWebhooks(secret, baseURL string, inlineLimit int) *Webhooks {
+	w := &Webhooks{
+		secret:      []byte(secret),
+		baseURL:     baseURL,
+		inlineLimit: inlineLimit,
+		attempts:    DefaultWebhookAttempts,
+		backoff:     DefaultWebhookBackoff,
+	}
+
+	dialer := &net.Dialer{Timeout: DefaultWebhookTimeout, Control: w.checkAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would resolve the callback, its address couldn't be checked.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	w.client = &http.Client{Timeout: DefaultWebhookTimeout, Transport: transport}
+
+	return w
+}
+
+// checkAddress rejects the connections to the addresses of the local
+// networks, like the cloud metadata at 169.254.169.254.
+func (w *Webhooks) checkAddress(_, address string, _ syscall.RawConn) error {
+	if w.allowPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errCallbackAddress, host)
+	}
+
+	return nil
+}
+
+// SignWebhook returns the signature of an event, the receivers compute it
+// with the same secret and compare it with hmac.Equal.
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateCallbackURL only accepts absolute http and https URLs.
+func validateCallbackURL(callback string) error {
+	u, err := url.Parse(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrorProcess{Msg: fmt.Sprintf("the callback URL %q must be an absolute http or https URL", callback)}
+	}
+
+	return nil
+}
+
+// event creates the event of a finished job.
+func (w *Webhooks) event(job Job, result []byte) WebhookEvent {
+	event := WebhookEvent{
+		JobID:       job.ID,
+		Type:        job.Type,
+		Status:      job.Status,
+		ErrorCode:   job.ErrorCode,
+		Error:       job.Error,
+		ContentType: job.ContentType,
+		Size:        job.Size,
+	}
+	if job.FinishedAt != nil {
+		event.FinishedAt = *job.FinishedAt
+	}
+	if job.Status != JobDone {
+		return event
+	}
+
+	if w.baseURL != "" {
+		event.DownloadURL = w.baseURL + "/jobs/" + job.ID + "/result"
+	}
+	if len(result) <= w.inlineLimit {
+		event.Data = result
+	}
+
+	return event
+}
+
+// deliver posts the event until the callback responds 2xx or the attempts
+// are exhausted, every attempt is passed to record.
+func (w *Webhooks) deliver(ctx context.Context, callback string, event WebhookEvent, record func(WebhookDelivery)) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		delivery := w.post(ctx, callback, body)
+		delivery.Attempt = attempt
+		record(delivery)
+		if delivery.Error == "" {
+			return nil
+		}
+		if attempt >= w.attempts {
+			return fmt.Errorf("the webhook failed after %d attempts: %s", attempt, delivery.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery, a response that isn't 2xx is an error.
+func (w *Webhooks) post(ctx context.Context, callback string, body []byte) (delivery WebhookDelivery) {
+	delivery.At = time.Now()
+	defer func() {
+		delivery.DurationMS = time.Since(delivery.At).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	timestamp := strconv.FormatInt(delivery.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, SignWebhook(w.secret, timestamp, body))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	delivery.StatusCode = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		delivery.Error = fmt.Sprintf("the callback responded %d", res.StatusCode)
+	}
+
+	return delivery
+}
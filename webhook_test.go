@@ -0,0 +1,177 @@
+package gohtmltopdf
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJobs_webhook(t *testing.T) {
+	secret := []byte("shared-secret")
+
+	var mu sync.Mutex
+	var events []WebhookEvent
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature := SignWebhook(secret, r.Header.Get(HeaderWebhookTimestamp), body)
+		if !hmac.Equal([]byte(signature), []byte(r.Header.Get(HeaderWebhookSignature))) {
+			t.Errorf("invalid signature %q", r.Header.Get(HeaderWebhookSignature))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		event := WebhookEvent{}
+		_ = json.Unmarshal(body, &event)
+		events = append(events, event)
+		// The first attempt fails to test the retries.
+		if len(events) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer callback.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	webhooks := NewWebhooks(string(secret), "https://pdf.example.com", DefaultWebhookInlineLimit)
+	webhooks.backoff = time.Millisecond
+	webhooks.allowPrivate = true
+	gen := NewGenerator(WithDefaultBackend(BackendMaroto))
+	jobs := NewJobs(gen, JobsConfig{Pool: NewPool(1), Webhooks: webhooks})
+	if err := jobs.Start(ctx); err != nil {
+		t.Fatalf("Got an unexpected error starting the jobs: %v", err)
+	}
+
+	job, err := jobs.Submit(context.Background(), JobRequest{
+		Type:        EndpointHTMLToPDF,
+		Request:     json.RawMessage(`{"data": "<h1>Hola mundo</h1>"}`),
+		CallbackURL: callback.URL,
+	})
+	if err != nil {
+		t.Fatalf("Got an unexpected error submitting the job: %v", err)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		job, _ = jobs.Get(job.ID)
+		if job.Webhook.Status != WebhookPending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if job.Webhook.Status != WebhookDelivered {
+		t.Fatalf("webhook status = %q, want %q", job.Webhook.Status, WebhookDelivered)
+	}
+	if len(job.Webhook.Deliveries) != 2 {
+		t.Fatalf("deliveries = %d, want 2", len(job.Webhook.Deliveries))
+	}
+	if d := job.Webhook.Deliveries[0]; d.StatusCode != http.StatusInternalServerError || d.Error == "" {
+		t.Errorf("the first delivery must fail: %+v", d)
+	}
+	if d := job.Webhook.Deliveries[1]; d.Attempt != 2 || d.StatusCode != http.StatusOK || d.Error != "" {
+		t.Errorf("the second delivery must succeed: %+v", d)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	event := events[len(events)-1]
+	if event.JobID != job.ID || event.Status != JobDone || event.Size != job.Size {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.DownloadURL != "https://pdf.example.com/jobs/"+job.ID+"/result" {
+		t.Errorf("download URL = %q", event.DownloadURL)
+	}
+	if !bytes.HasPrefix(event.Data, []byte("%PDF")) {
+		t.Errorf("the event must have the PDF inline")
+	}
+}
+
+func TestWebhooks_deliverFailed(t *testing.T) {
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer callback.Close()
+
+	webhooks := NewWebhooks("secret", "", 0)
+	webhooks.backoff = time.Millisecond
+	webhooks.attempts = 3
+	webhooks.allowPrivate = true
+
+	var deliveries []WebhookDelivery
+	err := webhooks.deliver(context.Background(), callback.URL, WebhookEvent{JobID: "1"}, func(d WebhookDelivery) {
+		deliveries = append(deliveries, d)
+	})
+	if err == nil {
+		t.Fatalf("the delivery must fail")
+	}
+	if len(deliveries) != 3 {
+		t.Errorf("deliveries = %d, want 3", len(deliveries))
+	}
+	for _, d := range deliveries {
+		if d.DurationMS < 5 {
+			t.Errorf("duration = %dms, want the time of the request", d.DurationMS)
+		}
+	}
+}
+
+func TestWebhooks_localCallback(t *testing.T) {
+	called := false
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer callback.Close()
+
+	webhooks := NewWebhooks("secret", "", DefaultWebhookInlineLimit)
+	webhooks.attempts = 1
+
+	var deliveries []WebhookDelivery
+	err := webhooks.deliver(context.Background(), callback.URL, WebhookEvent{JobID: "1", Data: []byte("%PDF-1.4")}, func(d WebhookDelivery) {
+		deliveries = append(deliveries, d)
+	})
+	if err == nil || called {
+		t.Fatalf("the callback at %s must not be called", callback.URL)
+	}
+	if len(deliveries) != 1 || !strings.Contains(deliveries[0].Error, errCallbackAddress.Error()) {
+		t.Errorf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestWebhooks_event(t *testing.T) {
+	webhooks := NewWebhooks("secret", "", 4)
+
+	event := webhooks.event(Job{ID: "1", Status: JobDone}, []byte("%PDF-1.4"))
+	if event.Data != nil || event.DownloadURL != "" {
+		t.Errorf("a result over the limit must not be inline: %+v", event)
+	}
+
+	event = webhooks.event(Job{ID: "1", Status: JobFailed, ErrorCode: JobErrorRender}, nil)
+	if event.ErrorCode != JobErrorRender || event.Data != nil {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestJobs_callbackRejected(t *testing.T) {
+	e := newJobsServer(t)
+
+	bodies := []string{
+		// The server of the tests doesn't have webhooks.
+		`{"type": "html-to-pdf", "callback_url": "https://example.com/hook", "request": {"data": "<p>a</p>"}}`,
+		`{"type": "html-to-pdf", "callback_url": "file:///etc/passwd", "request": {"data": "<p>a</p>"}}`,
+	}
+	for _, body := range bodies {
+		rec := doJSON(e, http.MethodPost, "/jobs", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+		}
+	}
+}
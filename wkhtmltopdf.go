@@ -4,56 +4,258 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const (
 	Executable     = "wkhtmltopdf"
 	PlaceHolderArg = "-"
+
+	BackendWKHTMLToPDF = "wkhtmltopdf"
 )
 
-type Generator struct {
-	stdIn  *bytes.Buffer
-	stdOut bytes.Buffer
-	stdErr bytes.Buffer
+// args returns the wkhtmltopdf flags of the options.
+func (o RenderOptions) args() []string {
+	var args []string
+	if o.PageSize != "" {
+		args = append(args, "--page-size", o.PageSize)
+	}
+	if o.Orientation != "" {
+		args = append(args, "--orientation", o.Orientation)
+	}
+
+	margins := []struct {
+		flag  string
+		value *float64
+	}{
+		{"--margin-top", o.MarginTop},
+		{"--margin-bottom", o.MarginBottom},
+		{"--margin-left", o.MarginLeft},
+		{"--margin-right", o.MarginRight},
+	}
+	for _, margin := range margins {
+		if margin.value != nil {
+			args = append(args, margin.flag, strconv.FormatFloat(*margin.value, 'f', -1, 64)+"mm")
+		}
+	}
+
+	if o.Grayscale {
+		args = append(args, "--grayscale")
+	}
+	if o.Title != "" {
+		args = append(args, "--title", o.Title)
+	}
+
+	return args
+}
+
+// wkhtmltopdfVersion matches the output of `wkhtmltopdf --version`, e.g.
+// "wkhtmltopdf 0.12.6 (with patched qt)".
+var wkhtmltopdfVersion = regexp.MustCompile(`wkhtmltopdf (\S+)( \(with patched qt\))?`)
+
+// WKHTMLToPDF is a Backend that uses the wkhtmltopdf executable.
+type WKHTMLToPDF struct {
+	binaryPath   string
+	defaultFlags []string
+	tempDir      string
+	metrics      *Metrics
+
+	mu           sync.RWMutex
+	capabilities *Capabilities
+}
+
+// NewWKHTMLToPDF creates the backend. An empty binaryPath resolves the
+// Executable from the PATH.
+func NewWKHTMLToPDF(binaryPath string, defaultFlags []string, tempDir string) *WKHTMLToPDF {
+	if binaryPath == "" {
+		binaryPath = Executable
+	}
+
+	return &WKHTMLToPDF{
+		binaryPath:   binaryPath,
+		defaultFlags: defaultFlags,
+		tempDir:      tempDir,
+	}
+}
+
+func (w *WKHTMLToPDF) Name() string {
+	return BackendWKHTMLToPDF
+}
+
+// DetectCapabilities runs `wkhtmltopdf --version` to know if the executable
+// exists and if it's the patched Qt build, which is needed for headers,
+// footers and the table of contents. After it, Render rejects the options
+// that the installed build can't do.
+func (w *WKHTMLToPDF) DetectCapabilities(ctx context.Context) Capabilities {
+	caps := Capabilities{Path: w.binaryPath, Features: []string{}}
+	defer func() {
+		w.mu.Lock()
+		w.capabilities = &caps
+		w.mu.Unlock()
+	}()
+
+	path, err := exec.LookPath(w.binaryPath)
+	if err != nil {
+		caps.Error = err.Error()
+		return caps
+	}
+	caps.Path = path
+
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		caps.Error = fmt.Sprintf("can't get the version: %v", err)
+		return caps
+	}
+
+	matches := wkhtmltopdfVersion.FindStringSubmatch(string(out))
+	if matches == nil {
+		caps.Error = fmt.Sprintf("unexpected version output: %q", strings.TrimSpace(string(out)))
+		return caps
+	}
+
+	caps.Available = true
+	caps.Version = matches[1]
+	if matches[2] != "" {
+		caps.Features = []string{FeatureHeaderFooter, FeatureTOC}
+	}
+
+	return caps
 }
 
-func NewGenerator(data *bytes.Buffer) Generator {
-	return Generator{
-		stdIn:  data,
-		stdOut: bytes.Buffer{},
-		stdErr: bytes.Buffer{},
+// checkCapabilities validates the options against the detected capabilities.
+// Without a detection every option is allowed.
+func (w *WKHTMLToPDF) checkCapabilities(options RenderOptions) error {
+	w.mu.RLock()
+	caps := w.capabilities
+	w.mu.RUnlock()
+	if caps == nil {
+		return nil
 	}
+
+	if !caps.Available {
+		return fmt.Errorf("%w: wkhtmltopdf: %s", ErrBackendUnavailable, caps.Error)
+	}
+	if options.needsPatchedQt() && !(caps.Has(FeatureHeaderFooter) && caps.Has(FeatureTOC)) {
+		return ErrorProcess{Msg: fmt.Sprintf("the option %s needs wkhtmltopdf with patched qt, the installed version doesn't support it", options.patchedQtOption())}
+	}
+
+	return nil
 }
 
-func (g Generator) run(ctx context.Context) ([]byte, error) {
+// Render creates a PDF from the HTML read from input.
+func (w *WKHTMLToPDF) Render(ctx context.Context, input io.Reader, options RenderOptions) ([]byte, error) {
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.checkCapabilities(options)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string{}, w.defaultFlags...)
+	args = append(args, options.args()...)
+
+	// The header and footer must be files, they are removed after the render.
+	htmlFiles := []struct {
+		flag    string
+		content string
+	}{
+		{"--header-html", options.HeaderHTML},
+		{"--footer-html", options.FooterHTML},
+	}
+	for _, htmlFile := range htmlFiles {
+		if htmlFile.content == "" {
+			continue
+		}
+
+		path, err := w.writeTempHTML(htmlFile.content)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(path)
+
+		args = append(args, htmlFile.flag, path)
+	}
+
+	if options.TOC {
+		args = append(args, "toc")
+	}
+
 	// The wkhtmltopdf executable needs to know the source and destination, we can use `-`
 	// for stdin and stdout. Then we handle the stdin/stdout to save in memory the process.
-	cmd := exec.CommandContext(ctx, Executable, PlaceHolderArg, PlaceHolderArg)
-	cmd.Stdin = g.stdIn
-	cmd.Stderr = &g.stdErr
-	cmd.Stdout = &g.stdOut
+	args = append(args, PlaceHolderArg, PlaceHolderArg)
+
+	ctx, span := tracer().Start(ctx, "wkhtmltopdf")
+	defer span.End()
 
-	err := cmd.Run()
+	// The stderr has the progress of wkhtmltopdf, its stages are events of the span.
+	stdOut := bytes.Buffer{}
+	stdErr := &stageRecorder{span: span}
+
+	cmd := exec.CommandContext(ctx, w.binaryPath, args...)
+	cmd.Stdin = input
+	cmd.Stderr = stdErr
+	cmd.Stdout = &stdOut
+	if w.tempDir != "" {
+		cmd.Env = append(os.Environ(), "TMPDIR="+w.tempDir)
+	}
+
+	err = cmd.Run()
+	w.metrics.ObserveProcess(cmd.ProcessState)
+	if cmd.ProcessState != nil {
+		span.SetAttributes(
+			attribute.Int("process.exit_code", cmd.ProcessState.ExitCode()),
+			attribute.Int64("process.cpu_user_ms", cmd.ProcessState.UserTime().Milliseconds()),
+		)
+	}
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
 		ctxErr := ctx.Err()
 		if ctxErr != nil {
 			return nil, ctxErr
 		}
 
-		if &g.stdErr != nil {
-			errStr := g.stdErr.String()
-			if strings.TrimSpace(errStr) != "" {
-				return nil, errors.New(errStr)
-			}
+		errStr := stdErr.String()
+		if strings.TrimSpace(errStr) != "" {
+			return nil, errors.New(errStr)
 		}
 
 		return nil, err
 	}
 
-	return g.stdOut.Bytes(), nil
+	return stdOut.Bytes(), nil
+}
+
+// writeTempHTML writes the content in a temporary HTML file and returns its path.
+func (w *WKHTMLToPDF) writeTempHTML(content string) (string, error) {
+	f, err := os.CreateTemp(w.tempDir, "gohtmltopdf-*.html")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(content)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
 }
 
 // writeFile is only for test proposes. --*Don´t use it*--
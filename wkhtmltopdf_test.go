@@ -1,9 +1,17 @@
 package gohtmltopdf
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/labstack/echo/v4"
 )
 
 const html = `
@@ -23,10 +31,10 @@ const html = `
 </html>
 `
 
-func Test_run(t *testing.T) {
-	src := bytes.NewBufferString(html)
-	gen := NewGenerator(src)
-	data, err := gen.run(context.Background())
+func TestGenerator_Render(t *testing.T) {
+	src := strings.NewReader(html)
+	gen := NewGenerator()
+	data, err := gen.Render(context.Background(), src, RenderOptions{})
 	if err != nil {
 		t.Fatalf("Got an unexpected error generating pdf: %v", err)
 	}
@@ -36,3 +44,107 @@ func Test_run(t *testing.T) {
 		t.Fatalf("Got an unexepected error writing file: %v", err)
 	}
 }
+
+func TestRenderOptions_args(t *testing.T) {
+	margin := 12.5
+	opts := RenderOptions{PageSize: "Letter", Orientation: "Landscape", MarginTop: &margin, Grayscale: true, Title: "Reporte"}
+	want := []string{"--page-size", "Letter", "--orientation", "Landscape", "--margin-top", "12.5mm", "--grayscale", "--title", "Reporte"}
+	if got := opts.args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("args() = %v, want %v", got, want)
+	}
+}
+
+func TestRenderOptions_Validate(t *testing.T) {
+	negative := -1.0
+	tests := []struct {
+		name    string
+		opts    RenderOptions
+		wantErr bool
+	}{
+		{"empty", RenderOptions{}, false},
+		{"valid", RenderOptions{PageSize: "a4", Orientation: "portrait"}, false},
+		{"page size", RenderOptions{PageSize: "B9"}, true},
+		{"orientation", RenderOptions{Orientation: "Diagonal"}, true},
+		{"margin", RenderOptions{MarginLeft: &negative}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.As(err, &ErrorProcess{}) {
+				t.Errorf("Validate() error must be an ErrorProcess, got %T", err)
+			}
+		})
+	}
+}
+
+// fakeWKHTMLToPDF writes an executable that prints the version like wkhtmltopdf.
+func fakeWKHTMLToPDF(t *testing.T, version string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wkhtmltopdf")
+	script := fmt.Sprintf("#!/bin/sh\necho %q\n", version)
+	err := os.WriteFile(path, []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("Got an unexpected error writing the fake executable: %v", err)
+	}
+
+	return path
+}
+
+func TestWKHTMLToPDF_DetectCapabilities(t *testing.T) {
+	tests := []struct {
+		name          string
+		binaryPath    string
+		wantAvailable bool
+		wantVersion   string
+		wantPatched   bool
+	}{
+		{"patched", fakeWKHTMLToPDF(t, "wkhtmltopdf 0.12.6 (with patched qt)"), true, "0.12.6", true},
+		{"unpatched", fakeWKHTMLToPDF(t, "wkhtmltopdf 0.12.6"), true, "0.12.6", false},
+		{"unexpected output", fakeWKHTMLToPDF(t, "something else"), false, "", false},
+		{"missing", filepath.Join(t.TempDir(), "missing"), false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps := NewWKHTMLToPDF(tt.binaryPath, nil, "").DetectCapabilities(context.Background())
+			if caps.Available != tt.wantAvailable {
+				t.Errorf("Available = %t, want %t (error: %s)", caps.Available, tt.wantAvailable, caps.Error)
+			}
+			if caps.Version != tt.wantVersion {
+				t.Errorf("Version = %q, want %q", caps.Version, tt.wantVersion)
+			}
+			if caps.Has(FeatureHeaderFooter) != tt.wantPatched || caps.Has(FeatureTOC) != tt.wantPatched {
+				t.Errorf("Features = %v, want patched %t", caps.Features, tt.wantPatched)
+			}
+		})
+	}
+}
+
+func TestWKHTMLToPDF_Render_capabilities(t *testing.T) {
+	w := NewWKHTMLToPDF(fakeWKHTMLToPDF(t, "wkhtmltopdf 0.12.6"), nil, "")
+	w.DetectCapabilities(context.Background())
+
+	for option, opts := range map[string]RenderOptions{"header_html": {HeaderHTML: "<p>Header</p>"}, "footer_html": {FooterHTML: "<p>Footer</p>"}, "toc": {TOC: true}} {
+		_, err := w.Render(context.Background(), strings.NewReader(html), opts)
+		if !errors.As(err, &ErrorProcess{}) || !strings.Contains(err.Error(), option) {
+			t.Errorf("Render(%+v) error = %v, want an ErrorProcess about %s", opts, err, option)
+		}
+	}
+
+	missing := NewWKHTMLToPDF(filepath.Join(t.TempDir(), "missing"), nil, "")
+	missing.DetectCapabilities(context.Background())
+	_, err := missing.Render(context.Background(), strings.NewReader(html), RenderOptions{})
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("Render() error = %v, want %v", err, ErrBackendUnavailable)
+	}
+
+	e := echo.New()
+	Router(e, RouterConfig{Auth: testAPIKeys(), Generator: NewGenerator(WithBackend(missing))})
+	rec := doJSON(e, http.MethodPost, "/html-to-pdf", `{"data": "<p>a</p>"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}